@@ -0,0 +1,592 @@
+// Package client is a Go client of TechnoMessenger protocol.
+//
+// Requests and answers use Clt* and Srv* structs of server package, so
+// protocol changes stay in sync. Answers of protocol have no request ids,
+// so Client sends one request at a time and waits for its answer.
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"../server"
+)
+
+// Errors of client
+var (
+	ErrClosed       = errors.New("Client is closed")
+	ErrDisconnected = errors.New("Connection is lost")
+	ErrTimeout      = errors.New("Answer timeout")
+)
+
+// Error is an error answer of server
+type Error struct {
+	Action     string
+	Status     int    // Error code of server (server.ErrInvalidPass, ...)
+	Text       string // Text of error from server
+	RetryAfter int    // Seconds before retry of rate limited request
+}
+
+func (e *Error) Error() string {
+	return e.Action + ": " + e.Text + " (status " + strconv.Itoa(e.Status) + ")"
+}
+
+// Options are settings of Client
+type Options struct {
+	Timeout    time.Duration // Max time of waiting for connection or answer
+	Reconnect  bool          // Reconnect and resume session after lost connection
+	MinBackoff time.Duration // Pause before first reconnect, it doubles after each failure
+	MaxBackoff time.Duration // Max pause between reconnects
+	EventQueue int           // Size of buffer of Messages channel
+
+	Dial func(addr string) (net.Conn, error) // Dialer of connections (nil - TCP)
+}
+
+// DefaultOptions returns settings used by Dial
+func DefaultOptions() Options {
+	return Options{
+		Timeout:    10 * time.Second,
+		Reconnect:  true,
+		MinBackoff: 500 * time.Millisecond,
+		MaxBackoff: 30 * time.Second,
+		EventQueue: 256,
+	}
+}
+
+// Event is a message of server which is not an answer to request (ev_message, ev_system, ...)
+type Event struct {
+	Action string
+	Data   json.RawMessage
+}
+
+// call is a request waiting for its answers
+type call struct {
+	expect  []string          // Actions of answers in order of arrival
+	answers []json.RawMessage // Data of received answers
+	done    chan error
+}
+
+// Client is a connection to server with typed requests
+type Client struct {
+	addr string
+	opts Options
+
+	mutex   sync.Mutex    // Guards fields below
+	conn    net.Conn      // Current connection (nil - reconnecting)
+	ready   chan struct{} // Closed when connection is ready for requests
+	pending *call         // Request waiting for answer
+	login   string        // Credentials of session for resume after reconnect
+	pass    string
+	session server.SrvStatusAuthMessage
+	motd    string
+	closed  bool
+	err     error // Reason of closing
+
+	requests   sync.Mutex // Only one request is sent at a time
+	writeMutex sync.Mutex // Guards writing to connection
+
+	eventMutex   sync.Mutex    // Guards events and finished
+	events       []Event       // Events waiting for handlers, reading never waits for handlers
+	finished     bool          // No more events, dispatch stops after the rest
+	wake         chan struct{} // Signals new events or finish to dispatch
+	handlers     map[string][]func(json.RawMessage)
+	handlerMutex sync.RWMutex  // Guards handlers
+	quit         chan struct{} // Closed by Close
+	done         chan struct{} // Closed when client stops
+	finishOnce   sync.Once
+	closeOnce    sync.Once
+}
+
+// Dial connects to server with DefaultOptions
+func Dial(addr string) (*Client, error) {
+	return DialOptions(addr, DefaultOptions())
+}
+
+// DialOptions connects to server with custom settings
+func DialOptions(addr string, opts Options) (*Client, error) {
+	if opts.Dial == nil {
+		timeout := opts.Timeout
+		opts.Dial = func(addr string) (net.Conn, error) {
+			return net.DialTimeout("tcp", addr, timeout)
+		}
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = DefaultOptions().MinBackoff
+	}
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = opts.MinBackoff
+	}
+	c := &Client{
+		addr:     addr,
+		opts:     opts,
+		ready:    make(chan struct{}),
+		wake:     make(chan struct{}, 1),
+		handlers: make(map[string][]func(json.RawMessage)),
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	conn, dec, err := c.connect()
+	if err != nil {
+		return nil, err
+	}
+	go c.dispatch()
+	c.setConn(conn, dec)
+	return c, nil
+}
+
+// connect dials server and reads welcome message
+func (c *Client) connect() (net.Conn, *json.Decoder, error) {
+	conn, err := c.opts.Dial(c.addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	dec := json.NewDecoder(conn)
+	var welcome server.SrvWelcomeMessage
+	c.setDeadline(conn)
+	err = dec.Decode(&welcome)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if welcome.Action != "welcome" {
+		conn.Close()
+		return nil, nil, errors.New("Invalid welcome message")
+	}
+	c.mutex.Lock()
+	c.motd = welcome.Message
+	c.mutex.Unlock()
+	return conn, dec, nil
+}
+
+// setDeadline limits time of reading of answer
+func (c *Client) setDeadline(conn net.Conn) {
+	if c.opts.Timeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(c.opts.Timeout))
+	}
+}
+
+// setConn makes connection current and starts reading it
+func (c *Client) setConn(conn net.Conn, dec *json.Decoder) {
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+		conn.Close()
+		c.finish()
+		return
+	}
+	c.conn = conn
+	close(c.ready)
+	c.mutex.Unlock()
+	go c.read(conn, dec)
+}
+
+// read reads messages of connection till it is lost
+func (c *Client) read(conn net.Conn, dec *json.Decoder) {
+	for {
+		var m server.SrvMessage
+		if err := dec.Decode(&m); err != nil {
+			c.connLost(conn)
+			return
+		}
+		c.receive(conn, m)
+	}
+}
+
+// receive passes message to waiting request or to event handlers
+func (c *Client) receive(conn net.Conn, m server.SrvMessage) {
+	if m.Action == "ping" {
+		c.write(conn, []byte(`{"action":"pong","data":{}}`))
+		return
+	}
+	if !strings.HasPrefix(m.Action, "ev_") && c.answer(m) {
+		return
+	}
+	c.push(Event{Action: m.Action, Data: m.RawData})
+}
+
+// push adds event to queue of dispatch, handler waiting for answer doesn't stop reading
+func (c *Client) push(ev Event) {
+	c.eventMutex.Lock()
+	if !c.finished {
+		c.events = append(c.events, ev)
+	}
+	c.eventMutex.Unlock()
+	c.signal()
+}
+
+// signal wakes dispatch up
+func (c *Client) signal() {
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// answer passes message to waiting request. Errors of rate limit of all
+// actions ("*") and of invalid requests ("unknown") go to any request.
+func (c *Client) answer(m server.SrvMessage) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	cl := c.pending
+	if cl == nil {
+		return false
+	}
+	if m.Action != cl.expect[len(cl.answers)] && m.Action != server.AnyAction && m.Action != "unknown" {
+		return false
+	}
+	cl.answers = append(cl.answers, m.RawData)
+	var status server.SrvRateLimitedMessage
+	json.Unmarshal(m.RawData, &status)
+	if status.Status != server.ErrOK {
+		c.pending = nil
+		cl.done <- &Error{Action: m.Action, Status: status.Status, Text: status.Error, RetryAfter: status.RetryAfter}
+	} else if len(cl.answers) == len(cl.expect) {
+		c.pending = nil
+		cl.done <- nil
+	}
+	return true
+}
+
+// write writes request to connection
+func (c *Client) write(conn net.Conn, data []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	if c.opts.Timeout > 0 {
+		conn.SetWriteDeadline(time.Now().Add(c.opts.Timeout))
+	}
+	_, err := conn.Write(data)
+	return err
+}
+
+// connLost fails waiting request and starts reconnect
+func (c *Client) connLost(conn net.Conn) {
+	conn.Close()
+	c.mutex.Lock()
+	if c.conn != conn {
+		c.mutex.Unlock()
+		return
+	}
+	c.conn = nil
+	c.ready = make(chan struct{})
+	if c.pending != nil {
+		c.pending.done <- ErrDisconnected
+		c.pending = nil
+	}
+	stop := c.closed || !c.opts.Reconnect
+	if !c.closed && !c.opts.Reconnect {
+		c.closed = true
+		c.err = ErrDisconnected
+	}
+	c.mutex.Unlock()
+
+	if stop {
+		c.finish()
+		return
+	}
+	go c.reconnect()
+}
+
+// reconnect connects to server again and resumes session.
+// Server keeps messages of user while he is offline and sends them after auth.
+func (c *Client) reconnect() {
+	backoff := c.opts.MinBackoff
+	for {
+		select {
+		case <-c.quit:
+			c.finish()
+			return
+		case <-time.After(backoff):
+		}
+		conn, dec, err := c.connect()
+		if err == nil {
+			err = c.resume(conn, dec)
+			if err != nil {
+				conn.Close()
+			}
+		}
+		if err == nil {
+			c.setConn(conn, dec)
+			return
+		}
+		if e, ok := err.(*Error); ok && e.Status != server.ErrRateLimited {
+			// Credentials are not valid anymore
+			c.mutex.Lock()
+			c.closed = true
+			c.err = err
+			c.mutex.Unlock()
+			c.finish()
+			return
+		}
+		backoff *= 2
+		if backoff > c.opts.MaxBackoff {
+			backoff = c.opts.MaxBackoff
+		}
+	}
+}
+
+// resume authenticates new connection with credentials of session
+func (c *Client) resume(conn net.Conn, dec *json.Decoder) error {
+	c.mutex.Lock()
+	login, pass := c.login, c.pass
+	c.mutex.Unlock()
+	if login == "" {
+		return nil
+	}
+	req, err := request("auth", server.CltAuth{Login: login, Pass: pass})
+	if err != nil {
+		return err
+	}
+	if err := c.write(conn, req); err != nil {
+		return err
+	}
+	defer conn.SetReadDeadline(time.Time{})
+	for {
+		c.setDeadline(conn)
+		var m server.SrvMessage
+		if err := dec.Decode(&m); err != nil {
+			return err
+		}
+		if m.Action != "auth" && m.Action != server.AnyAction {
+			continue
+		}
+		var answer server.SrvStatusAuthMessage
+		if err := json.Unmarshal(m.RawData, &answer); err != nil {
+			return err
+		}
+		if answer.Status != server.ErrOK {
+			return &Error{Action: m.Action, Status: answer.Status, Text: answer.Error}
+		}
+		c.mutex.Lock()
+		c.session = answer
+		c.mutex.Unlock()
+		return nil
+	}
+}
+
+// finish stops delivery of events when client is closed
+func (c *Client) finish() {
+	c.finishOnce.Do(func() {
+		c.eventMutex.Lock()
+		c.finished = true
+		c.eventMutex.Unlock()
+		c.signal()
+	})
+}
+
+// next returns next event, result is false when queue is empty and client is finished
+func (c *Client) next() (Event, bool) {
+	for {
+		c.eventMutex.Lock()
+		if len(c.events) > 0 {
+			ev := c.events[0]
+			c.events[0] = Event{}
+			c.events = c.events[1:]
+			c.eventMutex.Unlock()
+			return ev, true
+		}
+		finished := c.finished
+		c.eventMutex.Unlock()
+		if finished {
+			return Event{}, false
+		}
+		<-c.wake
+	}
+}
+
+// dispatch calls handlers of events
+func (c *Client) dispatch() {
+	for {
+		ev, ok := c.next()
+		if !ok {
+			break
+		}
+		c.handlerMutex.RLock()
+		handlers := c.handlers[ev.Action]
+		c.handlerMutex.RUnlock()
+		for _, h := range handlers {
+			h(ev.Data)
+		}
+	}
+	close(c.done)
+}
+
+// Handle adds handler of events with action (e.g. "ev_message").
+// Handlers are called in order of events in separate goroutine and may send requests.
+func (c *Client) Handle(action string, handler func(data json.RawMessage)) {
+	c.handlerMutex.Lock()
+	defer c.handlerMutex.Unlock()
+
+	c.handlers[action] = append(c.handlers[action], handler)
+}
+
+// OnMessage adds handler of new messages
+func (c *Client) OnMessage(handler func(m server.EvSrvMessage)) {
+	c.Handle("ev_message", func(data json.RawMessage) {
+		var m server.EvSrvMessage
+		if json.Unmarshal(data, &m) == nil {
+			handler(m)
+		}
+	})
+}
+
+// Messages returns channel of new messages. It has to be read, otherwise
+// handlers of all events wait when its buffer is full. It is closed with client.
+func (c *Client) Messages() <-chan server.EvSrvMessage {
+	messages := make(chan server.EvSrvMessage, c.opts.EventQueue)
+	c.OnMessage(func(m server.EvSrvMessage) {
+		messages <- m
+	})
+	go func() {
+		<-c.done
+		close(messages)
+	}()
+	return messages
+}
+
+// Done returns channel which is closed when client stops
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns reason of stop of client
+func (c *Client) Err() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.err
+}
+
+// Close closes connection and stops reconnects
+func (c *Client) Close() error {
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.err = ErrClosed
+	conn := c.conn
+	c.mutex.Unlock()
+
+	c.closeOnce.Do(func() { close(c.quit) })
+	if conn != nil {
+		return conn.Close()
+	}
+	return nil
+}
+
+// MOTD returns message of the day from welcome message
+func (c *Client) MOTD() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.motd
+}
+
+// Session returns answer of last successful auth
+func (c *Client) Session() server.SrvStatusAuthMessage {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.session
+}
+
+// request builds request of protocol
+func request(action string, data interface{}) ([]byte, error) {
+	if data == nil {
+		data = struct{}{}
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(server.CltRequest{Action: action, RawData: raw})
+}
+
+// waitReady waits for connection while client is reconnecting
+func (c *Client) waitReady(timer <-chan time.Time) (net.Conn, error) {
+	for {
+		c.mutex.Lock()
+		conn, ready, closed, err := c.conn, c.ready, c.closed, c.err
+		c.mutex.Unlock()
+		if closed {
+			return nil, err
+		}
+		if conn != nil {
+			return conn, nil
+		}
+		select {
+		case <-ready:
+		case <-c.done:
+		case <-timer:
+			return nil, ErrTimeout
+		}
+	}
+}
+
+// roundTrip sends request and waits for answers with expected actions
+// (by default one answer with action of request)
+func (c *Client) roundTrip(action string, data interface{}, expect ...string) ([]json.RawMessage, error) {
+	if len(expect) == 0 {
+		expect = []string{action}
+	}
+	req, err := request(action, data)
+	if err != nil {
+		return nil, err
+	}
+
+	c.requests.Lock()
+	defer c.requests.Unlock()
+
+	var timer <-chan time.Time
+	if c.opts.Timeout > 0 {
+		t := time.NewTimer(c.opts.Timeout)
+		defer t.Stop()
+		timer = t.C
+	}
+	conn, err := c.waitReady(timer)
+	if err != nil {
+		return nil, err
+	}
+	cl := &call{expect: expect, done: make(chan error, 1)}
+	c.mutex.Lock()
+	c.pending = cl
+	c.mutex.Unlock()
+
+	if err := c.write(conn, req); err != nil {
+		// Reader finds lost connection and fails request
+		conn.Close()
+	}
+	select {
+	case err = <-cl.done:
+	case <-timer:
+		c.mutex.Lock()
+		if c.pending == cl {
+			c.pending = nil
+		}
+		c.mutex.Unlock()
+		return nil, ErrTimeout
+	}
+	return cl.answers, err
+}
+
+// Request sends request with action and decodes data of its answer to answer (nil - answer is ignored).
+// It is used for actions without own method.
+func (c *Client) Request(action string, data interface{}, answer interface{}) error {
+	answers, err := c.roundTrip(action, data)
+	if err != nil {
+		return err
+	}
+	if answer == nil {
+		return nil
+	}
+	return json.Unmarshal(answers[0], answer)
+}
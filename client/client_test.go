@@ -0,0 +1,231 @@
+package client
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"../server"
+)
+
+// startServer starts server on random local port and returns its address
+func startServer(t *testing.T) string {
+	addr, _ := startServerSender(t)
+	return addr
+}
+
+// startServerSender starts server which keeps verification codes in LocalSender
+func startServerSender(t *testing.T) (string, *server.LocalSender) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	config := server.DefaultConfig()
+	config.LogOutput = io.Discard
+	sender := server.NewLocalSender()
+	config.VerifySender = sender
+	s := server.CreateInstanceWithConfig(config)
+	go s.Serve(l)
+	t.Cleanup(func() { l.Close() })
+	return l.Addr().String(), sender
+}
+
+// dialTest connects to server with short timeouts
+func dialTest(t *testing.T, addr string) *Client {
+	return dialTestOptions(t, addr, DefaultOptions())
+}
+
+// dialTestOptions connects to server with short timeouts and custom settings
+func dialTestOptions(t *testing.T, addr string, opts Options) *Client {
+	opts.Timeout = 2 * time.Second
+	opts.MinBackoff = 10 * time.Millisecond
+	c, err := DialOptions(addr, opts)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// TestClientRequests checks typed requests and events
+func TestClientRequests(t *testing.T) {
+	addr, sender := startServerSender(t)
+	c1 := dialTest(t, addr)
+	c2 := dialTest(t, addr)
+	messages := c2.Messages()
+
+	if c1.MOTD() == "" {
+		t.Errorf("Welcome message is empty")
+	}
+	session, err := c1.Register("user1", "pass", "nick1")
+	if err != nil || session.Nick != "nick1" || session.Sid == "" {
+		t.Fatalf("Invalid register %v %v", session, err)
+	}
+	if _, err := c2.Register("user2", "pass", "nick2"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := c1.SetUserInfo(server.CltSetUserInfo{Email: "user1@mail.ru", UserStatus: "online"}); err != nil {
+		t.Errorf("%v", err)
+	}
+	if err := c1.Verify(server.VerifyEmail, "000"); err == nil {
+		t.Errorf("Invalid code is accepted")
+	}
+	if err := c1.Verify(server.VerifyEmail, sender.Code("user1@mail.ru")); err != nil {
+		t.Errorf("%v", err)
+	}
+	if err := c2.AddContact("user1"); err != nil {
+		t.Errorf("%v", err)
+	}
+	list, err := c2.ContactList()
+	if err != nil || len(list) != 1 || list[0].Uid != "user1" {
+		t.Errorf("Invalid contact list %v %v", list, err)
+	}
+	found, err := c2.Import([]server.Contact{{Name: "First", Email: "user1@mail.ru", MyID: "42"}})
+	if err != nil || len(found) != 1 || found[0].MyID != "42" {
+		t.Errorf("Invalid import %v %v", found, err)
+	}
+	info, err := c2.UserInfo("user1")
+	if err != nil || info.UserStatus != "online" {
+		t.Errorf("Invalid user info %v %v", info, err)
+	}
+
+	if err := c1.SendMessage("user2", "hello", server.AttachData{}); err != nil {
+		t.Errorf("%v", err)
+	}
+	select {
+	case m := <-messages:
+		if m.From != "user1" || m.Body != "hello" {
+			t.Errorf("Invalid message %v", m)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Message is not received")
+	}
+	history, err := c2.History("user1", "", 10)
+	if err != nil || len(history) != 1 {
+		t.Errorf("Invalid history %v %v", history, err)
+	}
+	convs, err := c2.Conversations()
+	if err != nil || len(convs) != 1 || convs[0].Uid != "user1" || convs[0].Unread != 1 {
+		t.Errorf("Invalid conversations %v %v", convs, err)
+	}
+	if conv, err := c2.MarkRead("user1", ""); err != nil || conv.Unread != 0 {
+		t.Errorf("Invalid read conversation %v %v", conv, err)
+	}
+	pinned := true
+	if conv, err := c2.SetConversation(server.CltSetConversation{User: "user1", Pinned: &pinned}); err != nil || !conv.Pinned {
+		t.Errorf("Invalid pinned conversation %v %v", conv, err)
+	}
+	synced, err := c2.Sync(0, 0)
+	if err != nil || synced.Reset || len(synced.Events) == 0 || synced.Seq != synced.Events[len(synced.Events)-1].Seq {
+		t.Errorf("Invalid sync %v %v", synced, err)
+	}
+
+	err = c1.SendMessage("unknown", "hello", server.AttachData{})
+	if e, ok := err.(*Error); !ok || e.Status != server.ErrUserNotFound {
+		t.Errorf("Invalid error %v", err)
+	}
+	if err := c1.Ping(); err != nil {
+		t.Errorf("%v", err)
+	}
+}
+
+// TestClientResume checks reconnect and delivery of messages sent while client was offline
+func TestClientResume(t *testing.T) {
+	addr := startServer(t)
+	// Reconnect waits till message is sent
+	var offline sync.Mutex
+	opts := DefaultOptions()
+	opts.Dial = func(addr string) (net.Conn, error) {
+		offline.Lock()
+		defer offline.Unlock()
+		return net.Dial("tcp", addr)
+	}
+	c1 := dialTest(t, addr)
+	c2 := dialTestOptions(t, addr, opts)
+	messages := c2.Messages()
+	c1.Register("user1", "pass", "nick1")
+	c2.Register("user2", "pass", "nick2")
+
+	offline.Lock()
+	c2.mutex.Lock()
+	c2.conn.Close()
+	c2.mutex.Unlock()
+	// Server finds lost connection and keeps message offline
+	time.Sleep(50 * time.Millisecond)
+	if err := c1.SendMessage("user2", "while offline", server.AttachData{}); err != nil {
+		t.Errorf("%v", err)
+	}
+	offline.Unlock()
+	select {
+	case m := <-messages:
+		if m.Body != "while offline" {
+			t.Errorf("Invalid message %v", m)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Message is not received after reconnect")
+	}
+	if err := c2.Ping(); err != nil {
+		t.Errorf("Request after reconnect failed: %v", err)
+	}
+}
+
+// TestClientClose checks closing of client
+func TestClientClose(t *testing.T) {
+	addr := startServer(t)
+	c := dialTest(t, addr)
+	_, err := c.Auth("user", "pass")
+	if e, ok := err.(*Error); !ok || e.Status != server.ErrNeedRegister {
+		t.Errorf("Invalid auth error %v", err)
+	}
+	c.Close()
+	select {
+	case <-c.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("Client is not stopped")
+	}
+	if err := c.Ping(); err != ErrClosed {
+		t.Errorf("Request after close: %v", err)
+	}
+}
+
+// TestClientHandlerRequest checks that handler of event may wait for answer while events come
+func TestClientHandlerRequest(t *testing.T) {
+	addr := startServer(t)
+	c1 := dialTest(t, addr)
+	opts := DefaultOptions()
+	opts.EventQueue = 1
+	c2 := dialTestOptions(t, addr, opts)
+	if _, err := c1.Register("user1", "pass", "nick1"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err := c2.Register("user2", "pass", "nick2"); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// Handler waits till events fill queue, then sends request
+	const count = 5
+	sent := make(chan struct{})
+	answers := make(chan error, count)
+	c2.OnMessage(func(m server.EvSrvMessage) {
+		<-sent
+		answers <- c2.Ping()
+	})
+	for i := 0; i < count; i++ {
+		if err := c1.SendMessage("user2", "hello", server.AttachData{}); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	close(sent)
+	for i := 0; i < count; i++ {
+		select {
+		case err := <-answers:
+			if err != nil {
+				t.Errorf("Request of handler is failed: %v", err)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("Handler is stuck after %d events", i)
+		}
+	}
+}
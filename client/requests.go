@@ -0,0 +1,171 @@
+package client
+
+import (
+	"encoding/json"
+
+	"../server"
+)
+
+// authorize keeps credentials of session for resume after reconnect
+func (c *Client) authorize(login string, pass string, data json.RawMessage) (server.SrvStatusAuthMessage, error) {
+	var session server.SrvStatusAuthMessage
+	if err := json.Unmarshal(data, &session); err != nil {
+		return session, err
+	}
+	c.mutex.Lock()
+	c.login = login
+	c.pass = pass
+	c.session = session
+	c.mutex.Unlock()
+	return session, nil
+}
+
+// Register registers new user, server authenticates him after registration
+func (c *Client) Register(login string, pass string, nick string) (server.SrvStatusAuthMessage, error) {
+	req := server.CltRegister{Nick: nick}
+	req.Login = login
+	req.Pass = pass
+	answers, err := c.roundTrip("register", req, "register", "auth")
+	if err != nil {
+		return server.SrvStatusAuthMessage{}, err
+	}
+	return c.authorize(login, pass, answers[1])
+}
+
+// Auth authenticates user, messages received while he was offline arrive as events
+func (c *Client) Auth(login string, pass string) (server.SrvStatusAuthMessage, error) {
+	answers, err := c.roundTrip("auth", server.CltAuth{Login: login, Pass: pass})
+	if err != nil {
+		return server.SrvStatusAuthMessage{}, err
+	}
+	return c.authorize(login, pass, answers[0])
+}
+
+// Ping checks connection
+func (c *Client) Ping() error {
+	_, err := c.roundTrip("ping", nil, "pong")
+	return err
+}
+
+// UserInfo returns profile of user
+func (c *Client) UserInfo(uid string) (server.SrvUserInfo, error) {
+	var info server.SrvUserInfo
+	err := c.Request("userinfo", server.CltUserInfo{User: uid}, &info)
+	return info, err
+}
+
+// SetUserInfo changes own profile
+func (c *Client) SetUserInfo(info server.CltSetUserInfo) error {
+	return c.Request("setuserinfo", info, nil)
+}
+
+// ContactList returns contacts of user
+func (c *Client) ContactList() ([]server.UserData, error) {
+	var list server.SrvListOfUsers
+	err := c.Request("contactlist", server.CltBaseReq{}, &list)
+	return list.Users, err
+}
+
+// AddContact adds user to contact list
+func (c *Client) AddContact(uid string) error {
+	return c.Request("addcontact", server.CltUidReq{User: uid}, nil)
+}
+
+// DelContact removes user from contact list
+func (c *Client) DelContact(uid string) error {
+	return c.Request("delcontact", server.CltUidReq{User: uid}, nil)
+}
+
+// SendCode requests new verification code of email or phone (server.VerifyEmail, server.VerifyPhone)
+func (c *Client) SendCode(kind string) error {
+	return c.Request("sendcode", server.CltVerify{Kind: kind}, nil)
+}
+
+// Verify confirms email or phone by code, after it other users find user by import
+func (c *Client) Verify(kind string, code string) error {
+	return c.Request("verify", server.CltVerify{Kind: kind, Code: code}, nil)
+}
+
+// Import finds users by emails and phones of contacts
+func (c *Client) Import(contacts []server.Contact) ([]server.UserData, error) {
+	var list server.SrvListOfUsers
+	err := c.Request("import", server.CltImport{Contacts: contacts}, &list)
+	return list.Users, err
+}
+
+// ImportAddressBook finds users of address book in format server.FormatVCard or server.FormatCSV
+func (c *Client) ImportAddressBook(format string, payload string) ([]server.UserData, error) {
+	var list server.SrvListOfUsers
+	err := c.Request("import", server.CltImport{Format: format, Payload: payload}, &list)
+	return list.Users, err
+}
+
+// SendMessage sends message with attachment (empty - no attachment) to user
+func (c *Client) SendMessage(uid string, body string, attach server.AttachData) error {
+	m := server.CltMessage{Body: body, Attach: attach}
+	m.User = uid
+	return c.Send(m)
+}
+
+// Send sends message with all fields (replies, forwards, envelopes)
+func (c *Client) Send(m server.CltMessage) error {
+	return c.Request("message", m, nil)
+}
+
+// EditMessage changes body of own message
+func (c *Client) EditMessage(mid string, body string) error {
+	return c.Request("editmessage", server.CltEditMessage{Mid: mid, Body: body}, nil)
+}
+
+// DeleteMessage deletes message
+func (c *Client) DeleteMessage(mid string) error {
+	return c.Request("deletemessage", server.CltMidReq{Mid: mid}, nil)
+}
+
+// React puts or removes reaction on message
+func (c *Client) React(mid string, emoji string, remove bool) error {
+	return c.Request("react", server.CltReact{Mid: mid, Emoji: emoji, Remove: remove}, nil)
+}
+
+// History returns messages of dialog with user before message (""- the latest)
+func (c *Client) History(uid string, before string, limit int) ([]server.MessageData, error) {
+	var history server.SrvHistory
+	err := c.Request("history", server.CltHistory{User: uid, Before: before, Limit: limit}, &history)
+	return history.Messages, err
+}
+
+// Search finds messages in dialogs of user
+func (c *Client) Search(req server.CltSearch) ([]server.MessageData, error) {
+	var history server.SrvHistory
+	err := c.Request("searchmessages", req, &history)
+	return history.Messages, err
+}
+
+// Conversations returns dialogs of user: pinned ones first, then by last message
+func (c *Client) Conversations() ([]server.ConversationData, error) {
+	var list server.SrvConversations
+	err := c.Request("conversations", server.CltBaseReq{}, &list)
+	return list.List, err
+}
+
+// SetConversation pins, archives or mutes dialog, nil fields stay unchanged
+func (c *Client) SetConversation(req server.CltSetConversation) (server.ConversationData, error) {
+	var conv server.SrvConversation
+	err := c.Request("setconversation", req, &conv)
+	return conv.ConversationData, err
+}
+
+// MarkRead marks messages of dialog as read up to mid (empty - up to last message)
+func (c *Client) MarkRead(uid string, mid string) (server.ConversationData, error) {
+	var conv server.SrvConversation
+	err := c.Request("markread", server.CltMarkRead{User: uid, Mid: mid}, &conv)
+	return conv.ConversationData, err
+}
+
+// Sync returns events after sequence number seq and unread counts of dialogs.
+// Next sync starts from Seq of answer; if Reset is set, state is loaded again.
+func (c *Client) Sync(seq int, limit int) (server.SrvSync, error) {
+	var answer server.SrvSync
+	err := c.Request("sync", server.CltSync{Seq: seq, Limit: limit}, &answer)
+	return answer, err
+}
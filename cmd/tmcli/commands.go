@@ -0,0 +1,373 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"../../client"
+	"../../server"
+)
+
+// Max size of file sent as attachment
+const maxAttachFile = 5 << 20
+
+// command is a command of terminal client
+type command struct {
+	args  string // Description of arguments
+	help  string
+	min   int // Min count of arguments
+	apply func(cli *CLI, args []string) error
+}
+
+// Commands of terminal client, a line without "/" is a message to current dialog
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"register": {"login pass nick", "register and login", 3, (*CLI).register},
+		"login":    {"login pass", "login", 2, (*CLI).login},
+		"verify":   {"email|phone [code]", "confirm email or phone, without code - send new code", 1, (*CLI).verify},
+		"contacts": {"", "show contact list", 0, (*CLI).contacts},
+		"add":      {"uid", "add user to contacts", 1, (*CLI).add},
+		"del":      {"uid", "remove user from contacts", 1, (*CLI).del},
+		"import":   {"file.vcf|file.csv", "find users from address book", 1, (*CLI).importFile},
+		"info":     {"uid", "show profile of user", 1, (*CLI).info},
+		"to":       {"uid", "set current dialog", 1, (*CLI).to},
+		"msg":      {"uid text", "send message", 2, (*CLI).msg},
+		"send":     {"uid file [text]", "send file as attachment", 2, (*CLI).send},
+		"history":  {"uid [limit]", "show messages of dialog", 1, (*CLI).history},
+		"chats":    {"", "show dialogs with unread counts", 0, (*CLI).chats},
+		"read":     {"uid", "mark messages of dialog as read", 1, (*CLI).read},
+		"help":     {"", "show commands", 0, (*CLI).help},
+		"quit":     {"", "exit", 0, (*CLI).exit},
+	}
+}
+
+// CLI executes commands and prints events of server
+type CLI struct {
+	c     *client.Client
+	out   io.Writer
+	mutex sync.Mutex // Guards out
+	peer  string     // Uid of current dialog
+	quit  bool
+}
+
+// newCLI is constructor of CLI, it starts printing of events
+func newCLI(c *client.Client, out io.Writer) *CLI {
+	cli := &CLI{c: c, out: out}
+	c.OnMessage(cli.printMessage)
+	c.Handle("ev_system", func(data json.RawMessage) {
+		var ev server.EvSrvSystem
+		if json.Unmarshal(data, &ev) == nil {
+			cli.printf("[%s] *** %s: %s\n", clock(ev.Time), ev.Kind, ev.Text)
+		}
+	})
+	c.Handle("ev_message_edited", func(data json.RawMessage) {
+		var ev server.EvSrvMessageEdited
+		if json.Unmarshal(data, &ev) == nil {
+			cli.printf("[%s] %s edited %s: %s\n", clock(ev.Time), ev.From, ev.Mid, ev.Body)
+		}
+	})
+	c.Handle("ev_message_deleted", func(data json.RawMessage) {
+		var ev server.EvSrvMessageDeleted
+		if json.Unmarshal(data, &ev) == nil {
+			cli.printf("%s deleted %s\n", ev.From, ev.Mid)
+		}
+	})
+	return cli
+}
+
+// printf writes line to output without mixing with events
+func (cli *CLI) printf(format string, args ...interface{}) {
+	cli.mutex.Lock()
+	defer cli.mutex.Unlock()
+
+	fmt.Fprintf(cli.out, format, args...)
+}
+
+// clock formats time of message
+func clock(t int) string {
+	return time.Unix(int64(t), 0).Format("15:04:05")
+}
+
+// printMessage prints new message
+func (cli *CLI) printMessage(m server.EvSrvMessage) {
+	cli.printf("[%s] %s (%s): %s%s\n", clock(m.Time), m.Nick, m.From, m.Body, attachInfo(m.Attach))
+}
+
+// attachInfo describes attachment of message
+func attachInfo(attach server.AttachData) string {
+	if attach.Data == "" {
+		return ""
+	}
+	return fmt.Sprintf(" [%s, %d bytes]", attach.Mime, base64.StdEncoding.DecodedLen(len(attach.Data)))
+}
+
+// run executes one line and prints its error
+func (cli *CLI) run(line string) bool {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return true
+	}
+	if !strings.HasPrefix(line, "/") {
+		if cli.peer == "" {
+			cli.printf("error: no dialog, use /to uid or /msg uid text\n")
+			return false
+		}
+		return cli.report(cli.c.SendMessage(cli.peer, line, server.AttachData{}))
+	}
+
+	fields := strings.Fields(line[1:])
+	name := ""
+	if len(fields) > 0 {
+		name = fields[0]
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		cli.printf("error: unknown command /%s, see /help\n", name)
+		return false
+	}
+	args := fields[1:]
+	if len(args) < cmd.min {
+		cli.printf("usage: /%s %s\n", name, cmd.args)
+		return false
+	}
+	return cli.report(cmd.apply(cli, args))
+}
+
+// report prints error of command
+func (cli *CLI) report(err error) bool {
+	if err != nil {
+		cli.printf("error: %v\n", err)
+	}
+	return err == nil
+}
+
+// rest joins arguments starting from i
+func rest(args []string, i int) string {
+	if i >= len(args) {
+		return ""
+	}
+	return strings.Join(args[i:], " ")
+}
+
+func (cli *CLI) register(args []string) error {
+	session, err := cli.c.Register(args[0], args[1], rest(args, 2))
+	if err == nil {
+		cli.printf("registered as %s (%s)\n", session.Nick, args[0])
+	}
+	return err
+}
+
+func (cli *CLI) login(args []string) error {
+	session, err := cli.c.Auth(args[0], args[1])
+	if err == nil {
+		cli.printf("logged in as %s (%s)\n", session.Nick, args[0])
+	}
+	return err
+}
+
+func (cli *CLI) contacts(args []string) error {
+	list, err := cli.c.ContactList()
+	if err != nil {
+		return err
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Uid < list[j].Uid })
+	for _, u := range list {
+		cli.printUser(u, "")
+	}
+	cli.printf("%d contacts\n", len(list))
+	return nil
+}
+
+// printUser prints user of contact list or import
+func (cli *CLI) printUser(u server.UserData, name string) {
+	bot := ""
+	if u.Bot {
+		bot = " [bot]"
+	}
+	if name != "" {
+		name = " - " + name
+	}
+	cli.printf("%s\t%s%s\t%s\t%s%s\n", u.Uid, u.Nick, bot, u.Phone, u.Email, name)
+}
+
+func (cli *CLI) verify(args []string) error {
+	if len(args) < 2 {
+		err := cli.c.SendCode(args[0])
+		if err == nil {
+			cli.printf("Code is sent to %s\n", args[0])
+		}
+		return err
+	}
+	err := cli.c.Verify(args[0], args[1])
+	if err == nil {
+		cli.printf("%s is verified\n", args[0])
+	}
+	return err
+}
+
+func (cli *CLI) add(args []string) error {
+	err := cli.c.AddContact(args[0])
+	if err == nil {
+		cli.printf("%s is added\n", args[0])
+	}
+	return err
+}
+
+func (cli *CLI) del(args []string) error {
+	err := cli.c.DelContact(args[0])
+	if err == nil {
+		cli.printf("%s is removed\n", args[0])
+	}
+	return err
+}
+
+func (cli *CLI) importFile(args []string) error {
+	format, payload, entries, err := readContacts(rest(args, 0))
+	if err != nil {
+		return err
+	}
+	found, err := cli.c.ImportAddressBook(format, payload)
+	if err != nil {
+		return err
+	}
+	names := make(map[string]string, len(entries))
+	for _, e := range entries {
+		names[e.MyID] = e.Name
+	}
+	for _, u := range found {
+		cli.printUser(u, names[u.MyID])
+	}
+	cli.printf("%d of %d contacts are found\n", len(found), len(entries))
+	return nil
+}
+
+func (cli *CLI) info(args []string) error {
+	info, err := cli.c.UserInfo(args[0])
+	if err == nil {
+		cli.printf("%s\t%s\t%s\t%s\t%s\n", args[0], info.Nick, info.UserStatus, info.Phone, info.Email)
+	}
+	return err
+}
+
+func (cli *CLI) to(args []string) error {
+	cli.peer = args[0]
+	cli.printf("messages go to %s\n", cli.peer)
+	return nil
+}
+
+func (cli *CLI) msg(args []string) error {
+	return cli.c.SendMessage(args[0], rest(args, 1), server.AttachData{})
+}
+
+func (cli *CLI) send(args []string) error {
+	attach, err := readAttach(args[1])
+	if err != nil {
+		return err
+	}
+	return cli.c.SendMessage(args[0], rest(args, 2), attach)
+}
+
+// readAttach reads file and encodes it as attachment
+func readAttach(path string) (server.AttachData, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return server.AttachData{}, err
+	}
+	if info.Size() > maxAttachFile {
+		return server.AttachData{}, errors.New("File is too large")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return server.AttachData{}, err
+	}
+	mimeType := mime.TypeByExtension(filepath.Ext(path))
+	if mimeType == "" {
+		mimeType = http.DetectContentType(data)
+	}
+	return server.AttachData{
+		Mime: strings.SplitN(mimeType, ";", 2)[0],
+		Data: base64.StdEncoding.EncodeToString(data),
+	}, nil
+}
+
+func (cli *CLI) history(args []string) error {
+	limit := 20
+	if len(args) > 1 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil {
+			return errors.New("Invalid limit")
+		}
+		limit = n
+	}
+	messages, err := cli.c.History(args[0], "", limit)
+	if err != nil {
+		return err
+	}
+	for _, m := range messages {
+		cli.printf("[%s] %s: %s%s\n", clock(m.Time), m.From, m.Body, attachInfo(m.Attach))
+	}
+	return nil
+}
+
+func (cli *CLI) chats(args []string) error {
+	list, err := cli.c.Conversations()
+	if err != nil {
+		return err
+	}
+	for _, conv := range list {
+		flags := ""
+		if conv.Pinned {
+			flags += " [pinned]"
+		}
+		if conv.Archived {
+			flags += " [archived]"
+		}
+		if conv.Muted {
+			flags += " [muted]"
+		}
+		last := ""
+		if conv.Last != nil {
+			last = fmt.Sprintf("[%s] %s: %s", clock(conv.Last.Time), conv.Last.From, conv.Last.Body)
+		}
+		cli.printf("%s\t%s%s\t%d unread\t%s\n", conv.Uid, conv.Nick, flags, conv.Unread, last)
+	}
+	return nil
+}
+
+func (cli *CLI) read(args []string) error {
+	_, err := cli.c.MarkRead(args[0], "")
+	return err
+}
+
+func (cli *CLI) help(args []string) error {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cmd := commands[name]
+		cli.printf("/%s %s\t- %s\n", name, cmd.args, cmd.help)
+	}
+	cli.printf("text without / is sent to current dialog (/to uid)\n")
+	return nil
+}
+
+func (cli *CLI) exit(args []string) error {
+	cli.quit = true
+	return nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"../../client"
+	"../../server"
+)
+
+// syncBuffer is an output of CLI which is written by events goroutine
+type syncBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.String()
+}
+
+// newTestCLI connects CLI to server
+func newTestCLI(t *testing.T, addr string) (*CLI, *syncBuffer) {
+	c, err := client.Dial(addr)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	out := &syncBuffer{}
+	return newCLI(c, out), out
+}
+
+// TestCLICommands checks script of commands
+func TestCLICommands(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer l.Close()
+	config := server.DefaultConfig()
+	config.LogOutput = io.Discard
+	sender := server.NewLocalSender()
+	config.VerifySender = sender
+	go server.CreateInstanceWithConfig(config).Serve(l)
+
+	cli1, out1 := newTestCLI(t, l.Addr().String())
+	cli2, out2 := newTestCLI(t, l.Addr().String())
+
+	dir := t.TempDir()
+	book := filepath.Join(dir, "book.csv")
+	os.WriteFile(book, []byte("name,email\nFriend,user1@mail.ru\n"), 0600)
+	picture := filepath.Join(dir, "picture.png")
+	os.WriteFile(picture, []byte("\x89PNG\r\n\x1a\n0000"), 0600)
+
+	script1 := []string{"/register user1 pass Ivan Petrov", "/unknown", "/to", "hello"}
+	results := []bool{true, false, false, false}
+	for i, line := range script1 {
+		if cli1.run(line) != results[i] {
+			t.Errorf("Invalid result of %q: %s", line, out1.String())
+		}
+	}
+	cli1.c.SetUserInfo(server.CltSetUserInfo{Email: "user1@mail.ru"})
+	if !cli1.run("/verify email "+sender.Code("user1@mail.ru")) || !strings.Contains(out1.String(), "email is verified") {
+		t.Errorf("Email is not verified: %s", out1.String())
+	}
+
+	script2 := []string{"/register user2 pass Anna", "/import " + book, "/add user1", "/contacts", "/to user1", "hi there", "/send user1 " + picture + " photo"}
+	for _, line := range script2 {
+		if !cli2.run(line) {
+			t.Errorf("Command %q failed: %s", line, out2.String())
+		}
+	}
+	for _, text := range []string{"user1\tIvan Petrov\t\tuser1@mail.ru - Friend", "1 of 1 contacts are found", "1 contacts"} {
+		if !strings.Contains(out2.String(), text) {
+			t.Errorf("Output has no %q: %s", text, out2.String())
+		}
+	}
+
+	for i := 0; i < 100 && !strings.Contains(out1.String(), "photo ["); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, text := range []string{"Anna (user2): hi there", "Anna (user2): photo [image/png, 12 bytes]"} {
+		if !strings.Contains(out1.String(), text) {
+			t.Errorf("Output has no %q: %s", text, out1.String())
+		}
+	}
+	if !cli1.run("/chats") || !strings.Contains(out1.String(), "user2\tAnna\t2 unread\t") {
+		t.Errorf("Output has no dialog with user2: %s", out1.String())
+	}
+	if !cli1.run("/read user2") || !cli1.run("/chats") || !strings.Contains(out1.String(), "user2\tAnna\t0 unread\t") {
+		t.Errorf("Dialog is not read: %s", out1.String())
+	}
+
+	if !cli1.run("/quit") || !cli1.quit {
+		t.Errorf("Quit is failed")
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"../../server"
+)
+
+// readContacts reads address book from .vcf (vCard) or .csv file.
+// Entries are parsed only to show names of found users, server parses payload itself.
+func readContacts(path string) (string, string, []server.AddressBookEntry, error) {
+	format := ""
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".vcf", ".vcard":
+		format = server.FormatVCard
+	case ".csv":
+		format = server.FormatCSV
+	default:
+		return "", "", nil, errors.New("Unknown format of contacts, use .vcf or .csv")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", nil, err
+	}
+	entries, err := server.ParseAddressBook(format, string(data))
+	return format, string(data), entries, err
+}
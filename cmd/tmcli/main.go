@@ -0,0 +1,55 @@
+// Command tmcli is a terminal client of TechnoMessenger server.
+//
+// Commands are read from stdin line by line, so tmcli works both
+// interactively and with scripts: tmcli -login user -pass secret < script.txt
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"../../client"
+)
+
+// Main function
+func main() {
+	addr := flag.String("addr", "localhost:7788", "Address of server")
+	login := flag.String("login", "", "Login for auth after connect")
+	pass := flag.String("pass", os.Getenv("TM_PASS"), "Password for auth after connect (or TM_PASS)")
+	timeout := flag.Duration("timeout", 10*time.Second, "Timeout of answers")
+	wait := flag.Duration("wait", 0, "Time to show events after end of input, e.g. 5s")
+	flag.Parse()
+
+	opts := client.DefaultOptions()
+	opts.Timeout = *timeout
+	c, err := client.DialOptions(*addr, opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Can't connect:", err)
+		os.Exit(1)
+	}
+	defer c.Close()
+
+	cli := newCLI(c, os.Stdout)
+	cli.printf("%s\n", c.MOTD())
+	if *login != "" {
+		if !cli.run("/login " + *login + " " + *pass) {
+			os.Exit(1)
+		}
+	}
+
+	ok := true
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for !cli.quit && scanner.Scan() {
+		ok = cli.run(scanner.Text()) && ok
+	}
+	if !cli.quit && *wait > 0 {
+		time.Sleep(*wait)
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
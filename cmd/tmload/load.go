@@ -0,0 +1,280 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"../../client"
+	"../../server"
+)
+
+// Prefix of body of load messages: "load <sender> <unix nano of sending>"
+const loadPrefix = "load "
+
+// Load is a setting of load test
+type Load struct {
+	Addr     string        // Address of server ("" - in-process server on loopback)
+	Clients  int           // Count of simulated clients
+	Rate     float64       // Messages per second of one client (0 - as fast as possible)
+	Messages int           // Messages of one client (0 - till Duration ends)
+	Duration time.Duration // Duration of exchange of messages
+	Attach   int           // Size of attachment of each message in bytes (0 - no attachment)
+	Prefix   string        // Prefix of logins of simulated users
+	Timeout  time.Duration // Timeout of answers
+	Drain    time.Duration // Max time of waiting for messages in flight after exchange
+}
+
+// Latencies are percentiles of latencies
+type Latencies struct {
+	Count                   int
+	P50, P90, P99, Max, Avg time.Duration
+}
+
+// Result is a report of load test
+type Result struct {
+	Clients  int
+	Setup    time.Duration // Time of registration and auth of all clients
+	Elapsed  time.Duration // Time of exchange of messages
+	Sent     int           // Messages accepted by server
+	Received int           // Messages delivered to recipients
+	Errors   int           // Failed requests
+	Bytes    int64         // Size of sent attachments
+
+	Answer   Latencies // Time from request to answer of server
+	Delivery Latencies // Time from request to ev_message of recipient
+
+	Goroutines int    // Goroutines of process at the end of exchange
+	HeapAlloc  uint64 // Allocated heap at the end of exchange
+	HeapGrowth int64  // Growth of allocated heap during test
+	TotalAlloc uint64 // Bytes allocated during test
+}
+
+// Throughput returns delivered messages per second
+func (r Result) Throughput() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Received) / r.Elapsed.Seconds()
+}
+
+// recorder collects latencies of many goroutines
+type recorder struct {
+	mutex   sync.Mutex
+	samples []time.Duration
+}
+
+func (r *recorder) add(d time.Duration) {
+	r.mutex.Lock()
+	r.samples = append(r.samples, d)
+	r.mutex.Unlock()
+}
+
+func (r *recorder) count() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return len(r.samples)
+}
+
+// latencies calculates percentiles of samples
+func (r *recorder) latencies() Latencies {
+	r.mutex.Lock()
+	samples := append([]time.Duration(nil), r.samples...)
+	r.mutex.Unlock()
+
+	l := Latencies{Count: len(samples)}
+	if len(samples) == 0 {
+		return l
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	percentile := func(p float64) time.Duration {
+		return samples[int(p*float64(len(samples)-1))]
+	}
+	var sum time.Duration
+	for _, s := range samples {
+		sum += s
+	}
+	l.P50, l.P90, l.P99 = percentile(0.5), percentile(0.9), percentile(0.99)
+	l.Max = samples[len(samples)-1]
+	l.Avg = sum / time.Duration(len(samples))
+	return l
+}
+
+// startServer starts in-process server on loopback without rate limits
+func startServer() (string, func(), error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, err
+	}
+	config := server.DefaultConfig()
+	config.RateLimits = nil
+	config.AuthMaxFailures = 0
+	config.LogOutput = io.Discard
+	go server.CreateInstanceWithConfig(config).Serve(l)
+	return l.Addr().String(), func() { l.Close() }, nil
+}
+
+// newAttach creates attachment with random data
+func newAttach(size int) (server.AttachData, error) {
+	if size <= 0 {
+		return server.AttachData{}, nil
+	}
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		return server.AttachData{}, err
+	}
+	return server.AttachData{Mime: "application/octet-stream", Data: base64.StdEncoding.EncodeToString(data)}, nil
+}
+
+// sentAt parses time of sending from body of load message
+func sentAt(body string) (time.Time, bool) {
+	if !strings.HasPrefix(body, loadPrefix) {
+		return time.Time{}, false
+	}
+	fields := strings.Fields(body)
+	if len(fields) != 3 {
+		return time.Time{}, false
+	}
+	nano, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, nano), true
+}
+
+// Run connects clients, registers them and exchanges messages between them.
+// Client i sends messages to client i+1.
+func Run(load Load) (Result, error) {
+	result := Result{Clients: load.Clients}
+	if load.Clients < 2 {
+		return result, errors.New("Load needs at least 2 clients")
+	}
+	if load.Messages <= 0 && load.Duration <= 0 {
+		return result, errors.New("Set count of messages or duration")
+	}
+	if load.Prefix == "" {
+		load.Prefix = "load" + strconv.FormatInt(time.Now().UnixNano(), 36) + "_"
+	}
+	if load.Addr == "" {
+		addr, stop, err := startServer()
+		if err != nil {
+			return result, err
+		}
+		defer stop()
+		load.Addr = addr
+	}
+	attach, err := newAttach(load.Attach)
+	if err != nil {
+		return result, err
+	}
+
+	runtime.GC()
+	var before runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	answers := &recorder{}
+	deliveries := &recorder{}
+	opts := client.DefaultOptions()
+	opts.Reconnect = false
+	if load.Timeout > 0 {
+		opts.Timeout = load.Timeout
+	}
+
+	// Accounts are created one by one, it is a separate phase of test
+	start := time.Now()
+	clients := make([]*client.Client, load.Clients)
+	defer func() {
+		for _, c := range clients {
+			if c != nil {
+				c.Close()
+			}
+		}
+	}()
+	for i := range clients {
+		c, err := client.DialOptions(load.Addr, opts)
+		if err != nil {
+			return result, err
+		}
+		clients[i] = c
+		login := load.Prefix + strconv.Itoa(i)
+		if _, err := c.Register(login, "pass", login); err != nil {
+			return result, fmt.Errorf("Register of %s: %v", login, err)
+		}
+		c.OnMessage(func(m server.EvSrvMessage) {
+			if t, ok := sentAt(m.Body); ok && m.From != login {
+				deliveries.add(time.Since(t))
+			}
+		})
+	}
+	result.Setup = time.Since(start)
+
+	var mutex sync.Mutex // Guards counters of result
+	var wg sync.WaitGroup
+	start = time.Now()
+	deadline := start.Add(load.Duration)
+	for i, c := range clients {
+		wg.Add(1)
+		go func(i int, c *client.Client) {
+			defer wg.Done()
+			from := load.Prefix + strconv.Itoa(i)
+			to := load.Prefix + strconv.Itoa((i+1)%len(clients))
+			var ticker *time.Ticker
+			if load.Rate > 0 {
+				ticker = time.NewTicker(time.Duration(float64(time.Second) / load.Rate))
+				defer ticker.Stop()
+			}
+			for n := 0; load.Messages <= 0 || n < load.Messages; n++ {
+				if load.Duration > 0 && time.Now().After(deadline) {
+					return
+				}
+				if ticker != nil {
+					<-ticker.C
+				}
+				sent := time.Now()
+				body := loadPrefix + from + " " + strconv.FormatInt(sent.UnixNano(), 10)
+				err := c.SendMessage(to, body, attach)
+				answers.add(time.Since(sent))
+
+				mutex.Lock()
+				if err != nil {
+					result.Errors++
+				} else {
+					result.Sent++
+					result.Bytes += int64(load.Attach)
+				}
+				mutex.Unlock()
+				if err == client.ErrClosed || err == client.ErrDisconnected {
+					return
+				}
+			}
+		}(i, c)
+	}
+	wg.Wait()
+
+	// Messages in flight are waited for
+	drain := time.Now().Add(load.Drain)
+	for deliveries.count() < result.Sent && time.Now().Before(drain) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	result.Elapsed = time.Since(start)
+	result.Received = deliveries.count()
+	result.Answer = answers.latencies()
+	result.Delivery = deliveries.latencies()
+
+	var after runtime.MemStats
+	runtime.ReadMemStats(&after)
+	result.Goroutines = runtime.NumGoroutine()
+	result.HeapAlloc = after.HeapAlloc
+	result.HeapGrowth = int64(after.HeapAlloc) - int64(before.HeapAlloc)
+	result.TotalAlloc = after.TotalAlloc - before.TotalAlloc
+	return result, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRun checks small load on in-process server
+func TestRun(t *testing.T) {
+	result, err := Run(Load{Clients: 4, Messages: 5, Attach: 1024, Drain: time.Second})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if result.Sent != 20 || result.Received != 20 || result.Errors != 0 {
+		t.Errorf("Invalid result %+v", result)
+	}
+	if result.Answer.Count != 20 || result.Delivery.P50 <= 0 || result.Delivery.Max < result.Delivery.P99 {
+		t.Errorf("Invalid latencies %+v %+v", result.Answer, result.Delivery)
+	}
+
+	var out bytes.Buffer
+	report(&out, result)
+	if !strings.Contains(out.String(), "20 sent, 20 delivered, 0 errors") {
+		t.Errorf("Invalid report %s", out.String())
+	}
+
+	if _, err := Run(Load{Clients: 1, Messages: 1}); err == nil {
+		t.Errorf("Load with one client is accepted")
+	}
+}
+
+// TestSentAt checks parsing of load messages
+func TestSentAt(t *testing.T) {
+	if at, ok := sentAt("load user 1000000000"); !ok || at.Unix() != 1 {
+		t.Errorf("Invalid time %v %v", at, ok)
+	}
+	if _, ok := sentAt("hello"); ok {
+		t.Errorf("Usual message is parsed")
+	}
+}
+
+// benchmarkLoad sends b.N messages through in-process server
+func benchmarkLoad(b *testing.B, clients int, attach int) {
+	messages := b.N/clients + 1
+	b.ResetTimer()
+	result, err := Run(Load{Clients: clients, Messages: messages, Attach: attach, Drain: 10 * time.Second})
+	b.StopTimer()
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+	if result.Errors > 0 || result.Received < result.Sent {
+		b.Fatalf("Lost messages %+v", result)
+	}
+	b.ReportMetric(result.Throughput(), "msg/s")
+	b.ReportMetric(float64(result.Delivery.P99.Microseconds()), "p99-µs")
+}
+
+func BenchmarkLoad10(b *testing.B)       { benchmarkLoad(b, 10, 0) }
+func BenchmarkLoad100(b *testing.B)      { benchmarkLoad(b, 100, 0) }
+func BenchmarkLoad10Attach(b *testing.B) { benchmarkLoad(b, 10, 64<<10) }
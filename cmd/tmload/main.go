@@ -0,0 +1,58 @@
+// Command tmload is a load generator for TechnoMessenger server.
+//
+// It connects simulated clients over loopback, registers them, exchanges
+// messages between them and reports throughput, latency percentiles and
+// memory usage. Without -addr the server runs in the same process, so
+// memory usage includes the server.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Main function
+func main() {
+	var load Load
+	flag.StringVar(&load.Addr, "addr", "", "Address of server started with -no-limits (empty - in-process server without rate limits)")
+	flag.IntVar(&load.Clients, "clients", 100, "Count of simulated clients")
+	flag.Float64Var(&load.Rate, "rate", 1, "Messages per second of one client (0 - as fast as possible)")
+	flag.IntVar(&load.Messages, "messages", 0, "Messages of one client (0 - till -duration ends)")
+	flag.DurationVar(&load.Duration, "duration", 10*time.Second, "Duration of exchange of messages")
+	flag.IntVar(&load.Attach, "attach", 0, "Size of attachment of each message in bytes")
+	flag.StringVar(&load.Prefix, "prefix", "", "Prefix of logins of simulated users (empty - unique)")
+	flag.DurationVar(&load.Timeout, "timeout", 10*time.Second, "Timeout of answers")
+	flag.DurationVar(&load.Drain, "drain", 5*time.Second, "Max time of waiting for messages in flight")
+	flag.Parse()
+
+	result, err := Run(load)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Load failed:", err)
+		os.Exit(1)
+	}
+	report(os.Stdout, result)
+	if result.Errors > 0 || result.Received < result.Sent {
+		os.Exit(2)
+	}
+}
+
+// report prints result of load test
+func report(w io.Writer, r Result) {
+	fmt.Fprintf(w, "clients:     %d (setup %v)\n", r.Clients, r.Setup.Round(time.Millisecond))
+	fmt.Fprintf(w, "messages:    %d sent, %d delivered, %d errors in %v\n", r.Sent, r.Received, r.Errors, r.Elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "throughput:  %.1f msg/s, %.1f MB/s of attachments\n", r.Throughput(), float64(r.Bytes)/(1<<20)/r.Elapsed.Seconds())
+	printLatencies(w, "answer", r.Answer)
+	printLatencies(w, "delivery", r.Delivery)
+	fmt.Fprintf(w, "memory:      heap %.1f MB (growth %+.1f MB), allocated %.1f MB, %d goroutines\n",
+		float64(r.HeapAlloc)/(1<<20), float64(r.HeapGrowth)/(1<<20), float64(r.TotalAlloc)/(1<<20), r.Goroutines)
+}
+
+// printLatencies prints percentiles of latencies
+func printLatencies(w io.Writer, name string, l Latencies) {
+	round := func(d time.Duration) time.Duration { return d.Round(time.Microsecond) }
+	fmt.Fprintf(w, "%-12s p50 %v, p90 %v, p99 %v, max %v, avg %v\n", name+":",
+		round(l.P50), round(l.P90), round(l.P99), round(l.Max), round(l.Avg))
+}
@@ -1,6 +1,11 @@
 package main
 
 import (
+	"flag"
+	"log/slog"
+	"os"
+	"strings"
+
 	"./server"
 )
 
@@ -9,6 +14,41 @@ const PORT = 7788
 
 // Main function
 func main() {
-	s := server.CreateInstance()
+	config := server.DefaultConfig()
+	metrics := flag.String("metrics", "", "Address of metrics endpoint, e.g. :9100")
+	admin := flag.String("admin", "", "Address of admin API, e.g. 127.0.0.1:9200")
+	motd := flag.String("motd", config.MOTD, "Message of the day")
+	admins := flag.String("admins", "", "Comma separated logins of admins")
+	bots := flag.String("bots", "", "Address of bot API, e.g. :9300")
+	logFormat := flag.String("log-format", config.LogFormat, "Format of log: text or json")
+	region := flag.String("region", config.PhoneRegion, "Region of phones without country code, e.g. RU")
+	logLevel := flag.String("log-level", config.LogLevel.String(), "Level of log: debug, info, warn or error")
+	devCodes := flag.Bool("dev-codes", false, "Print verification codes to stdout (development only)")
+	noLimits := flag.Bool("no-limits", false, "Disable rate limits and auth bans (load testing only)")
+	flag.Parse()
+
+	config.MetricsAddr = *metrics
+	config.AdminAddr = *admin
+	config.AdminToken = os.Getenv("TM_ADMIN_TOKEN")
+	config.MOTD = *motd
+	config.BotAddr = *bots
+	config.PhoneRegion = *region
+	// Without mail or SMS gateway codes are kept in memory, printed codes are for development only
+	if *devCodes {
+		config.VerifySender = server.NewWriterSender(os.Stdout)
+	}
+	if *noLimits {
+		config.RateLimits = nil
+		config.AuthMaxFailures = 0
+	}
+	if *admins != "" {
+		config.Admins = strings.Split(*admins, ",")
+	}
+	config.LogFormat = *logFormat
+	if err := config.LogLevel.UnmarshalText([]byte(*logLevel)); err != nil {
+		slog.Error("Invalid log level", "err", err)
+		return
+	}
+	s := server.CreateInstanceWithConfig(config)
 	s.Start(PORT)
 }
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Formats of address book in import
+const (
+	FormatJSON  = "json"  // Array of Contact in "contacts"
+	FormatVCard = "vcard" // vCard 3.0 or 4.0 in "payload"
+	FormatCSV   = "csv"   // CSV with columns name, phone, email, myid in "payload"
+)
+
+// AddressBookEntry is a contact of address book with all its phones and emails
+type AddressBookEntry struct {
+	Name   string
+	MyID   string // ID of contact on device of user, it is returned with found user
+	Phones []string
+	Emails []string
+}
+
+// entriesOfContacts converts contacts of JSON import to address book entries
+func entriesOfContacts(contacts []Contact) []AddressBookEntry {
+	entries := make([]AddressBookEntry, 0, len(contacts))
+	for _, contact := range contacts {
+		entry := AddressBookEntry{Name: contact.Name, MyID: contact.MyID}
+		if contact.Phone != "" {
+			entry.Phones = []string{contact.Phone}
+		}
+		if contact.Email != "" {
+			entry.Emails = []string{contact.Email}
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// ParseAddressBook reads address book in format (FormatVCard or FormatCSV).
+// Entries without own ID get their position as MyID.
+func ParseAddressBook(format string, payload string) ([]AddressBookEntry, error) {
+	var entries []AddressBookEntry
+	var err error
+	switch strings.ToLower(format) {
+	case FormatVCard:
+		entries, err = parseVCard(strings.NewReader(payload))
+	case FormatCSV:
+		entries, err = parseCSV(strings.NewReader(payload))
+	default:
+		return nil, errors.New("Unknown format of address book")
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i := range entries {
+		if entries[i].MyID == "" {
+			entries[i].MyID = strconv.Itoa(i + 1)
+		}
+	}
+	return entries, nil
+}
+
+// unescapeVCard decodes escaped characters of vCard text value
+var unescapeVCard = strings.NewReplacer(`\,`, ",", `\;`, ";", `\n`, " ", `\N`, " ", `\\`, `\`)
+
+// parseVCard reads FN, N, TEL, EMAIL and UID properties of vCards
+func parseVCard(r io.Reader) ([]AddressBookEntry, error) {
+	lines := make([]string, 0)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		// Folded line continues previous one
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	entries := make([]AddressBookEntry, 0)
+	var cur *AddressBookEntry
+	for _, line := range lines {
+		colon := strings.Index(line, ":")
+		if colon < 0 {
+			continue
+		}
+		// Property may have group and parameters: item1.TEL;TYPE=CELL:+7...
+		name := strings.ToUpper(strings.SplitN(line[:colon], ";", 2)[0])
+		if dot := strings.LastIndex(name, "."); dot >= 0 {
+			name = name[dot+1:]
+		}
+		value := strings.TrimSpace(line[colon+1:])
+		switch {
+		case name == "BEGIN" && strings.EqualFold(value, "VCARD"):
+			cur = &AddressBookEntry{}
+		case name == "END" && strings.EqualFold(value, "VCARD"):
+			if cur == nil {
+				return nil, errors.New("END:VCARD without BEGIN:VCARD")
+			}
+			entries = append(entries, *cur)
+			cur = nil
+		case cur == nil || value == "":
+		case name == "FN":
+			cur.Name = unescapeVCard.Replace(value)
+		case name == "N" && cur.Name == "":
+			// Family;Given;Additional;Prefix;Suffix
+			parts := strings.Split(value, ";")
+			if len(parts) > 1 {
+				parts[0], parts[1] = parts[1], parts[0]
+			}
+			cur.Name = strings.Join(strings.Fields(unescapeVCard.Replace(strings.Join(parts, " "))), " ")
+		case name == "TEL":
+			// vCard 4.0 keeps phone as uri
+			cur.Phones = append(cur.Phones, strings.TrimPrefix(value, "tel:"))
+		case name == "EMAIL":
+			cur.Emails = append(cur.Emails, strings.TrimPrefix(value, "mailto:"))
+		case name == "UID":
+			cur.MyID = value
+		}
+	}
+	if cur != nil {
+		return nil, errors.New("BEGIN:VCARD without END:VCARD")
+	}
+	return entries, nil
+}
+
+// Known headers of CSV columns with fields of entry
+var csvHeaders = map[string]string{
+	"name": "name", "full name": "name", "fn": "name",
+	"myid": "myid", "id": "myid", "uid": "myid",
+	"phone": "phone", "tel": "phone", "telephone": "phone", "mobile": "phone", "mobile phone": "phone",
+	"email": "email", "e-mail": "email", "email address": "email", "e-mail address": "email",
+}
+
+// csvColumn returns field of entry for header of CSV column ("" - unknown column).
+// Exports of address books have headers like "E-mail 1 - Value" or "Phone 2 - Value".
+// Header has to match whole, so name of contact (e.g. "Tellman") isn't taken for it.
+func csvColumn(header string) string {
+	header = strings.ToLower(strings.TrimSpace(header))
+	header = strings.TrimSuffix(header, " - value")
+	if i := strings.LastIndexByte(header, ' '); i > 0 {
+		if _, err := strconv.Atoi(header[i+1:]); err == nil {
+			header = header[:i]
+		}
+	}
+	return csvHeaders[header]
+}
+
+// parseCSV reads contacts from CSV. Header row defines columns, without it columns are
+// name, phone, email, myid. Cell may contain several values separated by ";" or " ::: ".
+func parseCSV(r io.Reader) ([]AddressBookEntry, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	columns := []string{"name", "phone", "email", "myid"}
+	if len(rows) > 0 {
+		header := make([]string, len(rows[0]))
+		known := false
+		for i, col := range rows[0] {
+			header[i] = csvColumn(col)
+			known = known || header[i] != ""
+		}
+		if known {
+			columns = header
+			rows = rows[1:]
+		}
+	}
+
+	entries := make([]AddressBookEntry, 0, len(rows))
+	for _, row := range rows {
+		var entry AddressBookEntry
+		for i, cell := range row {
+			if i >= len(columns) {
+				break
+			}
+			cell = strings.TrimSpace(cell)
+			switch columns[i] {
+			case "name":
+				entry.Name = cell
+			case "myid":
+				entry.MyID = cell
+			case "phone":
+				entry.Phones = append(entry.Phones, splitValues(cell)...)
+			case "email":
+				entry.Emails = append(entry.Emails, splitValues(cell)...)
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// splitValues splits cell of CSV with several values
+func splitValues(cell string) []string {
+	values := make([]string, 0)
+	for _, v := range strings.FieldsFunc(strings.ReplaceAll(cell, ":::", ";"), func(r rune) bool { return r == ';' }) {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// findEntry finds uid of user by emails and then by phones of entry
+func (s *MessageServer) findEntry(entry AddressBookEntry) (string, bool) {
+	for _, email := range entry.Emails {
+		if uid, ok := s.findUid(email, ""); ok {
+			return uid, true
+		}
+	}
+	for _, phone := range entry.Phones {
+		if uid, ok := s.findUid("", phone); ok {
+			return uid, true
+		}
+	}
+	return "", false
+}
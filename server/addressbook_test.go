@@ -0,0 +1,130 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"testing"
+)
+
+// TestParseVCard checks folded lines, groups, escapes and several phones of vCard
+func TestParseVCard(t *testing.T) {
+	vcf := "BEGIN:VCARD\r\nVERSION:3.0\r\nFN:Ivan\r\n  Petrov\\, jr\r\nTEL;TYPE=CELL:+7 912 345-67-89\r\n" +
+		"item1.TEL:tel:89001112233\r\nEMAIL;TYPE=INTERNET:ivan@mail.ru\r\nUID:abc\r\nEND:VCARD\r\n" +
+		"BEGIN:VCARD\nVERSION:4.0\nN:Smirnova;Anna;;;\nEMAIL:anna@mail.ru\nEND:VCARD\n"
+	entries, err := ParseAddressBook(FormatVCard, vcf)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Invalid count of entries %v", entries)
+	}
+	e := entries[0]
+	if e.Name != "Ivan Petrov, jr" || len(e.Phones) != 2 || e.Phones[1] != "89001112233" || e.Emails[0] != "ivan@mail.ru" || e.MyID != "abc" {
+		t.Errorf("Invalid entry %v", e)
+	}
+	e = entries[1]
+	if e.Name != "Anna Smirnova" || len(e.Phones) != 0 || e.MyID != "2" {
+		t.Errorf("Invalid entry %v", e)
+	}
+
+	if _, err := ParseAddressBook(FormatVCard, "BEGIN:VCARD\nFN:Ivan\n"); err == nil {
+		t.Errorf("vCard without end is parsed")
+	}
+	if _, err := ParseAddressBook("xml", ""); err == nil {
+		t.Errorf("Unknown format is parsed")
+	}
+}
+
+// TestParseCSV checks columns from header and without it
+func TestParseCSV(t *testing.T) {
+	entries, err := ParseAddressBook(FormatCSV, "E-mail 1 - Value,Name,Phone 1 - Value,id\nivan@mail.ru,Ivan,\"+79123456789 ::: 89001112233\",10\n")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(entries) != 1 || entries[0].Name != "Ivan" || len(entries[0].Phones) != 2 || entries[0].Emails[0] != "ivan@mail.ru" || entries[0].MyID != "10" {
+		t.Errorf("Invalid entries %v", entries)
+	}
+
+	entries, err = ParseAddressBook(FormatCSV, "Anna,+79001112233,anna@mail.ru\nOleg,\"1; 2\"\n")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(entries) != 2 || entries[0].Name != "Anna" || entries[0].Phones[0] != "+79001112233" || entries[0].MyID != "1" ||
+		len(entries[1].Phones) != 2 || entries[1].MyID != "2" {
+		t.Errorf("Invalid entries %v", entries)
+	}
+
+	// Names and values which start like headers are not a header
+	entries, err = ParseAddressBook(FormatCSV, "Tellman,+79001112233,emailer@mail.ru\nPhoner,+79003334455\n")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(entries) != 2 || entries[0].Name != "Tellman" || entries[0].Phones[0] != "+79001112233" || entries[1].Name != "Phoner" {
+		t.Errorf("Invalid entries %v", entries)
+	}
+
+	if _, err := ParseAddressBook(FormatCSV, "a,\"b\n"); err == nil {
+		t.Errorf("Invalid CSV is parsed")
+	}
+}
+
+// TestClientImportAddressBook checks import of vCard and CSV by request
+func TestClientImportAddressBook(t *testing.T) {
+	gServer = newServer()
+	for i := 1; i <= 2; i++ {
+		c := NewTestClient(newTestConn())
+		login := fmt.Sprintf("user%v", i)
+		gServer.Register(c, login, "pass", login)
+		c.Auth(login, "pass")
+		c.SetUserInfo("", login+"@mail.ru", fmt.Sprintf("+7900%v", i), "")
+		verifyContacts(c)
+	}
+
+	server, client := net.Pipe()
+	defer client.Close()
+	c := NewClient(server)
+	listenTest(t, c)
+	dec := json.NewDecoder(client)
+	readAction(dec)
+
+	request := func(req CltImport) SrvListOfUsers {
+		data, _ := json.Marshal(struct {
+			Action string    `json:"action"`
+			Data   CltImport `json:"data"`
+		}{"import", req})
+		go client.Write(data)
+		for {
+			var m struct {
+				Action string         `json:"action"`
+				Data   SrvListOfUsers `json:"data"`
+			}
+			if err := dec.Decode(&m); err != nil {
+				t.Fatalf("%v", err)
+			}
+			if m.Action == "import" {
+				return m.Data
+			}
+		}
+	}
+	fmt.Fprint(client, `{"action":"register","data":{"login":"user3","pass":"pass","nick":"user3"}}`)
+
+	// One entry matches one user even with several phones and emails
+	vcf := "BEGIN:VCARD\nFN:One\nEMAIL:none@mail.ru\nTEL:+79002\nTEL:+79001\nUID:a\nEND:VCARD\n" +
+		"BEGIN:VCARD\nFN:Two\nEMAIL:user1@mail.ru\nEND:VCARD\n"
+	list := request(CltImport{Format: FormatVCard, Payload: vcf})
+	if list.Status != ErrOK || len(list.Users) != 2 || list.Users[0].Uid != "user2" || list.Users[0].MyID != "a" ||
+		list.Users[1].Uid != "user1" || list.Users[1].MyID != "2" {
+		t.Errorf("Invalid answer %+v", list)
+	}
+
+	list = request(CltImport{Format: FormatCSV, Payload: "phone\n+79001\n+79003\n"})
+	if list.Status != ErrOK || len(list.Users) != 1 || list.Users[0].Uid != "user1" || list.Users[0].MyID != "1" {
+		t.Errorf("Invalid answer %+v", list)
+	}
+
+	list = request(CltImport{Format: "xml", Payload: "<contacts/>"})
+	if list.Status != ErrInvalidData {
+		t.Errorf("Invalid answer %+v", list)
+	}
+}
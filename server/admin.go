@@ -0,0 +1,502 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Requests of admin API
+
+type AdminUidReq struct {
+	User string `json:"uid"`
+}
+
+type AdminBanReq struct {
+	User     string `json:"uid"`
+	Duration int    `json:"duration"` // Seconds, 0 - forever
+}
+
+type AdminPasswordReq struct {
+	User string `json:"uid"`
+	Pass string `json:"pass"`
+}
+
+type AdminRoleReq struct {
+	User string `json:"uid"`
+	Role Role   `json:"role"`
+}
+
+type AdminBotReq struct {
+	Login string `json:"login"`
+	Nick  string `json:"nick"`
+}
+
+type AdminBotAnswer struct {
+	Uid   string `json:"uid"`
+	Token string `json:"token"`
+	SrvStatusMessage
+}
+
+type AdminBroadcastReq struct {
+	Body   string       `json:"body"` // Announcement as ev_message, other fields are for ev_system
+	Kind   string       `json:"kind"`
+	Text   string       `json:"text"`
+	Target NotifyTarget `json:"target"`
+	Users  []string     `json:"users"`
+}
+
+type AdminMOTDReq struct {
+	Motd string `json:"motd"`
+}
+
+type AdminWelcomeReq struct {
+	Message string `json:"message"`
+}
+
+// Answers of admin API
+
+type AdminClientData struct {
+	User      string `json:"uid"`
+	Nick      string `json:"nick"`
+	Ip        string `json:"ip"`
+	Connected bool   `json:"connected"`
+	Queue     int    `json:"queue"`
+	Banned    bool   `json:"banned"`
+	Role      Role   `json:"role"`
+}
+
+type AdminBroadcastAnswer struct {
+	Status    int    `json:"status"`
+	Error     string `json:"error"`
+	Receivers int    `json:"receivers"`
+}
+
+type AdminReindexAnswer struct {
+	Changed   int `json:"changed"`   // Emails and phones changed by normalization
+	Conflicts int `json:"conflicts"` // Emails and phones of several users
+	SrvStatusMessage
+}
+
+type AdminStats struct {
+	Users      int        `json:"users"`
+	Clients    int        `json:"clients"`
+	Connected  int        `json:"connected"`
+	Banned     int        `json:"banned"`
+	Messages   int64      `json:"messages"`
+	Goroutines int        `json:"goroutines"`
+	Uptime     int        `json:"uptime"`
+	Queues     QueueStats `json:"queues"`
+}
+
+// Banned checks if user is banned
+func (s *MessageServer) Banned(uid string) bool {
+	s.adminMutex.Lock()
+	defer s.adminMutex.Unlock()
+
+	end, ok := s.bans[uid]
+	if !ok {
+		return false
+	}
+	if !end.IsZero() && time.Now().After(end) {
+		delete(s.bans, uid)
+		return false
+	}
+	return true
+}
+
+// Ban forbids auth of user for duration (0 - forever) and disconnects him
+func (s *MessageServer) Ban(uid string, duration time.Duration) bool {
+	if !s.Exists(uid) {
+		return false
+	}
+	var end time.Time
+	if duration > 0 {
+		end = time.Now().Add(duration)
+	}
+	s.adminMutex.Lock()
+	s.bans[uid] = end
+	s.adminMutex.Unlock()
+
+	s.Kick(uid)
+	logger(LogServer).Warn("User is banned", "uid", uid, "duration", duration)
+	return true
+}
+
+// Unban allows auth of user
+func (s *MessageServer) Unban(uid string) bool {
+	s.adminMutex.Lock()
+	defer s.adminMutex.Unlock()
+
+	_, ok := s.bans[uid]
+	delete(s.bans, uid)
+	return ok
+}
+
+// Kick closes connection of user
+func (s *MessageServer) Kick(uid string) bool {
+	c, ok := s.GetUserData(uid)
+	if !ok {
+		return false
+	}
+	c.Disconnect()
+	logger(LogServer).Info("User is kicked", "uid", uid)
+	return true
+}
+
+// DeleteAccount removes user, his profile, role and tokens. Messages stay in history.
+func (s *MessageServer) DeleteAccount(uid string) bool {
+	nick, ok := s.RemoveAccount(uid)
+	if !ok {
+		return false
+	}
+	s.clientsMutex.Lock()
+	c, ok := s.Clients[uid]
+	delete(s.Clients, uid)
+	s.clientsMutex.Unlock()
+	if ok {
+		c.Disconnect()
+	}
+
+	s.adminMutex.Lock()
+	delete(s.bans, uid)
+	s.adminMutex.Unlock()
+
+	s.botsMutex.Lock()
+	delete(s.bots, uid)
+	for hash, owner := range s.botTokens {
+		if owner == uid {
+			delete(s.botTokens, hash)
+		}
+	}
+	s.botsMutex.Unlock()
+	s.keys.Remove(uid)
+	s.verifier.Remove(uid)
+	s.conversations.Remove(uid)
+	s.events.Remove(uid)
+
+	logger(LogServer).Info("Account is deleted", "uid", uid)
+	s.webhooks.Emit(EventAccountDeleted, WebhookUserData{Uid: uid, Nick: nick})
+	return true
+}
+
+// ResetPassword sets new password of user
+func (s *MessageServer) ResetPassword(uid string, pass string) bool {
+	if !s.SetPassword(uid, pass) {
+		return false
+	}
+	logger(LogServer).Info("Password is reset", "uid", uid)
+	return true
+}
+
+// adminData returns snapshot of session, it is taken under lock because session is
+// changed by its own goroutines
+func (c *Client) adminData() AdminClientData {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return AdminClientData{
+		User:      c.cid,
+		Nick:      c.nick,
+		Ip:        c.ip,
+		Connected: c.connected,
+	}
+}
+
+// AdminClients returns users sorted by uid
+func (s *MessageServer) AdminClients() []AdminClientData {
+	list := make([]AdminClientData, 0)
+	for _, c := range s.clientList() {
+		data := c.adminData()
+		data.Queue = c.QueueDepth()
+		data.Banned = s.Banned(data.User)
+		data.Role = s.Role(data.User)
+		list = append(list, data)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].User < list[j].User })
+	return list
+}
+
+// AdminStats returns state of server
+func (s *MessageServer) AdminStats() AdminStats {
+	stats := AdminStats{
+		Users:      s.CountUsers(),
+		Messages:   atomic.LoadInt64(&s.metrics.messages),
+		Goroutines: runtime.NumGoroutine(),
+		Uptime:     int(time.Since(s.metrics.startTime).Seconds()),
+		Queues:     s.QueueStats(),
+	}
+	for _, c := range s.clientList() {
+		stats.Clients++
+		if c.isConnected() {
+			stats.Connected++
+		}
+	}
+	s.adminMutex.Lock()
+	stats.Banned = len(s.bans)
+	s.adminMutex.Unlock()
+	return stats
+}
+
+// adminHandler is a handler of admin API
+type adminHandler struct {
+	s     *MessageServer
+	token string
+	mux   *http.ServeMux
+}
+
+// NewAdminHandler is constructor of handler of admin API.
+// Requests have to contain header "Authorization: Bearer <token>".
+func NewAdminHandler(s *MessageServer, token string) http.Handler {
+	h := &adminHandler{s: s, token: token, mux: http.NewServeMux()}
+	h.mux.HandleFunc("/admin/clients", h.clients)
+	h.mux.HandleFunc("/admin/stats", h.stats)
+	h.mux.HandleFunc("/admin/kick", h.kick)
+	h.mux.HandleFunc("/admin/ban", h.ban)
+	h.mux.HandleFunc("/admin/unban", h.unban)
+	h.mux.HandleFunc("/admin/password", h.password)
+	h.mux.HandleFunc("/admin/role", h.role)
+	h.mux.HandleFunc("/admin/delete", h.deleteAccount)
+	h.mux.HandleFunc("/admin/bots", h.createBot)
+	h.mux.HandleFunc("/admin/bottoken", h.botToken)
+	h.mux.HandleFunc("/admin/broadcast", h.broadcast)
+	h.mux.HandleFunc("/admin/motd", h.motd)
+	h.mux.HandleFunc("/admin/welcome", h.welcome)
+	h.mux.HandleFunc("/admin/reindex", h.reindex)
+	return h
+}
+
+// ServeHTTP checks token and routes request
+func (h *adminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if h.token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+		logger(LogServer).Warn("Admin auth failed", "ip", r.RemoteAddr)
+		writeStatus(w, http.StatusUnauthorized, ErrAccessDenied, "Access denied")
+		return
+	}
+	logger(LogServer).Info("Admin request", "ip", r.RemoteAddr, "path", r.URL.Path)
+	h.mux.ServeHTTP(w, r)
+}
+
+// writeJSON writes answer of http API as JSON
+func writeJSON(w http.ResponseWriter, code int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	err := json.NewEncoder(w).Encode(data)
+	CheckError(err, "Can't write http answer", false)
+}
+
+// writeStatus writes status answer of http API
+func writeStatus(w http.ResponseWriter, code int, status int, text string) {
+	writeJSON(w, code, SrvStatusMessage{Status: status, Error: text})
+}
+
+// ok writes status OK or user not found
+func (h *adminHandler) ok(w http.ResponseWriter, found bool) {
+	if !found {
+		writeStatus(w, http.StatusNotFound, ErrUserNotFound, "User not found")
+		return
+	}
+	writeStatus(w, http.StatusOK, ErrOK, "OK")
+}
+
+// decodeJSON reads JSON body of POST request to http API
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if r.Method != http.MethodPost {
+		writeStatus(w, http.StatusMethodNotAllowed, ErrInvalidData, "POST is required")
+		return false
+	}
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(v)
+	if !CheckError(err, "Invalid http request", false) {
+		writeStatus(w, http.StatusBadRequest, ErrInvalidData, "Invalid data")
+		return false
+	}
+	return true
+}
+
+func (h *adminHandler) clients(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.s.AdminClients())
+}
+
+func (h *adminHandler) stats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.s.AdminStats())
+}
+
+func (h *adminHandler) kick(w http.ResponseWriter, r *http.Request) {
+	var req AdminUidReq
+	if decodeJSON(w, r, &req) {
+		h.ok(w, h.s.Kick(req.User))
+	}
+}
+
+func (h *adminHandler) ban(w http.ResponseWriter, r *http.Request) {
+	var req AdminBanReq
+	if decodeJSON(w, r, &req) {
+		h.ok(w, h.s.Ban(req.User, time.Duration(req.Duration)*time.Second))
+	}
+}
+
+func (h *adminHandler) unban(w http.ResponseWriter, r *http.Request) {
+	var req AdminUidReq
+	if decodeJSON(w, r, &req) {
+		h.ok(w, h.s.Unban(req.User))
+	}
+}
+
+func (h *adminHandler) deleteAccount(w http.ResponseWriter, r *http.Request) {
+	var req AdminUidReq
+	if decodeJSON(w, r, &req) {
+		h.ok(w, h.s.DeleteAccount(req.User))
+	}
+}
+
+func (h *adminHandler) password(w http.ResponseWriter, r *http.Request) {
+	var req AdminPasswordReq
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Pass == "" {
+		writeStatus(w, http.StatusBadRequest, ErrEmptyField, "Empty field")
+		return
+	}
+	h.ok(w, h.s.ResetPassword(req.User, req.Pass))
+}
+
+func (h *adminHandler) role(w http.ResponseWriter, r *http.Request) {
+	var req AdminRoleReq
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	status, err := h.s.setRole(req.User, req.Role)
+	switch status {
+	case ErrOK:
+		h.ok(w, true)
+	case ErrUserNotFound:
+		h.ok(w, false)
+	default:
+		writeStatus(w, http.StatusBadRequest, status, err.Error())
+	}
+}
+
+func (h *adminHandler) createBot(w http.ResponseWriter, r *http.Request) {
+	var req AdminBotReq
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	token, status, err := h.s.CreateBot(req.Login, req.Nick)
+	h.botAnswer(w, req.Login, token, status, err)
+}
+
+func (h *adminHandler) botToken(w http.ResponseWriter, r *http.Request) {
+	var req AdminUidReq
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	token, status, err := h.s.RotateBotToken(req.User)
+	h.botAnswer(w, req.User, token, status, err)
+}
+
+// botAnswer writes API token of bot or error
+func (h *adminHandler) botAnswer(w http.ResponseWriter, uid string, token string, status int, err error) {
+	switch {
+	case err == nil:
+		answer := AdminBotAnswer{Uid: uid, Token: token}
+		answer.Status = ErrOK
+		answer.Error = "OK"
+		writeJSON(w, http.StatusOK, answer)
+	case status == ErrUserNotFound:
+		writeStatus(w, http.StatusNotFound, status, err.Error())
+	default:
+		writeStatus(w, http.StatusBadRequest, status, err.Error())
+	}
+}
+
+func (h *adminHandler) broadcast(w http.ResponseWriter, r *http.Request) {
+	var req AdminBroadcastReq
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Body != "" {
+		count := h.s.Broadcast(req.Body)
+		writeJSON(w, http.StatusOK, AdminBroadcastAnswer{Status: ErrOK, Error: "OK", Receivers: count})
+		return
+	}
+	if req.Text == "" {
+		writeStatus(w, http.StatusBadRequest, ErrEmptyField, "Text is empty")
+		return
+	}
+	count, err := h.s.Notify(SystemNotification{
+		Kind:   req.Kind,
+		Text:   req.Text,
+		Target: req.Target,
+		Users:  req.Users,
+	})
+	if err != nil {
+		writeStatus(w, http.StatusBadRequest, ErrInvalidData, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, AdminBroadcastAnswer{Status: ErrOK, Error: "OK", Receivers: count})
+}
+
+func (h *adminHandler) motd(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		writeJSON(w, http.StatusOK, AdminMOTDReq{Motd: h.s.MOTD()})
+		return
+	}
+	var req AdminMOTDReq
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Motd == "" {
+		writeStatus(w, http.StatusBadRequest, ErrEmptyField, "Message is empty")
+		return
+	}
+	h.s.SetMOTD(req.Motd)
+	h.ok(w, true)
+}
+
+// welcome is /admin/motd with text of welcome message in "message"
+func (h *adminHandler) welcome(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		writeJSON(w, http.StatusOK, AdminWelcomeReq{Message: h.s.MOTD()})
+		return
+	}
+	var req AdminWelcomeReq
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Message == "" {
+		writeStatus(w, http.StatusBadRequest, ErrEmptyField, "Message is empty")
+		return
+	}
+	h.s.SetMOTD(req.Message)
+	h.ok(w, true)
+}
+
+func (h *adminHandler) reindex(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeStatus(w, http.StatusMethodNotAllowed, ErrInvalidData, "POST is required")
+		return
+	}
+	answer := AdminReindexAnswer{}
+	answer.Changed, answer.Conflicts = h.s.Reindex()
+	answer.Status = ErrOK
+	answer.Error = "OK"
+	writeJSON(w, http.StatusOK, answer)
+}
+
+// StartAdmin starts http server with admin API on addr
+func (s *MessageServer) StartAdmin(addr string, token string) {
+	if token == "" {
+		logger(LogServer).Error("Admin API needs token", "addr", addr)
+		return
+	}
+	logger(LogServer).Info("Admin API start", "addr", addr)
+	err := http.ListenAndServe(addr, NewAdminHandler(s, token))
+	CheckError(err, "Can't start admin API", false)
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// adminRequest sends request to admin API and returns its answer
+func adminRequest(h http.Handler, token string, method string, path string, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// TestAdminAuth checks token of admin API
+func TestAdminAuth(t *testing.T) {
+	gServer = newServer()
+	h := NewAdminHandler(gServer, "secret")
+	if w := adminRequest(h, "bad", "GET", "/admin/stats", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Invalid token is accepted: %d", w.Code)
+	}
+	if w := adminRequest(NewAdminHandler(gServer, ""), "", "GET", "/admin/stats", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Empty token is accepted: %d", w.Code)
+	}
+	if w := adminRequest(h, "secret", "GET", "/admin/stats", ""); w.Code != http.StatusOK {
+		t.Errorf("Valid token is rejected: %d", w.Code)
+	}
+}
+
+// TestAdminBan checks ban, kick and password reset
+func TestAdminBan(t *testing.T) {
+	gServer = newServer()
+	h := NewAdminHandler(gServer, "secret")
+
+	conn := newTestConn()
+	c := NewTestClient(conn)
+	c.Register("login", "pass", "nick")
+	c.Flush()
+
+	w := adminRequest(h, "secret", "GET", "/admin/clients", "")
+	var clients []AdminClientData
+	if err := json.Unmarshal(w.Body.Bytes(), &clients); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(clients) != 1 || clients[0].User != "login" || !clients[0].Connected {
+		t.Errorf("Invalid clients %+v", clients)
+	}
+
+	if w := adminRequest(h, "secret", "POST", "/admin/ban", `{"uid":"unknown"}`); w.Code != http.StatusNotFound {
+		t.Errorf("Unknown user is banned: %d", w.Code)
+	}
+	if w := adminRequest(h, "secret", "POST", "/admin/ban", `{"uid":"login","duration":60}`); w.Code != http.StatusOK {
+		t.Errorf("User is not banned: %d", w.Code)
+	}
+	if !conn.Closed {
+		t.Errorf("Banned user is not disconnected")
+	}
+	if _, status, _ := gServer.Auth(NewClient(newTestConn()), "login", "pass"); status != ErrAccessDenied {
+		t.Errorf("Banned user is authorized: %d", status)
+	}
+
+	adminRequest(h, "secret", "POST", "/admin/unban", `{"uid":"login"}`)
+	adminRequest(h, "secret", "POST", "/admin/password", `{"uid":"login","pass":"new"}`)
+	if _, status, _ := gServer.Auth(NewClient(newTestConn()), "login", "pass"); status != ErrInvalidPass {
+		t.Errorf("Old password is accepted: %d", status)
+	}
+	if _, status, _ := gServer.Auth(NewClient(newTestConn()), "login", "new"); status != ErrOK {
+		t.Errorf("New password is rejected: %d", status)
+	}
+}
+
+// TestAdminConcurrent checks that admin API reads sessions while they are changed by
+// their goroutines, test is useful with -race
+func TestAdminConcurrent(t *testing.T) {
+	gServer = newServer()
+	h := NewAdminHandler(gServer, "secret")
+	gServer.Register(NewTestClient(newTestConn()), "login", "pass", "nick")
+
+	server, client := net.Pipe()
+	defer client.Close()
+	c := NewClient(server)
+	gServer.Clients["other"] = c
+	listenTest(t, c)
+
+	dec := json.NewDecoder(client)
+	readAction(dec)
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				adminRequest(h, "secret", "GET", "/admin/clients", "")
+				adminRequest(h, "secret", "GET", "/admin/stats", "")
+			}
+		}
+	}()
+	fmt.Fprint(client, `{"action":"auth","data":{"login":"login","pass":"pass"}}`)
+	if action := readAction(dec); action != "auth" {
+		t.Errorf("Invalid answer to auth '%s'", action)
+	}
+	close(stop)
+	<-done
+
+	if w := adminRequest(h, "secret", "POST", "/admin/kick", `{"uid":"login"}`); w.Code != http.StatusOK {
+		t.Errorf("User is not kicked: %d", w.Code)
+	}
+	if !waitDisconnect(c) {
+		t.Errorf("Kicked user is not disconnected")
+	}
+}
+
+// TestAdminBanExpired checks end of ban
+func TestAdminBanExpired(t *testing.T) {
+	gServer = newServer()
+	gServer.Logins["login"] = "nick"
+	gServer.Ban("login", time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if gServer.Banned("login") {
+		t.Errorf("Ban is not expired")
+	}
+}
+
+// TestAdminBroadcast checks announcement and message of the day
+func TestAdminBroadcast(t *testing.T) {
+	gServer = newServer()
+	h := NewAdminHandler(gServer, "secret")
+
+	conn := newTestConn()
+	c := NewTestClient(conn)
+	gServer.Clients["user"] = c
+	w := adminRequest(h, "secret", "POST", "/admin/broadcast", `{"kind":"maintenance","text":"Restart"}`)
+	var answer AdminBroadcastAnswer
+	if err := json.Unmarshal(w.Body.Bytes(), &answer); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if w.Code != http.StatusOK || answer.Receivers != 1 {
+		t.Errorf("Broadcast is failed: %d %+v", w.Code, answer)
+	}
+	c.Flush()
+	var m struct {
+		Action string      `json:"action"`
+		Data   EvSrvSystem `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(conn.Messages[len(conn.Messages)-1]), &m); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if m.Action != "ev_system" || m.Data.Text != "Restart" || m.Data.Kind != NotifyMaintenance || m.Data.Id == "" {
+		t.Errorf("Invalid announcement %+v", m)
+	}
+	if w := adminRequest(h, "secret", "POST", "/admin/broadcast", `{"text":"x","target":"bad"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Invalid target is accepted: %d", w.Code)
+	}
+
+	w = adminRequest(h, "secret", "POST", "/admin/broadcast", `{"body":"Maintenance"}`)
+	if w.Code != http.StatusOK {
+		t.Errorf("Broadcast of message is failed: %d %s", w.Code, w.Body.String())
+	}
+	c.Flush()
+	var ev struct {
+		Action string       `json:"action"`
+		Data   EvSrvMessage `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(conn.Messages[len(conn.Messages)-1]), &ev); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if ev.Action != "ev_message" || ev.Data.Body != "Maintenance" || ev.Data.Nick != serverNick || ev.Data.From != "" || ev.Data.Mid == "" {
+		t.Errorf("Invalid announcement %+v", ev)
+	}
+
+	adminRequest(h, "secret", "POST", "/admin/motd", `{"motd":"Hello"}`)
+	if gServer.MOTD() != "Hello" {
+		t.Errorf("MOTD is not changed")
+	}
+	adminRequest(h, "secret", "POST", "/admin/welcome", `{"message":"Welcome"}`)
+	w = adminRequest(h, "secret", "GET", "/admin/welcome", "")
+	if gServer.MOTD() != "Welcome" || !strings.Contains(w.Body.String(), `"message":"Welcome"`) {
+		t.Errorf("Welcome message is not changed: %s", w.Body.String())
+	}
+	if w := adminRequest(h, "secret", "GET", "/admin/kick", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET is allowed for kick: %d", w.Code)
+	}
+}
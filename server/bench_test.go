@@ -0,0 +1,105 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net"
+	"testing"
+)
+
+// benchConn is a connection which discards written data
+type benchConn struct {
+	testConn
+}
+
+func (c *benchConn) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+// newBenchServer creates server without rate limits and log
+func newBenchServer() {
+	config := DefaultConfig()
+	config.RateLimits = nil
+	config.LogOutput = io.Discard
+	config.HeartbeatInterval = 0
+	gServer = newServerWithConfig(config)
+}
+
+// newBenchClient registers user with connection which discards answers
+func newBenchClient(login string) *Client {
+	c := NewTestClient(&benchConn{testConn: *newTestConn()})
+	c.Register(login, "pass", login)
+	return c
+}
+
+// benchmarkSendMessage sends messages with attachment of size between two users
+func benchmarkSendMessage(b *testing.B, size int) {
+	newBenchServer()
+	c1 := newBenchClient("user1")
+	c2 := newBenchClient("user2")
+	attach := AttachData{}
+	if size > 0 {
+		attach = AttachData{Mime: "image/png", Data: base64.StdEncoding.EncodeToString(make([]byte, size))}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		gServer.SendMessage(c1, "user2", "hello", attach, MessageLinks{}, nil)
+	}
+	c1.Flush()
+	c2.Flush()
+}
+
+func BenchmarkSendMessage(b *testing.B)       { benchmarkSendMessage(b, 0) }
+func BenchmarkSendMessageAttach(b *testing.B) { benchmarkSendMessage(b, 64<<10) }
+
+// BenchmarkClientWrite checks send queue and write goroutine
+func BenchmarkClientWrite(b *testing.B) {
+	newBenchServer()
+	c := newBenchClient("user")
+	data := []byte(`{"action":"ev_message","data":{"mid":"1","from":"user","nick":"user","body":"hello","time":0}}`)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.Send(data)
+	}
+	c.Flush()
+}
+
+// BenchmarkClientRead checks parsing and handling of requests in read loop
+func BenchmarkClientRead(b *testing.B) {
+	newBenchServer()
+	server, user := net.Pipe()
+	c := NewClient(server)
+	listenTest(b, c)
+	defer user.Close()
+
+	// Answers are read to keep write goroutine going
+	answers := make(chan struct{})
+	go func() {
+		dec := json.NewDecoder(user)
+		for {
+			var m SrvMessage
+			if err := dec.Decode(&m); err != nil {
+				return
+			}
+			if m.Action == "pong" {
+				answers <- struct{}{}
+			}
+		}
+	}()
+	request := []byte(`{"action":"ping","data":{}}`)
+	b.ReportAllocs()
+	b.ResetTimer()
+	go func() {
+		for i := 0; i < b.N; i++ {
+			if _, err := user.Write(request); err != nil {
+				return
+			}
+		}
+	}()
+	for i := 0; i < b.N; i++ {
+		<-answers
+	}
+}
@@ -0,0 +1,381 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limits of bot API
+const (
+	maxBotUpdates  = 1000             // Max count of updates kept for bot, the oldest are dropped
+	maxBotPoll     = 60 * time.Second // Max time of long-poll of updates
+	botHookTimeout = 5 * time.Second  // Timeout of delivery of update to webhook of bot
+)
+
+// Bot is an automated account which works through HTTP bot API
+type Bot struct {
+	uid     string
+	client  *Client           // Client of bot in server, its send queue is written to updates
+	mutex   sync.Mutex        // Guards updates, notify and webhook
+	updates []json.RawMessage // Events waiting for long-poll
+	notify  chan struct{}     // Closed when new update arrives
+	webhook string            // Url for updates ("" - long-poll only)
+	hook    *http.Client      // Client of webhook, it checks addresses of connections
+}
+
+// newBot is constructor of Bot
+func newBot(uid string, nick string) *Bot {
+	b := &Bot{
+		uid:     uid,
+		updates: make([]json.RawMessage, 0),
+		notify:  make(chan struct{}),
+	}
+	b.client = NewClient(&botConn{bot: b})
+	b.hook = newHookClient(botHookTimeout, b.client.config().BotWebhookPrivate)
+	b.client.uid = uid
+	b.client.login = uid
+	b.client.cid = uid
+	b.client.nick = nick
+	go b.client.write()
+	return b
+}
+
+// push delivers event to webhook or keeps it for long-poll
+func (b *Bot) push(data []byte) {
+	event := make(json.RawMessage, len(data))
+	copy(event, data)
+
+	b.mutex.Lock()
+	webhook := b.webhook
+	b.mutex.Unlock()
+	if webhook != "" && b.post(webhook, event) {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.updates = append(b.updates, event)
+	if len(b.updates) > maxBotUpdates {
+		b.updates = b.updates[len(b.updates)-maxBotUpdates:]
+	}
+	close(b.notify)
+	b.notify = make(chan struct{})
+}
+
+// post sends event to webhook of bot
+func (b *Bot) post(url string, event json.RawMessage) bool {
+	resp, err := b.hook.Post(url, "application/json", bytes.NewReader(event))
+	if err != nil {
+		logger(LogServer).Warn("Bot webhook failed", "uid", b.uid, "err", err)
+		return false
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		logger(LogServer).Warn("Bot webhook failed", "uid", b.uid, "code", resp.StatusCode)
+		return false
+	}
+	return true
+}
+
+// Updates waits up to timeout for events and takes them
+func (b *Bot) Updates(timeout time.Duration) []json.RawMessage {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		b.mutex.Lock()
+		if len(b.updates) > 0 || timeout <= 0 {
+			updates := b.updates
+			b.updates = make([]json.RawMessage, 0)
+			b.mutex.Unlock()
+			return updates
+		}
+		notify := b.notify
+		b.mutex.Unlock()
+
+		select {
+		case <-notify:
+		case <-timer.C:
+			timeout = 0
+		}
+	}
+}
+
+// SetWebhook changes url for updates ("" - long-poll only)
+func (b *Bot) SetWebhook(url string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.webhook = url
+}
+
+// botConn is a connection of bot Client, written data goes to updates of bot
+type botConn struct {
+	bot *Bot
+}
+
+func (c *botConn) Read(b []byte) (int, error) {
+	return 0, errors.New("Bot connection can't be read")
+}
+
+func (c *botConn) Write(b []byte) (int, error) {
+	c.bot.push(b)
+	return len(b), nil
+}
+
+func (c *botConn) Close() error                       { return nil }
+func (c *botConn) LocalAddr() net.Addr                { return botAddr{} }
+func (c *botConn) RemoteAddr() net.Addr               { return botAddr{} }
+func (c *botConn) SetDeadline(t time.Time) error      { return nil }
+func (c *botConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *botConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// botAddr is an address of bot connection
+type botAddr struct{}
+
+func (botAddr) Network() string { return "bot" }
+func (botAddr) String() string  { return "bot" }
+
+// newBotToken generates API token of bot and returns it with its hash
+func newBotToken(uid string) (string, string, error) {
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+	token := uid + ":" + hex.EncodeToString(secret)
+	return token, hashBotToken(token), nil
+}
+
+// hashBotToken returns hash of token which is kept on server
+func hashBotToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateBot registers bot account and returns its API token
+func (s *MessageServer) CreateBot(login string, nick string) (string, int, error) {
+	if login == "" || nick == "" {
+		return "", ErrEmptyField, errors.New("Empty field")
+	}
+	token, hash, err := newBotToken(login)
+	if err != nil {
+		return "", ErrInvalidData, err
+	}
+	// Bot has no password, it can't auth by protocol
+	if status, err := s.AddAccount(login, "", nick); err != nil {
+		return "", status, err
+	}
+	s.UpdateAccount(login, func(a *Account) { a.Role = RoleBot })
+
+	b := newBot(login, nick)
+	s.clientsMutex.Lock()
+	s.Clients[login] = b.client
+	s.clientsMutex.Unlock()
+
+	s.botsMutex.Lock()
+	s.bots[login] = b
+	s.botTokens[hash] = login
+	s.botsMutex.Unlock()
+
+	logger(LogServer).Info("Bot is created", "uid", login)
+	return token, ErrOK, nil
+}
+
+// RotateBotToken replaces API token of bot
+func (s *MessageServer) RotateBotToken(uid string) (string, int, error) {
+	s.botsMutex.Lock()
+	defer s.botsMutex.Unlock()
+
+	if _, ok := s.bots[uid]; !ok {
+		return "", ErrUserNotFound, errors.New("Bot not found")
+	}
+	token, hash, err := newBotToken(uid)
+	if err != nil {
+		return "", ErrInvalidData, err
+	}
+	for old, owner := range s.botTokens {
+		if owner == uid {
+			delete(s.botTokens, old)
+		}
+	}
+	s.botTokens[hash] = uid
+	return token, ErrOK, nil
+}
+
+// BotByToken finds bot by API token
+func (s *MessageServer) BotByToken(token string) (*Bot, bool) {
+	s.botsMutex.RLock()
+	defer s.botsMutex.RUnlock()
+
+	uid, ok := s.botTokens[hashBotToken(token)]
+	if !ok {
+		return nil, false
+	}
+	b, ok := s.bots[uid]
+	return b, ok
+}
+
+// IsBot checks if user is a bot
+func (s *MessageServer) IsBot(uid string) bool {
+	s.botsMutex.RLock()
+	defer s.botsMutex.RUnlock()
+
+	_, ok := s.bots[uid]
+	return ok
+}
+
+// Requests and answers of bot API
+
+type BotMessageReq struct {
+	User      string     `json:"uid"`
+	Body      string     `json:"body"`
+	Attach    AttachData `json:"attach"`
+	Envelopes []Envelope `json:"envelopes,omitempty"`
+	MessageLinks
+}
+
+type BotWebhookReq struct {
+	Url string `json:"url"`
+}
+
+type BotMessageAnswer struct {
+	Mid string `json:"mid"`
+	SrvStatusMessage
+}
+
+type BotUpdatesAnswer struct {
+	Updates []json.RawMessage `json:"updates"`
+	SrvStatusMessage
+}
+
+type BotInfo struct {
+	Uid     string `json:"uid"`
+	Nick    string `json:"nick"`
+	Webhook string `json:"webhook"`
+}
+
+// botHandler is a handler of bot API
+type botHandler struct {
+	s   *MessageServer
+	mux *http.ServeMux
+}
+
+// NewBotHandler is constructor of handler of bot API.
+// Requests have to contain header "Authorization: Bearer <bot token>".
+func NewBotHandler(s *MessageServer) http.Handler {
+	h := &botHandler{s: s, mux: http.NewServeMux()}
+	h.mux.HandleFunc("/bot/me", h.me)
+	h.mux.HandleFunc("/bot/message", h.message)
+	h.mux.HandleFunc("/bot/updates", h.updates)
+	h.mux.HandleFunc("/bot/webhook", h.webhook)
+	return h
+}
+
+// botKey is a key of bot in context of request
+type botKey struct{}
+
+// contextWithBot returns context of request with authorized bot
+func contextWithBot(r *http.Request, b *Bot) context.Context {
+	return context.WithValue(r.Context(), botKey{}, b)
+}
+
+// bot returns bot of authorized request
+func (h *botHandler) bot(r *http.Request) *Bot {
+	return r.Context().Value(botKey{}).(*Bot)
+}
+
+// ServeHTTP checks token and routes request
+func (h *botHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	b, ok := h.s.BotByToken(token)
+	if !ok {
+		logger(LogServer).Warn("Bot auth failed", "ip", r.RemoteAddr)
+		writeStatus(w, http.StatusUnauthorized, ErrAccessDenied, "Access denied")
+		return
+	}
+	h.mux.ServeHTTP(w, r.WithContext(contextWithBot(r, b)))
+}
+
+func (h *botHandler) me(w http.ResponseWriter, r *http.Request) {
+	b := h.bot(r)
+	b.mutex.Lock()
+	info := BotInfo{Uid: b.uid, Nick: b.client.nick, Webhook: b.webhook}
+	b.mutex.Unlock()
+	writeJSON(w, http.StatusOK, info)
+}
+
+func (h *botHandler) message(w http.ResponseWriter, r *http.Request) {
+	b := h.bot(r)
+	var req BotMessageReq
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if ok, wait := h.s.limiter.Allow("message", b.uid, 1); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		writeStatus(w, http.StatusTooManyRequests, ErrRateLimited, "Too many requests")
+		return
+	}
+	msg, status, err := h.s.prepareMessage(b.client, req.User, req.Body, req.Attach, req.MessageLinks, req.Envelopes)
+	if err != nil {
+		code := http.StatusBadRequest
+		if status == ErrUserNotFound || status == ErrMessageNotFound {
+			code = http.StatusNotFound
+		}
+		writeStatus(w, code, status, err.Error())
+		return
+	}
+	h.s.dispatchMessage(b.client, msg)
+
+	answer := BotMessageAnswer{Mid: msg.Mid}
+	answer.Status = ErrOK
+	answer.Error = "OK"
+	writeJSON(w, http.StatusOK, answer)
+}
+
+func (h *botHandler) updates(w http.ResponseWriter, r *http.Request) {
+	timeout := time.Duration(0)
+	if t, err := strconv.Atoi(r.URL.Query().Get("timeout")); err == nil && t > 0 {
+		timeout = time.Duration(t) * time.Second
+	}
+	if timeout > maxBotPoll {
+		timeout = maxBotPoll
+	}
+	answer := BotUpdatesAnswer{Updates: h.bot(r).Updates(timeout)}
+	answer.Status = ErrOK
+	answer.Error = "OK"
+	writeJSON(w, http.StatusOK, answer)
+}
+
+func (h *botHandler) webhook(w http.ResponseWriter, r *http.Request) {
+	var req BotWebhookReq
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Url != "" {
+		if err := checkWebhookURL(req.Url, h.s.config.BotWebhookPrivate); err != nil {
+			writeStatus(w, http.StatusBadRequest, ErrInvalidData, "Invalid url")
+			return
+		}
+	}
+	h.bot(r).SetWebhook(req.Url)
+	writeStatus(w, http.StatusOK, ErrOK, "OK")
+}
+
+// StartBotAPI starts http server with bot API on addr
+func (s *MessageServer) StartBotAPI(addr string) {
+	logger(LogServer).Info("Bot API start", "addr", addr)
+	err := http.ListenAndServe(addr, NewBotHandler(s))
+	CheckError(err, "Can't start bot API", false)
+}
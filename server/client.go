@@ -3,50 +3,189 @@ package server
 import (
 	"bufio"
 	"encoding/json"
-	"log"
+	"math"
 	"net"
+	"sync"
 	"time"
 )
 
 // Client class of client
 type Client struct {
-	conn   net.Conn // Connection to user socket
-	uid    string   // UserID
-	login  string   // Login of user
-	ip     string   // Ip of client
-	cid    string   // Client ID
-	sid    string   // Session ID
-	nick   string   // Nickname of user
-	status string   // Stirng of user's status
-	avatar string   // Picture of user
-	email  string   // User's email
-	phone  string   // User's phone
-
-	connected       bool // Connection user state
-	offlineMessages [][]byte
-
-	outgoing chan []byte
+	server *MessageServer // Server which accepted connection (nil - default settings)
+	conn   net.Conn       // Connection to user socket
+	uid    string         // UserID
+	login  string         // Login of user
+	ip     string         // Ip of client
+	cid    string         // Client ID
+	sid    string         // Session ID
+	nick   string         // Nickname of user
+	status string         // Stirng of user's status
+	avatar string         // Picture of user
+	email  string         // User's email
+	phone  string         // User's phone
+
+	emailVerified bool // Email is confirmed by code and is used by FindUser
+	phoneVerified bool // Phone is confirmed by code and is used by FindUser
+
+	requestID string // Id of current request in log records
+
+	connected       bool          // Connection user state
+	writing         bool          // Write goroutine is running
+	offlineMessages [][]byte      // Messages waiting for reconnect or free place in send queue
+	mutex           sync.Mutex    // Guards connected, writing, offlineMessages; uid, login, requestID, cid, nick, emails and phones for other goroutines
+	closed          chan struct{} // Closed on disconnect, write goroutine stops
+	stopped         chan struct{} // Closed when write goroutine has stopped
+
+	outgoing chan outMessage // Send queue, only write goroutine writes to connection
 	reader   *bufio.Reader
 	writer   *bufio.Writer
 	contacts map[string]string // Map of uids of users (key uid; value uid)
 }
 
-// write - send data to user
+// write - send data to user while connection is alive
 func (c *Client) write() {
-	for data := range c.outgoing {
-		if c.connected {
-			c.writer.Write(data)
-			c.writer.Flush()
-		} else {
-			c.offlineMessages = append(c.offlineMessages, data)
+	c.mutex.Lock()
+	c.writing = true
+	c.mutex.Unlock()
+	defer c.stopWriting()
+
+	for {
+		select {
+		case m := <-c.outgoing:
+			if m.data != nil && !c.deliver(m.data) {
+				c.keepOffline([][]byte{m.data})
+			}
+			if len(c.outgoing) == 0 || m.done != nil {
+				c.deliverSpilled()
+			}
+			if m.close {
+				c.Disconnect()
+			}
+			if m.done != nil {
+				close(m.done)
+			}
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+// stopWriting moves rest of send queue to offline storage when write goroutine stops
+func (c *Client) stopWriting() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.spillQueue()
+	c.writing = false
+	close(c.stopped)
+}
+
+// waitWriting waits until write goroutine stops, e.g. finishes message it writes
+func (c *Client) waitWriting() {
+	c.mutex.Lock()
+	writing, stopped := c.writing, c.stopped
+	c.mutex.Unlock()
+	if writing {
+		<-stopped
+	}
+}
+
+// isConnected checks that user is online
+func (c *Client) isConnected() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.connected
+}
+
+// deliver writes data to connection, result is false if user is offline or connection is lost
+func (c *Client) deliver(data []byte) bool {
+	if !c.isConnected() {
+		return false
+	}
+	c.setWriteDeadline()
+	_, err := c.writer.Write(data)
+	if err == nil {
+		err = c.writer.Flush()
+	}
+	if err != nil {
+		c.connLost(err)
+		c.writer.Reset(c.conn)
+		return false
+	}
+	c.metrics().BytesOut(len(data))
+	return true
+}
+
+// keepOffline keeps undelivered messages till reconnect. They are older than messages
+// already in offline storage (e.g. rest of send queue), so they go first.
+func (c *Client) keepOffline(messages [][]byte) {
+	c.mutex.Lock()
+	c.offlineMessages = append(messages, c.offlineMessages...)
+	c.mutex.Unlock()
+}
+
+// deliverSpilled writes messages which didn't fit in send queue
+func (c *Client) deliverSpilled() {
+	c.mutex.Lock()
+	if !c.connected || len(c.offlineMessages) == 0 {
+		c.mutex.Unlock()
+		return
+	}
+	messages := c.offlineMessages
+	c.offlineMessages = make([][]byte, 0)
+	c.mutex.Unlock()
+
+	for i, data := range messages {
+		if !c.deliver(data) {
+			c.keepOffline(messages[i:])
+			return
+		}
+	}
+}
+
+// takeOfflineMessages returns and clears offline messages
+func (c *Client) takeOfflineMessages() [][]byte {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	messages := c.offlineMessages
+	c.offlineMessages = make([][]byte, 0)
+	return messages
+}
+
+// ReplaceOfflineMessage replaces queued ev_message with given mid by data.
+// If data is nil the queued message is removed.
+func (c *Client) ReplaceOfflineMessage(mid string, data []byte) {
+	// Send queue of offline client is already moved to offline messages
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	messages := make([][]byte, 0, len(c.offlineMessages))
+	for _, mess := range c.offlineMessages {
+		var ev struct {
+			Action string `json:"action"`
+			Data   struct {
+				Mid string `json:"mid"`
+			} `json:"data"`
 		}
+		if json.Unmarshal(mess, &ev) == nil && ev.Action == "ev_message" && ev.Data.Mid == mid {
+			if data == nil {
+				continue
+			}
+			mess = data
+		}
+		messages = append(messages, mess)
 	}
+	c.offlineMessages = messages
 }
 
 // Listen - start corotinues for listening and writing
 func (c *Client) Listen() {
 	go c.read()
 	go c.write()
+	if interval := c.config().HeartbeatInterval; interval > 0 {
+		go c.heartbeat(interval)
+	}
 }
 
 // NewClient create new instance of Client class
@@ -54,6 +193,7 @@ func NewClient(connection net.Conn) *Client {
 	writer := bufio.NewWriter(connection)
 	reader := bufio.NewReader(connection)
 	client := &Client{
+		server:   gServer,
 		conn:     connection,
 		uid:      "",
 		login:    "",
@@ -61,13 +201,15 @@ func NewClient(connection net.Conn) *Client {
 		email:    "",
 		phone:    "",
 		ip:       connection.RemoteAddr().String(),
-		outgoing: make(chan []byte),
+		outgoing: make(chan outMessage, currentConfig().SendQueueSize),
 		reader:   reader,
 		writer:   writer,
 		contacts: make(map[string]string),
 
 		connected:       true,
 		offlineMessages: make([][]byte, 0),
+		closed:          make(chan struct{}),
+		stopped:         make(chan struct{}),
 	}
 	//client.Listen()
 	return client
@@ -93,42 +235,103 @@ func (c *Client) Register(login string, pass string, nick string) {
 	if !c.CheckError(err, "Can't marhsal answer") {
 		return
 	}
-	c.outgoing <- s
+	c.Send(s)
 
 	c.Auth(login, pass)
 }
 
 // Disconnect is function a wrapper of conn.Close
 func (c *Client) Disconnect() {
+	c.mutex.Lock()
+	c.disconnect()
+	c.mutex.Unlock()
 	c.conn.Close()
+}
+
+// disconnect marks client offline and moves send queue to offline storage, c.mutex must be locked
+func (c *Client) disconnect() {
+	if !c.connected {
+		return
+	}
 	c.connected = false
+	c.spillQueue()
+	close(c.closed)
 }
 
 // CheckError wrapper to check err construction
 func (c *Client) CheckError(err error, message string) bool {
 	if err != nil {
-		log.Println("(" + c.ip + ") " + message + ": " + err.Error())
+		c.logger(LogClient).Warn(message, "err", err)
 	}
 	return err == nil
 }
 
 // SetUserInfo is updates information about user
 func (c *Client) SetUserInfo(ava string, email string, phone string, userstatus string) {
+	status, err := gServer.UpdateUserData(c, email, phone)
+	if err != nil {
+		c.Error("setuserinfo", err.Error(), status, false)
+		return
+	}
 
 	c.avatar = ava
 	c.status = userstatus
+	gServer.saveProfile(c)
+	gServer.recordProfile(c)
+
+	c.Ok("setuserinfo")
+}
 
-	gServer.UpdateUserData(c, email, phone)
+// loadAccount sets profile of session from account of user
+func (c *Client) loadAccount(a Account) {
+	c.nick = a.Nick
+	c.status = a.Status
+	c.avatar = a.Avatar
+	c.email = a.Email
+	c.phone = a.Phone
+	c.emailVerified = a.EmailVerified
+	c.phoneVerified = a.PhoneVerified
+	c.contacts = a.Contacts
+}
 
-	if email != "" {
-		c.email = email
+// account returns profile of session as account
+func (c *Client) account() Account {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return Account{
+		Uid:           c.login,
+		Nick:          c.nick,
+		Status:        c.status,
+		Avatar:        c.avatar,
+		Email:         c.email,
+		Phone:         c.phone,
+		EmailVerified: c.emailVerified,
+		PhoneVerified: c.phoneVerified,
 	}
+}
 
-	if phone != "" {
-		c.phone = phone
+// address returns email or phone of user and its verification state
+func (c *Client) address(kind string) (string, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if kind == VerifyEmail {
+		return c.email, c.emailVerified
 	}
+	return c.phone, c.phoneVerified
+}
 
-	c.Ok("setuserinfo")
+// setAddress changes email or phone of user, admin API changes them too
+func (c *Client) setAddress(kind string, address string, verified bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if kind == VerifyEmail {
+		c.email, c.emailVerified = address, verified
+	} else {
+		c.phone, c.phoneVerified = address, verified
+	}
 }
 
 // GetContactList sends contact list to user
@@ -139,14 +342,8 @@ func (c *Client) GetContactList() {
 	list.Users = make([]UserData, 0)
 
 	for _, uid := range c.contacts {
-		if user, ok := gServer.GetUserData(uid); ok {
-			list.Users = append(list.Users, UserData{
-				Uid:    uid,
-				Nick:   user.nick,
-				Email:  user.email,
-				Phone:  user.phone,
-				Avatar: user.avatar,
-			})
+		if user, ok := gServer.profile(uid); ok {
+			list.Users = append(list.Users, gServer.userData(user))
 		}
 	}
 
@@ -161,26 +358,33 @@ func (c *Client) GetContactList() {
 	if !c.CheckError(err, "Can't marhsal answer") {
 		return
 	}
-	c.outgoing <- m
+	c.Send(m)
 }
 
 // ImportContacts finds users contacts on server
 func (c *Client) ImportContacts(contacts []Contact) {
+	c.ImportAddressBook(entriesOfContacts(contacts))
+}
+
+// ImportAddressBook finds users of address book on server, each entry matches one user
+func (c *Client) ImportAddressBook(entries []AddressBookEntry) {
+	if max := c.config().MaxImportContacts; max > 0 && len(entries) > max {
+		c.Error("import", "Too many contacts", ErrTooLarge, false)
+		return
+	}
+
 	list := SrvListOfUsers{}
 	list.Status = ErrOK
 	list.Error = "OK"
 	list.Users = make([]UserData, 0)
 
-	for _, contact := range contacts {
-		if user, ok := gServer.FindUser(contact.Email, contact.Phone); ok {
-			list.Users = append(list.Users, UserData{
-				Uid:    user.login,
-				Nick:   user.nick,
-				Email:  user.email,
-				Phone:  user.phone,
-				Avatar: user.avatar,
-				MyID:   contact.MyID,
-			})
+	for _, entry := range entries {
+		if uid, ok := gServer.findEntry(entry); ok {
+			if user, ok := gServer.profile(uid); ok {
+				data := gServer.userData(user)
+				data.MyID = entry.MyID
+				list.Users = append(list.Users, data)
+			}
 		}
 	}
 
@@ -195,7 +399,7 @@ func (c *Client) ImportContacts(contacts []Contact) {
 	if !c.CheckError(err, "Can't marhsal answer") {
 		return
 	}
-	c.outgoing <- m
+	c.Send(m)
 }
 
 // AddContact adds contact to user list
@@ -208,31 +412,43 @@ func (c *Client) AddContact(uid string) {
 		return
 	}
 
-	_, ok = gServer.GetUserData(uid)
-	if !ok {
+	if _, ok = gServer.profile(uid); !ok {
 		c.Error("addcontact", "User not found", ErrUserNotFound, true)
 		return
 	}
 	c.contacts[uid] = uid
+	gServer.saveProfile(c)
+	gServer.record("ev_contact_added", EvSrvContact{Uid: uid}, c.uid)
+	gServer.webhooks.Emit(EventContactAdded, WebhookContactData{Uid: c.uid, Contact: uid})
 
 	c.Ok("addcontact")
 }
 
 // DelContact removes contact from user list
 func (c *Client) DelContact(uid string) {
-	delete(c.contacts, uid)
+	if _, ok := c.contacts[uid]; ok {
+		delete(c.contacts, uid)
+		gServer.saveProfile(c)
+		gServer.record("ev_contact_removed", EvSrvContact{Uid: uid}, c.uid)
+	}
 	c.Ok("delcontact")
 }
 
 // Auth client autorisation on server
 func (c *Client) Auth(login string, pass string) bool {
 	sid, status, err := gServer.Auth(c, login, pass)
+	if e, ok := err.(*RateLimitError); ok {
+		c.RateLimited("auth", e.Wait, true)
+		return false
+	}
 	if err != nil {
 		c.Error("auth", err.Error(), status, true)
 		return false
 	}
+	c.mutex.Lock()
 	c.login = login
 	c.uid = login
+	c.mutex.Unlock()
 	c.sid = sid
 	m := SrvStatusAuthMessage{
 		Sid:  c.sid,
@@ -252,13 +468,8 @@ func (c *Client) Auth(login string, pass string) bool {
 		c.Disconnect()
 		return false
 	}
-	c.outgoing <- s
-
-	// Send offlineMessages
-	for _, mess := range c.offlineMessages {
-		c.outgoing <- mess
-	}
-	c.offlineMessages = make([][]byte, 0)
+	// Answer goes before offline messages which are written by write goroutine
+	c.sendFirst(s)
 
 	return true
 }
@@ -279,7 +490,7 @@ func (c *Client) Ok(action string) {
 		c.Disconnect()
 		return
 	}
-	c.outgoing <- s
+	c.Send(s)
 }
 
 // Error sends to client an error status
@@ -298,45 +509,140 @@ func (c *Client) Error(action string, text string, status int, closeConn bool) {
 		c.Disconnect()
 		return
 	}
-	log.Printf("Error: from %v - %v\n", c.ip, text)
-	c.writer.Write(data)
-	c.writer.Flush()
+	c.logger(LogClient).Info("Error answer", "action", action, "status", status, "error", text)
+	c.metrics().Error(status)
+	c.Send(data)
 	if closeConn {
+		c.Close()
+	} else {
+		c.Flush()
+	}
+}
+
+// RateLimited sends to client an error status with time to wait
+func (c *Client) RateLimited(action string, wait time.Duration, closeConn bool) {
+	message := SrvRateLimitedMessage{
+		RetryAfter: int(math.Ceil(wait.Seconds())),
+	}
+	message.Status = ErrRateLimited
+	message.Error = "Too many requests"
+	data, err := json.Marshal(struct {
+		Action string                `json:"action"`
+		Data   SrvRateLimitedMessage `json:"data"`
+	}{
+		Action: action, Data: message,
+	})
+	if !c.CheckError(err, "Can't marhsal message") {
 		c.Disconnect()
+		return
+	}
+	c.logger(LogClient).Warn("Rate limit", "action", action, "retry_after", wait)
+	c.metrics().Error(ErrRateLimited)
+	c.Send(data)
+	if closeConn {
+		c.Close()
+	} else {
+		c.Flush()
+	}
+}
+
+// Host returns ip of client without port
+func (c *Client) Host() string {
+	host, _, err := net.SplitHostPort(c.ip)
+	if err != nil {
+		return c.ip
 	}
+	return host
+}
+
+// allow checks rate limit of action, cost is count of processed items.
+// Requests are limited per user or per ip before auth.
+func (c *Client) allow(action string, cost int) bool {
+	user := c.uid
+	if user == "" || action == AnyAction {
+		user = c.Host()
+	}
+	ok, wait := gServer.limiter.Allow(action, user, cost)
+	if !ok {
+		c.RateLimited(action, wait, false)
+	}
+	return ok
 }
 
 func (c *Client) read() {
 	message := SrvWelcomeMessage{
 		Action:  "welcome",
 		Time:    int(time.Now().Unix()),
-		Message: "Happy New Year! Welcome to message server!",
+		Message: gServer.MOTD(),
 	}
 	start, err := json.Marshal(message)
 	if !c.CheckError(err, "Can't marhsal message") {
 		c.Error("unknown", "Invalid request", ErrInvalidData, true)
 		return
 	}
-	c.outgoing <- start
-	log.Printf("Send message to client\n")
-	dec := json.NewDecoder(c.reader)
+	c.Send(start)
+	c.logger(LogClient).Debug("Welcome sent")
+	frames := &frameReader{r: c.reader}
+	dec := json.NewDecoder(frames)
+
+	// Request is measured till the next one is read or connection is closed
+	var action string
+	var started time.Time
+	observe := func() {
+		if action != "" {
+			c.metrics().Request(action, time.Since(started))
+			action = ""
+		}
+	}
+	defer observe()
+
 	for {
+		observe()
 		var m CltRequest
+		offset := dec.InputOffset()
+		frames.startFrame(offset, c.config().MaxFrameSize)
+		c.setReadDeadline()
 		err := dec.Decode(&m)
-		if !c.CheckError(err, "Invalid message\n") {
+		if err != nil && isConnLost(err) {
+			c.connLost(err)
+			return
+		}
+		if err == errFrameTooLarge {
+			c.Error("unknown", "Request is too large", ErrTooLarge, true)
+			return
+		}
+		if !c.CheckError(err, "Invalid message") {
 			c.Error("unknown", "Invalid request", ErrInvalidData, true)
 			return
 		}
-		log.Printf("Action %v, %v\n", m.Action, string(m.RawData))
-		if c.uid == "" && (m.Action != "register" && m.Action != "auth") {
-			c.Error(m.Action, "Need auth", ErrNeedAuth, false)
+		c.metrics().BytesIn(int(dec.InputOffset() - offset))
+		action, started = m.Action, time.Now()
+		c.mutex.Lock()
+		c.requestID = nextRequestID()
+		c.mutex.Unlock()
+		c.logger(LogClient).Info("Request", "action", m.Action, "data", RedactJSON(m.RawData))
+		if !c.allow(AnyAction, 1) {
+			continue
+		}
+		// Import is limited by count of contacts, sendcode - by sent codes
+		if m.Action != "import" && m.Action != "sendcode" && !c.allow(m.Action, 1) {
+			continue
+		}
+		if status, err := gServer.Authorize(c, m.Action); err != nil {
+			c.Error(m.Action, err.Error(), status, false)
 			continue
 		}
 		switch m.Action {
+		case "ping":
+			c.Ping("pong")
+
+		case "pong":
+			// Answer to heartbeat, connection is alive
+
 		case "register":
 			var im CltRegister
 			err := json.Unmarshal(m.RawData, &im)
-			if !c.CheckError(err, "Invalid RawData"+string(m.RawData)) {
+			if !c.CheckError(err, "Invalid RawData") {
 				c.Error(m.Action, "Register: Invalid data", ErrInvalidData, true)
 				return
 			}
@@ -349,7 +655,7 @@ func (c *Client) read() {
 		case "auth":
 			var im CltAuth
 			err := json.Unmarshal(m.RawData, &im)
-			if !c.CheckError(err, "Invalid RawData"+string(m.RawData)) {
+			if !c.CheckError(err, "Invalid RawData") {
 				c.Error(m.Action, "Auth: Invalid data", ErrInvalidData, true)
 				return
 			}
@@ -360,7 +666,7 @@ func (c *Client) read() {
 		case "setuserinfo":
 			var im CltSetUserInfo
 			err := json.Unmarshal(m.RawData, &im)
-			if !c.CheckError(err, "Invalid RawData"+string(m.RawData)) {
+			if !c.CheckError(err, "Invalid RawData") {
 				c.Error(m.Action, "SetUserInfo: Invalid data", ErrInvalidData, true)
 				return
 			}
@@ -369,7 +675,7 @@ func (c *Client) read() {
 		case "userinfo":
 			var im CltUserInfo
 			err := json.Unmarshal(m.RawData, &im)
-			if !c.CheckError(err, "Invalid RawData"+string(m.RawData)) {
+			if !c.CheckError(err, "Invalid RawData") {
 				c.Error(m.Action, "Invalid data", ErrInvalidData, true)
 				return
 			}
@@ -378,7 +684,7 @@ func (c *Client) read() {
 		case "contactlist":
 			var im CltBaseReq
 			err := json.Unmarshal(m.RawData, &im)
-			if !c.CheckError(err, "Invalid RawData"+string(m.RawData)) {
+			if !c.CheckError(err, "Invalid RawData") {
 				c.Error(m.Action, "Channels Invalid data", ErrInvalidData, true)
 				return
 			}
@@ -387,7 +693,7 @@ func (c *Client) read() {
 		case "addcontact":
 			var im CltUidReq
 			err := json.Unmarshal(m.RawData, &im)
-			if !c.CheckError(err, "Invalid RawData"+string(m.RawData)) {
+			if !c.CheckError(err, "Invalid RawData") {
 				c.Error(m.Action, "Invalid data", ErrInvalidData, true)
 				return
 			}
@@ -396,7 +702,7 @@ func (c *Client) read() {
 		case "delcontact":
 			var im CltUidReq
 			err := json.Unmarshal(m.RawData, &im)
-			if !c.CheckError(err, "Invalid RawData"+string(m.RawData)) {
+			if !c.CheckError(err, "Invalid RawData") {
 				c.Error(m.Action, "Invalid data", ErrInvalidData, true)
 				return
 			}
@@ -405,20 +711,169 @@ func (c *Client) read() {
 		case "message":
 			var im CltMessage
 			err := json.Unmarshal(m.RawData, &im)
-			if !c.CheckError(err, "Invalid RawData"+string(m.RawData)) {
+			if !c.CheckError(err, "Invalid RawData") {
+				c.Error(m.Action, "Invalid data", ErrInvalidData, true)
+				return
+			}
+			gServer.SendMessage(c, im.User, im.Body, im.Attach, im.MessageLinks, im.Envelopes)
+
+		case "editmessage":
+			var im CltEditMessage
+			err := json.Unmarshal(m.RawData, &im)
+			if !c.CheckError(err, "Invalid RawData") {
+				c.Error(m.Action, "Invalid data", ErrInvalidData, true)
+				return
+			}
+			gServer.EditMessage(c, im.Mid, im.Body)
+
+		case "deletemessage":
+			var im CltMidReq
+			err := json.Unmarshal(m.RawData, &im)
+			if !c.CheckError(err, "Invalid RawData") {
+				c.Error(m.Action, "Invalid data", ErrInvalidData, true)
+				return
+			}
+			gServer.DeleteMessage(c, im.Mid)
+
+		case "react":
+			var im CltReact
+			err := json.Unmarshal(m.RawData, &im)
+			if !c.CheckError(err, "Invalid RawData") {
+				c.Error(m.Action, "Invalid data", ErrInvalidData, true)
+				return
+			}
+			gServer.React(c, im.Mid, im.Emoji, im.Remove)
+
+		case "history":
+			var im CltHistory
+			err := json.Unmarshal(m.RawData, &im)
+			if !c.CheckError(err, "Invalid RawData") {
+				c.Error(m.Action, "Invalid data", ErrInvalidData, true)
+				return
+			}
+			gServer.GetHistory(c, im.User, im.Before, im.Limit)
+
+		case "searchmessages":
+			var im CltSearch
+			err := json.Unmarshal(m.RawData, &im)
+			if !c.CheckError(err, "Invalid RawData") {
+				c.Error(m.Action, "Invalid data", ErrInvalidData, true)
+				return
+			}
+			filter := SearchFilter{
+				Peer:   im.User,
+				After:  im.After,
+				Before: im.Before,
+				Mime:   im.Mime,
+			}
+			gServer.SearchMessages(c, im.Query, filter, im.Limit)
+
+		case "setrole":
+			var im CltSetRole
+			err := json.Unmarshal(m.RawData, &im)
+			if !c.CheckError(err, "Invalid RawData") {
+				c.Error(m.Action, "Invalid data", ErrInvalidData, true)
+				return
+			}
+			gServer.SetRole(c, im.User, im.Role)
+
+		case "roles":
+			gServer.GetRoles(c)
+
+		case "uploadkeys":
+			var im CltUploadKeys
+			err := json.Unmarshal(m.RawData, &im)
+			if !c.CheckError(err, "Invalid RawData") {
+				c.Error(m.Action, "Invalid data", ErrInvalidData, true)
+				return
+			}
+			gServer.UploadKeys(c, im)
+
+		case "getkeys":
+			var im CltUidReq
+			err := json.Unmarshal(m.RawData, &im)
+			if !c.CheckError(err, "Invalid RawData") {
+				c.Error(m.Action, "Invalid data", ErrInvalidData, true)
+				return
+			}
+			gServer.GetKeys(c, im.User)
+
+		case "removekeys":
+			var im CltDeviceReq
+			err := json.Unmarshal(m.RawData, &im)
+			if !c.CheckError(err, "Invalid RawData") {
+				c.Error(m.Action, "Invalid data", ErrInvalidData, true)
+				return
+			}
+			gServer.RemoveDeviceKeys(c, im.Device)
+
+		case "sendcode":
+			var im CltVerify
+			err := json.Unmarshal(m.RawData, &im)
+			if !c.CheckError(err, "Invalid RawData") {
 				c.Error(m.Action, "Invalid data", ErrInvalidData, true)
 				return
 			}
-			gServer.SendMessage(c, im.User, im.Body, im.Attach)
+			gServer.SendCode(c, im.Kind)
+
+		case "verify":
+			var im CltVerify
+			err := json.Unmarshal(m.RawData, &im)
+			if !c.CheckError(err, "Invalid RawData") {
+				c.Error(m.Action, "Invalid data", ErrInvalidData, true)
+				return
+			}
+			gServer.Verify(c, im.Kind, im.Code)
+
+		case "conversations":
+			gServer.GetConversations(c)
+
+		case "setconversation":
+			var im CltSetConversation
+			err := json.Unmarshal(m.RawData, &im)
+			if !c.CheckError(err, "Invalid RawData") {
+				c.Error(m.Action, "Invalid data", ErrInvalidData, true)
+				return
+			}
+			gServer.SetConversation(c, im)
+
+		case "markread":
+			var im CltMarkRead
+			err := json.Unmarshal(m.RawData, &im)
+			if !c.CheckError(err, "Invalid RawData") {
+				c.Error(m.Action, "Invalid data", ErrInvalidData, true)
+				return
+			}
+			gServer.MarkRead(c, im.User, im.Mid)
+
+		case "sync":
+			var im CltSync
+			err := json.Unmarshal(m.RawData, &im)
+			if !c.CheckError(err, "Invalid RawData") {
+				c.Error(m.Action, "Invalid data", ErrInvalidData, true)
+				return
+			}
+			gServer.Sync(c, im.Seq, im.Limit)
 
 		case "import":
 			var im CltImport
 			err := json.Unmarshal(m.RawData, &im)
-			if !c.CheckError(err, "Invalid RawData"+string(m.RawData)) {
+			if !c.CheckError(err, "Invalid RawData") {
 				c.Error(m.Action, "Invalid data", ErrInvalidData, true)
 				return
 			}
-			c.ImportContacts(im.Contacts)
+			entries := entriesOfContacts(im.Contacts)
+			if im.Format != "" && im.Format != FormatJSON {
+				entries, err = ParseAddressBook(im.Format, im.Payload)
+				if err != nil {
+					c.Error(m.Action, err.Error(), ErrInvalidData, false)
+					continue
+				}
+			}
+			if !c.allow(m.Action, len(entries)) {
+				continue
+			}
+			c.ImportAddressBook(entries)
 		default:
 		}
 	}
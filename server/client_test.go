@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"log"
 	"net"
+	"sync"
 	"testing"
 )
 
@@ -20,11 +21,49 @@ func NewTestClient(conn net.Conn) *Client {
 	return c
 }
 
+// listenTest starts goroutines of client like Listen, they are stopped at the end
+// of test to leave gServer of next test alone
+func listenTest(tb testing.TB, c *Client) {
+	var wg sync.WaitGroup
+	run := func(f func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f()
+		}()
+	}
+	run(c.read)
+	run(c.write)
+	if interval := currentConfig().HeartbeatInterval; interval > 0 {
+		run(func() { c.heartbeat(interval) })
+	}
+	tb.Cleanup(func() {
+		c.Disconnect()
+		wg.Wait()
+	})
+}
+
+// reconnect makes client closed by error online again
+func reconnect(c *Client, conn *testConn) {
+	conn.Closed = false
+	if c.isConnected() {
+		return
+	}
+	<-c.stopped
+	c.mutex.Lock()
+	c.connected = true
+	c.closed = make(chan struct{})
+	c.stopped = make(chan struct{})
+	c.mutex.Unlock()
+	go c.write()
+}
+
 // TestClientDelContact checks Client.DelContact
 func TestClientDelContact(t *testing.T) {
 	conn := newTestConn()
 	c := NewTestClient(conn)
 	c.DelContact("111")
+	c.Flush()
 	testString := "{\"action\":\"delcontact\",\"data\":{\"status\":0,\"error\":\"OK\"}}"
 	err := conn.CheckLastMessage(t, testString)
 	if nil != err {
@@ -61,7 +100,10 @@ func TestClientCheckError(t *testing.T) {
 
 // TestClientSetUserInfo checks Client.SetUserInfo
 func TestClientSetUserInfo(t *testing.T) {
-	gServer = newServer()
+	// Each change of email and phone sends code
+	config := DefaultConfig()
+	config.RateLimits["sendcode"] = Limit{Rate: 1, Burst: 10}
+	gServer = newServerWithConfig(config)
 
 	conn := newTestConn()
 	c := NewTestClient(conn)
@@ -83,6 +125,7 @@ func TestClientSetUserInfo(t *testing.T) {
 	phone2 := "+7999123123777"
 
 	c.SetUserInfo(ava, mail, phone, status)
+	c.Flush()
 
 	if c.email != mail {
 		t.Errorf("Email is invalid '%s' instead '%s'", c.email, mail)
@@ -102,6 +145,7 @@ func TestClientSetUserInfo(t *testing.T) {
 	if nil != err {
 		t.Errorf(err.Error())
 	}
+	verifyContacts(c)
 
 	uid, ok := gServer.emails[mail]
 	if !ok || uid != c.login {
@@ -114,13 +158,13 @@ func TestClientSetUserInfo(t *testing.T) {
 	}
 
 	c.SetUserInfo("", mail2, phone2, "")
-	// TODO: WTF
-	c.outgoing <- []byte("")
+	c.Flush()
 
 	err = conn.CheckLastMessage(t, testString)
 	if nil != err {
 		t.Errorf(err.Error())
 	}
+	verifyContacts(c)
 
 	if c.email != mail2 {
 		t.Errorf("Email is invalid '%s' instead of '%s'", c.email, mail2)
@@ -167,6 +211,7 @@ func TestClientRegister(t *testing.T) {
 	testOk := "{\"action\":\"register\",\"data\":{\"status\":0,\"error\":\"OK\"}}"
 	testNickUse := "{\"action\":\"register\",\"data\":{\"status\":1,\"error\":\"Nick already was used\"}}"
 	testLoginUse := "{\"action\":\"register\",\"data\":{\"status\":1,\"error\":\"Login already was used\"}}"
+	testAuthOk := "{\"action\":\"auth\",\"data\":{\"sid\":\"d56b699830e77ba53855679cb1d252da\",\"cid\":\"login\",\"nick\":\"nick\",\"status\":0,\"error\":\"OK\"}}"
 	//fmt.Printf("Messages - %v", conn.Messages)
 
 	var testData = []struct {
@@ -177,27 +222,33 @@ func TestClientRegister(t *testing.T) {
 		{"", "1", "1", testEmpty, false},
 		{"1", "", "1", testEmpty, false},
 		{"1", "1", "", testEmpty, false},
-		{"login", "pass", "nick", testOk, true},
+		{"login", "pass", "nick", testAuthOk, true},
 		{"login", "pass2", "nick2", testLoginUse, false},
 		{"login2", "pass2", "nick", testNickUse, false},
 	}
 
 	for _, value := range testData {
-		conn.Closed = false
-		c.connected = true
+		reconnect(c, conn)
 		c.Register(value.login, value.pass, value.nick)
+		c.Flush()
 		err := conn.CheckLastMessage(t, value.message)
 		if nil != err {
 			t.Errorf("Register('%s','%s','%s') - '%s'",
 				value.login, value.pass, value.nick, err.Error())
 		}
-		if conn.Closed == value.conn || c.connected != value.conn {
+		if conn.Closed == value.conn || c.isConnected() != value.conn {
 			t.Errorf("Register('%s','%s','%s') Connection has invalid state (%v) instead (%v)!",
 				value.login, value.pass, value.nick, conn.Closed, value.conn)
 		}
 	}
 	conn.Closed = false
 
+	// Successful registration is answered before auth
+	err := conn.CheckLastMessage(t, testOk)
+	if nil != err {
+		t.Errorf("%v", err)
+	}
+
 	var login, nick, pass string
 	var ok bool
 	login, ok = gServer.Nicks["nick"]
@@ -236,7 +287,7 @@ func TestClientAuth(t *testing.T) {
 
 	setInfo := func(c *Client, conn *testConn) {
 		c.SetUserInfo(ava, mail, phone, status)
-		c.outgoing <- []byte("")
+		c.Flush()
 		if c.email != mail {
 			t.Errorf("Email is invalid '%s' instead '%s'", c.email, mail)
 		}
@@ -299,12 +350,13 @@ func TestClientAuth(t *testing.T) {
 		}
 
 		c.Auth(val.login, val.pass)
+		c.Flush()
 		err := conn.CheckLastMessage(t, val.mess)
 		if nil != err {
 			t.Errorf("Test data - (%v): %s", val, err.Error())
 		}
-		if conn.Closed == val.connect || c.connected != val.connect {
-			t.Errorf("Test data - (%v): connection invalid (conn - %v, client - %v)", val, conn.Closed, c.connected)
+		if conn.Closed == val.connect || c.isConnected() != val.connect {
+			t.Errorf("Test data - (%v): connection invalid (conn - %v, client - %v)", val, conn.Closed, c.isConnected())
 		}
 		if val.postfunc != nil {
 			val.postfunc(c, conn)
@@ -325,7 +377,7 @@ func TestClientAddContact(t *testing.T) {
 	gServer.Register(c, "login", "pass", "nick")
 	c1.Auth("user", "pass")
 	c.Auth("login", "pass")
-	c.outgoing <- []byte("")
+	c.Flush()
 	err := conn.CheckLastMessage(t, testOk)
 	if nil != err {
 		t.Errorf(err.Error())
@@ -336,25 +388,28 @@ func TestClientAddContact(t *testing.T) {
 	ansNotFound := "{\"action\":\"addcontact\",\"data\":{\"status\":8,\"error\":\"User not found\"}}"
 
 	c.AddContact(c1.login)
-	c.outgoing <- []byte("")
+	c.Flush()
 	err = conn.CheckLastMessage(t, ansOk)
 	if nil != err {
 		t.Errorf(err.Error())
 	}
 
 	c.AddContact(c.login)
-	c.outgoing <- []byte("")
+	c.Flush()
 	err = conn.CheckLastMessage(t, ansAlready)
 	if nil != err {
 		t.Errorf(err.Error())
 	}
 
+	// Error closes connection
+	reconnect(c, conn)
 	c.AddContact(c1.login)
 	err = conn.CheckLastMessage(t, ansAlready)
 	if nil != err {
 		t.Errorf(err.Error())
 	}
 
+	reconnect(c, conn)
 	c.AddContact("unknown")
 	err = conn.CheckLastMessage(t, ansNotFound)
 	if nil != err {
@@ -395,6 +450,7 @@ func TestClientGetContactList(t *testing.T) {
 
 		gServer.Register(tmp.client, tmp.login, tmp.pass, tmp.nick)
 		tmp.client.Auth(tmp.login, tmp.pass)
+		tmp.client.Flush()
 
 		testOk := fmt.Sprintf(
 			"{\"action\":\"auth\",\"data\":{\"sid\":\"%s\",\"cid\":\"%s\",\"nick\":\"%s\",\"status\":0,\"error\":\"OK\"}}",
@@ -408,6 +464,7 @@ func TestClientGetContactList(t *testing.T) {
 		}
 
 		tmp.client.SetUserInfo(tmp.ava, tmp.email, tmp.phone, "")
+		verifyContacts(tmp.client)
 	}
 
 	conn := newTestConn()
@@ -426,7 +483,7 @@ func TestClientGetContactList(t *testing.T) {
 	andOkTml := "{\"action\":\"contactlist\",\"data\":{\"list\":[%s],\"status\":0,\"error\":\"OK\"}}"
 	mess := fmt.Sprintf(andOkTml, messUsers[:len(messUsers)-1])
 	c.GetContactList()
-	c.outgoing <- []byte("")
+	c.Flush()
 	err := conn.CheckLastMessage(t, mess)
 	if nil != err {
 		t.Errorf(err.Error())
@@ -466,6 +523,7 @@ func TestClientImportContacst(t *testing.T) {
 
 		gServer.Register(tmp.client, tmp.login, tmp.pass, tmp.nick)
 		tmp.client.Auth(tmp.login, tmp.pass)
+		tmp.client.Flush()
 
 		testOk := fmt.Sprintf(
 			"{\"action\":\"auth\",\"data\":{\"sid\":\"%s\",\"cid\":\"%s\",\"nick\":\"%s\",\"status\":0,\"error\":\"OK\"}}",
@@ -478,6 +536,7 @@ func TestClientImportContacst(t *testing.T) {
 				tmp.login, tmp.pass, err.Error())
 		}
 		tmp.client.SetUserInfo(tmp.ava, tmp.email, tmp.phone, "")
+		verifyContacts(tmp.client)
 	}
 
 	conn := newTestConn()
@@ -509,7 +568,7 @@ func TestClientImportContacst(t *testing.T) {
 
 	andOkTml := "{\"action\":\"import\",\"data\":{\"list\":[%s],\"status\":0,\"error\":\"OK\"}}"
 	mess := fmt.Sprintf(andOkTml, messUsers[:len(messUsers)-1])
-	c.outgoing <- []byte("")
+	c.Flush()
 	err := conn.CheckLastMessage(t, mess)
 	if nil != err {
 		t.Errorf(err.Error())
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"time"
+)
+
+// Config is a set of server settings
+type Config struct {
+	EditWindow   time.Duration // Time while author can edit or delete his message
+	HistoryLimit int           // Max count of messages in one history answer
+	SearchLimit  int           // Max count of messages in one search answer
+	SyncLimit    int           // Max count of events in one sync answer
+	SyncLogSize  int           // Count of last events of user kept for sync (0 - all events)
+
+	RateLimits      map[string]Limit // Limits of requests per action for one user (AnyAction - for one ip)
+	AuthMaxFailures int              // Count of auth failures from one ip before ban (0 - never ban)
+	AuthFailWindow  time.Duration    // Period of counting auth failures
+	AuthBanTime     time.Duration    // Duration of ban
+
+	SendQueueSize int         // Max count of messages in send queue of one client
+	QueuePolicy   QueuePolicy // Behaviour when send queue is full
+
+	ReadTimeout       time.Duration // Max time between requests of user (0 - no limit)
+	WriteTimeout      time.Duration // Max time of writing one message (0 - no limit)
+	HeartbeatInterval time.Duration // Period of server pings (0 - no pings)
+
+	MaxFrameSize      int // Max size of one request in bytes (0 - no limit)
+	MaxBodyLength     int // Max size of message body in bytes (0 - no limit)
+	MaxAttachSize     int // Max size of decoded attachment in bytes (0 - no limit)
+	MaxImportContacts int // Max count of contacts in one import (0 - no limit)
+	MaxEnvelopeSize   int // Max total size of ciphertexts of one message in bytes (0 - no limit)
+	MaxPinned         int // Max count of pinned conversations of one user (0 - no limit)
+
+	PhoneRegion    string             // Region of phones without country code, e.g. "RU" ("" - only international format)
+	VerifySender   VerificationSender // Delivery of codes confirming email and phone (nil - codes are kept in memory)
+	VerifyCodeTTL  time.Duration      // Lifetime of verification code (0 - unlimited)
+	VerifyAttempts int                // Failed attempts before code becomes invalid (0 - no limit)
+
+	MetricsAddr string // Address of http endpoint with metrics, e.g. ":9100" ("" - disabled)
+	AdminAddr   string // Address of http admin API ("" - disabled)
+	AdminToken  string // Bearer token of admin API
+	MOTD        string // Message of the day in welcome message
+	BotAddr     string // Address of http bot API ("" - disabled)
+
+	BotWebhookPrivate bool // Allow webhooks of bots to loopback and private addresses, e.g. for local bots
+
+	Webhooks       []WebhookConfig // Outgoing webhooks on server events
+	WebhookRetries int             // Count of attempts of delivery of one event
+	WebhookBackoff time.Duration   // Pause after first failed attempt, it doubles after each failure
+	WebhookTimeout time.Duration   // Timeout of one attempt
+
+	Admins []string // Logins which get RoleAdmin on registration
+
+	LogFormat string                // Format of log records: "text" (key=value) or "json"
+	LogLevel  slog.Level            // Level of subsystems missing in LogLevels
+	LogLevels map[string]slog.Level // Levels of subsystems (LogServer, LogClient, ...)
+	LogOutput io.Writer             // Destination of log (nil - stderr)
+}
+
+// DefaultConfig returns settings used by CreateInstance
+func DefaultConfig() Config {
+	return Config{
+		EditWindow:   24 * time.Hour,
+		HistoryLimit: 50,
+		SearchLimit:  50,
+		SyncLimit:    200,
+		SyncLogSize:  1000,
+
+		RateLimits: map[string]Limit{
+			AnyAction:  {Rate: 20, Burst: 50},
+			"register": {Rate: 0.1, Burst: 3},
+			"auth":     {Rate: 0.5, Burst: 5},
+			"message":  {Rate: 5, Burst: 20},
+			"import":   {Rate: 100, Burst: 2000}, // Cost of import is count of contacts
+			"sendcode": {Rate: 1.0 / 60, Burst: 3},
+			"verify":   {Rate: 0.2, Burst: 10},
+		},
+		AuthMaxFailures: 5,
+		AuthFailWindow:  time.Minute,
+		AuthBanTime:     15 * time.Minute,
+
+		SendQueueSize: 256,
+		QueuePolicy:   QueueSpill,
+
+		ReadTimeout:       90 * time.Second,
+		WriteTimeout:      10 * time.Second,
+		HeartbeatInterval: 30 * time.Second,
+
+		MaxFrameSize:      8 << 20,
+		MaxBodyLength:     16 << 10,
+		MaxAttachSize:     5 << 20,
+		MaxImportContacts: 2000,
+		MaxEnvelopeSize:   1 << 20,
+		MaxPinned:         5,
+
+		PhoneRegion:    "RU",
+		VerifyCodeTTL:  10 * time.Minute,
+		VerifyAttempts: 5,
+
+		MOTD: defaultMOTD,
+
+		WebhookRetries: 5,
+		WebhookBackoff: time.Second,
+		WebhookTimeout: 5 * time.Second,
+
+		LogFormat: "text",
+		LogLevel:  slog.LevelInfo,
+	}
+}
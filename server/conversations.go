@@ -0,0 +1,272 @@
+package server
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Max count of runes of message body in preview of conversation
+const previewLength = 100
+
+// MutedForever is a value of MutedUntil for conversation muted without end
+const MutedForever = -1
+
+// Conversation is a dialog of user with settings shared by all his devices.
+// Last message and unread count are taken from history.
+type Conversation struct {
+	Peer       string // UserID of interlocutor
+	ReadMid    string // Last message read by user
+	Pinned     bool
+	Archived   bool
+	MutedUntil int // End of mute (0 - not muted, MutedForever - without end)
+}
+
+// Muted checks if conversation is muted at time now
+func (conv *Conversation) Muted(now int) bool {
+	return conv.MutedUntil == MutedForever || conv.MutedUntil > now
+}
+
+// Conversations keeps conversations of users
+type Conversations struct {
+	mutex sync.RWMutex
+	chats map[string]map[string]*Conversation // map key - uid; val - conversations by uid of peer
+}
+
+// NewConversations is constructor of Conversations
+func NewConversations() *Conversations {
+	return &Conversations{chats: make(map[string]map[string]*Conversation)}
+}
+
+// get returns conversation of user with peer, it creates missing one
+func (cs *Conversations) get(uid string, peer string) *Conversation {
+	chats, ok := cs.chats[uid]
+	if !ok {
+		chats = make(map[string]*Conversation)
+		cs.chats[uid] = chats
+	}
+	conv, ok := chats[peer]
+	if !ok {
+		conv = &Conversation{Peer: peer}
+		chats[peer] = conv
+	}
+	return conv
+}
+
+// Message starts conversations of author and recipient of message. Author has read
+// everything before his message; archived conversation of recipient returns to the
+// list unless it is muted.
+func (cs *Conversations) Message(m *StoredMessage) {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	cs.get(m.From, m.To).ReadMid = m.Mid
+	conv := cs.get(m.To, m.From)
+	if conv.Archived && !conv.Muted(m.Time) {
+		conv.Archived = false
+	}
+}
+
+// Get returns copy of conversation of user with peer
+func (cs *Conversations) Get(uid string, peer string) (Conversation, bool) {
+	cs.mutex.RLock()
+	defer cs.mutex.RUnlock()
+
+	conv, ok := cs.chats[uid][peer]
+	if !ok {
+		return Conversation{}, false
+	}
+	return *conv, true
+}
+
+// List returns copies of all conversations of user
+func (cs *Conversations) List(uid string) []Conversation {
+	cs.mutex.RLock()
+	defer cs.mutex.RUnlock()
+
+	list := make([]Conversation, 0, len(cs.chats[uid]))
+	for _, conv := range cs.chats[uid] {
+		list = append(list, *conv)
+	}
+	return list
+}
+
+// Update changes conversation of user with peer under lock, missing conversation is created
+func (cs *Conversations) Update(uid string, peer string, update func(conv *Conversation)) Conversation {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	conv := cs.get(uid, peer)
+	update(conv)
+	return *conv
+}
+
+// Pin pins conversation of user with peer if user has less than max pinned ones (0 - no limit).
+// Limit is checked under the same lock, so concurrent requests don't exceed it.
+func (cs *Conversations) Pin(uid string, peer string, max int) (Conversation, bool) {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	if conv, ok := cs.chats[uid][peer]; ok && conv.Pinned {
+		return *conv, true
+	}
+	if max > 0 && cs.countPinned(uid) >= max {
+		return Conversation{}, false
+	}
+	conv := cs.get(uid, peer)
+	conv.Pinned = true
+	return *conv, true
+}
+
+// countPinned returns count of pinned conversations of user, cs.mutex must be locked
+func (cs *Conversations) countPinned(uid string) int {
+	count := 0
+	for _, conv := range cs.chats[uid] {
+		if conv.Pinned {
+			count++
+		}
+	}
+	return count
+}
+
+// Remove removes conversations of user and conversations of others with him
+func (cs *Conversations) Remove(uid string) {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	for peer := range cs.chats[uid] {
+		delete(cs.chats[peer], uid)
+	}
+	delete(cs.chats, uid)
+}
+
+// conversationData returns conversation with last message and unread count
+func (s *MessageServer) conversationData(uid string, conv Conversation) ConversationData {
+	data := ConversationData{
+		Uid:        conv.Peer,
+		ReadMid:    conv.ReadMid,
+		Unread:     s.history.Unread(uid, conv.Peer, conv.ReadMid),
+		Pinned:     conv.Pinned,
+		Archived:   conv.Archived,
+		MutedUntil: conv.MutedUntil,
+		Muted:      conv.Muted(int(time.Now().Unix())),
+	}
+	data.Nick, _, _ = s.Credentials(conv.Peer)
+	if m, ok := s.history.Last(uid, conv.Peer); ok {
+		data.Last = m.Preview()
+	}
+	return data
+}
+
+// GetConversations sends to user his conversations: pinned ones first, then by last message
+func (s *MessageServer) GetConversations(c *Client) {
+	list := make([]ConversationData, 0)
+	for _, conv := range s.conversations.List(c.cid) {
+		list = append(list, s.conversationData(c.cid, conv))
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Pinned != list[j].Pinned {
+			return list[i].Pinned
+		}
+		mid1, mid2 := "", ""
+		if list[i].Last != nil {
+			mid1 = list[i].Last.Mid
+		}
+		if list[j].Last != nil {
+			mid2 = list[j].Last.Mid
+		}
+		if mid1 != mid2 {
+			return midLess(mid2, mid1)
+		}
+		return list[i].Uid < list[j].Uid
+	})
+
+	answer := SrvConversations{List: list}
+	answer.Status = ErrOK
+	answer.Error = "OK"
+	m, err := json.Marshal(struct {
+		Action string           `json:"action"`
+		Data   SrvConversations `json:"data"`
+	}{
+		Action: "conversations",
+		Data:   answer,
+	})
+	if !c.CheckError(err, "Can't marhsal answer") {
+		return
+	}
+	c.Send(m)
+}
+
+// sendConversation sends conversation as answer to action, other devices of user get it by sync
+func (s *MessageServer) sendConversation(c *Client, action string, conv Conversation) {
+	answer := SrvConversation{ConversationData: s.conversationData(c.cid, conv)}
+	s.record("ev_conversation", answer.ConversationData, c.cid)
+	answer.Status = ErrOK
+	answer.Error = "OK"
+	m, err := json.Marshal(struct {
+		Action string          `json:"action"`
+		Data   SrvConversation `json:"data"`
+	}{
+		Action: action,
+		Data:   answer,
+	})
+	if !c.CheckError(err, "Can't marhsal answer") {
+		return
+	}
+	c.Send(m)
+}
+
+// SetConversation user pins, archives or mutes conversation. Fields missing in request stay unchanged.
+func (s *MessageServer) SetConversation(c *Client, im CltSetConversation) {
+	if _, ok := s.conversations.Get(c.cid, im.User); !ok && !s.Exists(im.User) {
+		c.Error("setconversation", "User not found", ErrUserNotFound, false)
+		return
+	}
+	if im.MutedUntil != nil && *im.MutedUntil < MutedForever {
+		c.Error("setconversation", "Invalid mute time", ErrInvalidData, false)
+		return
+	}
+	if im.Pinned != nil && *im.Pinned {
+		if _, ok := s.conversations.Pin(c.cid, im.User, s.config.MaxPinned); !ok {
+			c.Error("setconversation", "Too many pinned conversations", ErrTooLarge, false)
+			return
+		}
+	}
+	conv := s.conversations.Update(c.cid, im.User, func(conv *Conversation) {
+		if im.Pinned != nil && !*im.Pinned {
+			conv.Pinned = false
+		}
+		if im.Archived != nil {
+			conv.Archived = *im.Archived
+		}
+		if im.MutedUntil != nil {
+			conv.MutedUntil = *im.MutedUntil
+		}
+	})
+	s.sendConversation(c, "setconversation", conv)
+}
+
+// MarkRead user reads messages of conversation up to message mid (empty mid - up to last message)
+func (s *MessageServer) MarkRead(c *Client, uid string, mid string) {
+	if mid == "" {
+		m, ok := s.history.Last(c.cid, uid)
+		if !ok {
+			c.Error("markread", "Message not found", ErrMessageNotFound, false)
+			return
+		}
+		mid = m.Mid
+	}
+	m, ok := s.history.Get(mid)
+	if !ok || DialogKey(m.From, m.To) != DialogKey(c.cid, uid) {
+		c.Error("markread", "Message not found", ErrMessageNotFound, false)
+		return
+	}
+	// Read position doesn't go back, e.g. after late request of another device
+	conv := s.conversations.Update(c.cid, uid, func(conv *Conversation) {
+		if conv.ReadMid == "" || midLess(conv.ReadMid, mid) {
+			conv.ReadMid = mid
+		}
+	})
+	s.sendConversation(c, "markread", conv)
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// lastConversations decodes last answer with list of conversations
+func lastConversations(t *testing.T, c *Client, conn *testConn) []ConversationData {
+	c.Flush()
+	var m struct {
+		Action string           `json:"action"`
+		Data   SrvConversations `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(conn.Messages[len(conn.Messages)-1]), &m); err != nil {
+		t.Fatalf("Invalid answer: %v", err)
+	}
+	if m.Action != "conversations" || m.Data.Status != ErrOK {
+		t.Fatalf("Invalid answer: %s", conn.Messages[len(conn.Messages)-1])
+	}
+	return m.Data.List
+}
+
+// TestHistoryUnread checks History.Last and History.Unread
+func TestHistoryUnread(t *testing.T) {
+	h := NewHistory()
+	mids := make([]string, 0)
+	for _, from := range []string{"a", "b", "b", "a", "b"} {
+		to := "b"
+		if from == "b" {
+			to = "a"
+		}
+		mids = append(mids, h.Add(&StoredMessage{From: from, To: to, Body: "text " + from}))
+	}
+	if n := h.Unread("a", "b", ""); n != 3 {
+		t.Errorf("Unread of all messages %d, waits 3", n)
+	}
+	if n := h.Unread("a", "b", mids[1]); n != 2 {
+		t.Errorf("Unread after second message %d, waits 2", n)
+	}
+	if n := h.Unread("b", "a", mids[3]); n != 0 {
+		t.Errorf("Unread of author %d, waits 0", n)
+	}
+	h.Delete(mids[4])
+	if n := h.Unread("a", "b", mids[1]); n != 1 {
+		t.Errorf("Deleted message is unread")
+	}
+	if m, ok := h.Last("b", "a"); !ok || m.Mid != mids[3] {
+		t.Errorf("Last message %s, waits %s", m.Mid, mids[3])
+	}
+	if _, ok := h.Last("a", "c"); ok {
+		t.Errorf("Last message of empty dialog")
+	}
+}
+
+// TestServerConversations checks list of conversations, unread counts and settings
+func TestServerConversations(t *testing.T) {
+	gServer = newServer()
+	conns := []*testConn{newTestConn(), newTestConn(), newTestConn()}
+	users := make([]*Client, len(conns))
+	for i, login := range []string{"user1", "user2", "user3"} {
+		users[i] = NewTestClient(conns[i])
+		gServer.Register(users[i], login, "pass", "Nick "+login)
+		users[i].Auth(login, "pass")
+	}
+
+	gServer.SendMessage(users[1], "user1", "hello", AttachData{}, MessageLinks{}, nil)
+	gServer.SendMessage(users[2], "user1", "first", AttachData{}, MessageLinks{}, nil)
+	gServer.SendMessage(users[2], "user1", strings.Repeat("я", 150), AttachData{}, MessageLinks{}, nil)
+
+	// Newest dialog goes first
+	gServer.GetConversations(users[0])
+	list := lastConversations(t, users[0], conns[0])
+	if len(list) != 2 || list[0].Uid != "user3" || list[1].Uid != "user2" {
+		t.Fatalf("Invalid order of conversations %+v", list)
+	}
+	if list[0].Nick != "Nick user3" || list[0].Unread != 2 || list[1].Unread != 1 {
+		t.Errorf("Invalid unread counts %+v", list)
+	}
+	if list[0].Last == nil || len([]rune(list[0].Last.Body)) != previewLength+1 {
+		t.Errorf("Body of preview is not truncated %+v", list[0].Last)
+	}
+
+	// Author has no unread messages
+	gServer.GetConversations(users[2])
+	list = lastConversations(t, users[2], conns[2])
+	if len(list) != 1 || list[0].Uid != "user1" || list[0].Unread != 0 {
+		t.Errorf("Invalid conversations of author %+v", list)
+	}
+
+	// Pinned dialog goes first
+	pinned := true
+	gServer.SetConversation(users[0], CltSetConversation{User: "user2", Pinned: &pinned})
+	gServer.MarkRead(users[0], "user3", "")
+	gServer.GetConversations(users[0])
+	list = lastConversations(t, users[0], conns[0])
+	if list[0].Uid != "user2" || !list[0].Pinned || list[1].Unread != 0 {
+		t.Errorf("Invalid conversations after changes %+v", list)
+	}
+
+	// Archived and muted dialog stays in archive after new message
+	archived, muted := true, MutedForever
+	gServer.SetConversation(users[0], CltSetConversation{User: "user3", Archived: &archived, MutedUntil: &muted})
+	gServer.SendMessage(users[2], "user1", "again", AttachData{}, MessageLinks{}, nil)
+	if conv, _ := gServer.conversations.Get("user1", "user3"); !conv.Archived {
+		t.Errorf("Muted conversation is unarchived")
+	}
+	// Not muted dialog returns from archive
+	unmuted := 0
+	gServer.SetConversation(users[0], CltSetConversation{User: "user3", MutedUntil: &unmuted})
+	gServer.SendMessage(users[2], "user1", "and again", AttachData{}, MessageLinks{}, nil)
+	if conv, _ := gServer.conversations.Get("user1", "user3"); conv.Archived || conv.MutedUntil != 0 {
+		t.Errorf("Conversation is not unarchived %+v", conv)
+	}
+
+	// Read position doesn't go back
+	before, _ := gServer.conversations.Get("user1", "user3")
+	gServer.MarkRead(users[0], "user3", "1")
+	if conv, _ := gServer.conversations.Get("user1", "user3"); conv.ReadMid != before.ReadMid {
+		t.Errorf("Read position goes back to %s", conv.ReadMid)
+	}
+	users[0].Flush()
+	gServer.MarkRead(users[0], "user2", "3")
+	users[0].Flush()
+	if err := conns[0].CheckLastMessage(t, `{"action":"markread","data":{"status":9,"error":"Message not found"}}`); err != nil {
+		t.Errorf("%v", err)
+	}
+
+	gServer.SetConversation(users[0], CltSetConversation{User: "nobody", Pinned: &pinned})
+	users[0].Flush()
+	if err := conns[0].CheckLastMessage(t, `{"action":"setconversation","data":{"status":8,"error":"User not found"}}`); err != nil {
+		t.Errorf("%v", err)
+	}
+}
+
+// TestServerMaxPinned checks limit of pinned conversations
+func TestServerMaxPinned(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxPinned = 1
+	gServer = newServerWithConfig(config)
+	conn := newTestConn()
+	c := NewTestClient(conn)
+	for _, login := range []string{"user1", "user2", "user3"} {
+		gServer.Register(NewTestClient(newTestConn()), login, "pass", login)
+	}
+	c.Auth("user1", "pass")
+
+	pinned := true
+	gServer.SetConversation(c, CltSetConversation{User: "user2", Pinned: &pinned})
+	// Pinned conversation can be pinned again
+	gServer.SetConversation(c, CltSetConversation{User: "user2", Pinned: &pinned})
+	c.Flush()
+	if err := conn.CheckLastMessage(t, `{"action":"setconversation","data":{"uid":"user2","nick":"user2","unread":0,"pinned":true,"archived":false,"muted":false,"status":0,"error":"OK"}}`); err != nil {
+		t.Errorf("%v", err)
+	}
+	gServer.SetConversation(c, CltSetConversation{User: "user3", Pinned: &pinned})
+	c.Flush()
+	if err := conn.CheckLastMessage(t, `{"action":"setconversation","data":{"status":13,"error":"Too many pinned conversations"}}`); err != nil {
+		t.Errorf("%v", err)
+	}
+	if _, ok := gServer.conversations.Get("user1", "user3"); ok {
+		t.Errorf("Conversation is created by rejected pin")
+	}
+}
+
+// TestConversationsPin checks limit of pinned conversations with concurrent requests
+func TestConversationsPin(t *testing.T) {
+	cs := NewConversations()
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(peer string) {
+			defer wg.Done()
+			cs.Pin("user", peer, 3)
+		}(fmt.Sprint("peer", i))
+	}
+	wg.Wait()
+
+	if list := cs.List("user"); len(list) != 3 {
+		t.Errorf("Invalid pinned conversations %v", list)
+	}
+}
@@ -0,0 +1,226 @@
+package server
+
+import (
+	"errors"
+	"sync"
+)
+
+// Account is a record of registered user. It lives without connection, Client
+// keeps a copy of profile for the session and writes changes back.
+type Account struct {
+	Uid           string
+	Nick          string
+	Status        string
+	Avatar        string
+	Email         string
+	Phone         string
+	EmailVerified bool
+	PhoneVerified bool
+	Role          Role              // Permissions of user
+	Contacts      map[string]string // Map of uids of contacts (key uid; value uid)
+}
+
+// UserDirectory keeps accounts and indexes of their nicks, emails and phones.
+// Only verified email and phone are indexed, each of them belongs to one user.
+type UserDirectory struct {
+	Logins       map[string]string
+	Nicks        map[string]string
+	LoginsPasses map[string]string
+	Users        map[string]string
+	emails       map[string]string   // map key - email; val - uid
+	phones       map[string]string   // map key - phone; val - uid
+	accounts     map[string]*Account // map key - uid
+	dirMutex     sync.RWMutex        // Guards all maps of directory
+}
+
+// NewUserDirectory is constructor of UserDirectory
+func NewUserDirectory() *UserDirectory {
+	return &UserDirectory{
+		Logins:       make(map[string]string),
+		Nicks:        make(map[string]string),
+		LoginsPasses: make(map[string]string),
+		Users:        make(map[string]string),
+		emails:       make(map[string]string),
+		phones:       make(map[string]string),
+		accounts:     make(map[string]*Account),
+	}
+}
+
+// AddAccount creates account with unique login and nick
+func (d *UserDirectory) AddAccount(login string, pass string, nick string) (int, error) {
+	d.dirMutex.Lock()
+	defer d.dirMutex.Unlock()
+
+	if _, ok := d.Nicks[nick]; ok {
+		return ErrAlreadyExist, errors.New("Nick already was used")
+	}
+	if _, ok := d.Logins[login]; ok {
+		return ErrAlreadyExist, errors.New("Login already was used")
+	}
+	d.Nicks[nick] = login
+	d.Logins[login] = nick
+	if pass != "" {
+		d.LoginsPasses[login] = pass
+	}
+	d.Users[login] = login
+	d.accounts[login] = &Account{Uid: login, Nick: nick, Role: RoleUser, Contacts: make(map[string]string)}
+	return ErrOK, nil
+}
+
+// Credentials returns nick and password of login
+func (d *UserDirectory) Credentials(login string) (string, string, bool) {
+	d.dirMutex.RLock()
+	defer d.dirMutex.RUnlock()
+
+	nick, ok := d.Logins[login]
+	return nick, d.LoginsPasses[login], ok
+}
+
+// Exists checks if user is registered
+func (d *UserDirectory) Exists(uid string) bool {
+	d.dirMutex.RLock()
+	defer d.dirMutex.RUnlock()
+
+	_, ok := d.Logins[uid]
+	return ok
+}
+
+// CountUsers returns count of registered users
+func (d *UserDirectory) CountUsers() int {
+	d.dirMutex.RLock()
+	defer d.dirMutex.RUnlock()
+	return len(d.Logins)
+}
+
+// SetPassword changes password of user
+func (d *UserDirectory) SetPassword(uid string, pass string) bool {
+	d.dirMutex.Lock()
+	defer d.dirMutex.Unlock()
+
+	if _, ok := d.Logins[uid]; !ok {
+		return false
+	}
+	d.LoginsPasses[uid] = pass
+	return true
+}
+
+// Account returns copy of account of user
+func (d *UserDirectory) Account(uid string) (Account, bool) {
+	d.dirMutex.RLock()
+	defer d.dirMutex.RUnlock()
+
+	a, ok := d.accounts[uid]
+	if !ok {
+		return Account{}, false
+	}
+	copied := *a
+	copied.Contacts = make(map[string]string, len(a.Contacts))
+	for k, v := range a.Contacts {
+		copied.Contacts[k] = v
+	}
+	return copied, true
+}
+
+// UpdateAccount changes account of user under lock of directory
+func (d *UserDirectory) UpdateAccount(uid string, update func(a *Account)) bool {
+	d.dirMutex.Lock()
+	defer d.dirMutex.Unlock()
+
+	a, ok := d.accounts[uid]
+	if ok {
+		update(a)
+	}
+	return ok
+}
+
+// RemoveAccount removes account and its nick, email and phone. Returns nick of user.
+func (d *UserDirectory) RemoveAccount(uid string) (string, bool) {
+	d.dirMutex.Lock()
+	defer d.dirMutex.Unlock()
+
+	nick, ok := d.Logins[uid]
+	if !ok {
+		return "", false
+	}
+	delete(d.Logins, uid)
+	delete(d.Nicks, nick)
+	delete(d.LoginsPasses, uid)
+	delete(d.Users, uid)
+	delete(d.accounts, uid)
+	for email, owner := range d.emails {
+		if owner == uid {
+			delete(d.emails, email)
+		}
+	}
+	for phone, owner := range d.phones {
+		if owner == uid {
+			delete(d.phones, phone)
+		}
+	}
+	return nick, true
+}
+
+// ContactOf returns uids of users who have user in contacts
+func (d *UserDirectory) ContactOf(uid string) []string {
+	d.dirMutex.RLock()
+	defer d.dirMutex.RUnlock()
+
+	uids := make([]string, 0)
+	for owner, a := range d.accounts {
+		if _, ok := a.Contacts[uid]; ok {
+			uids = append(uids, owner)
+		}
+	}
+	return uids
+}
+
+// index returns index of kind (VerifyEmail or VerifyPhone)
+func (d *UserDirectory) index(kind string) map[string]string {
+	if kind == VerifyEmail {
+		return d.emails
+	}
+	return d.phones
+}
+
+// Owner returns uid of user with verified email or phone
+func (d *UserDirectory) Owner(kind string, address string) (string, bool) {
+	d.dirMutex.RLock()
+	defer d.dirMutex.RUnlock()
+
+	uid, ok := d.index(kind)[address]
+	return uid, ok
+}
+
+// Claim indexes verified email or phone of user, it fails if address belongs to another user
+func (d *UserDirectory) Claim(kind string, address string, uid string) (int, error) {
+	d.dirMutex.Lock()
+	defer d.dirMutex.Unlock()
+
+	index := d.index(kind)
+	if owner, ok := index[address]; ok && owner != uid {
+		if kind == VerifyEmail {
+			return ErrAlreadyExist, errors.New("Email already was used")
+		}
+		return ErrAlreadyExist, errors.New("Phone already was used")
+	}
+	index[address] = uid
+	if a, ok := d.accounts[uid]; ok {
+		if kind == VerifyEmail {
+			a.Email, a.EmailVerified = address, true
+		} else {
+			a.Phone, a.PhoneVerified = address, true
+		}
+	}
+	return ErrOK, nil
+}
+
+// Release removes email or phone from index if it belongs to user
+func (d *UserDirectory) Release(kind string, address string, uid string) {
+	d.dirMutex.Lock()
+	defer d.dirMutex.Unlock()
+
+	index := d.index(kind)
+	if index[address] == uid {
+		delete(index, address)
+	}
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestServerUniqueContacts checks that verified email and phone belong to one user
+func TestServerUniqueContacts(t *testing.T) {
+	gServer = newServer()
+	conns := []*testConn{newTestConn(), newTestConn(), newTestConn()}
+	users := make([]*Client, len(conns))
+	for i, login := range []string{"user1", "user2", "user3"} {
+		users[i] = NewTestClient(conns[i])
+		gServer.Register(users[i], login, "pass", login)
+		users[i].Auth(login, "pass")
+	}
+
+	// Both users wait for codes, the first verified one gets email
+	sender := gServer.verifier.sender.(*LocalSender)
+	users[0].SetUserInfo("", "same@mail.ru", "+79123456789", "")
+	code := sender.Code("same@mail.ru")
+	users[1].SetUserInfo("", "Same@Mail.ru", "", "")
+	users[1].Flush()
+	if err := conns[1].CheckLastMessage(t, `{"action":"setuserinfo","data":{"status":0,"error":"OK"}}`); err != nil {
+		t.Errorf("%v", err)
+	}
+	code2 := sender.Code("same@mail.ru")
+	gServer.Verify(users[0], VerifyEmail, code)
+	verifyContacts(users[0])
+	gServer.Verify(users[1], VerifyEmail, code2)
+	users[1].Flush()
+	if err := conns[1].CheckLastMessage(t, `{"action":"verify","data":{"status":1,"error":"Email already was used"}}`); err != nil {
+		t.Errorf("%v", err)
+	}
+
+	// Verified email and phone of another user are rejected
+	users[2].SetUserInfo("ava", "SAME@mail.ru", "", "online")
+	users[2].Flush()
+	if err := conns[2].CheckLastMessage(t, `{"action":"setuserinfo","data":{"status":1,"error":"Email already was used"}}`); err != nil {
+		t.Errorf("%v", err)
+	}
+	users[2].SetUserInfo("", "", "8 912 345-67-89", "")
+	users[2].Flush()
+	if err := conns[2].CheckLastMessage(t, `{"action":"setuserinfo","data":{"status":1,"error":"Phone already was used"}}`); err != nil {
+		t.Errorf("%v", err)
+	}
+	if users[2].email != "" || users[2].phone != "" || users[2].avatar != "" {
+		t.Errorf("Rejected data is saved")
+	}
+	if uid, ok := gServer.Owner(VerifyEmail, "same@mail.ru"); !ok || uid != "user1" {
+		t.Errorf("Email is stolen by %s", uid)
+	}
+
+	// Email is free after owner changes it
+	users[0].SetUserInfo("", "other@mail.ru", "", "")
+	users[2].SetUserInfo("", "same@mail.ru", "", "")
+	verifyContacts(users[2])
+	if uid, ok := gServer.Owner(VerifyEmail, "same@mail.ru"); !ok || uid != "user3" {
+		t.Errorf("Email is not moved to user3 (%s)", uid)
+	}
+}
+
+// TestServerOfflineUserInfo checks profile of user who has no live connection
+func TestServerOfflineUserInfo(t *testing.T) {
+	gServer = newServer()
+	conn := newTestConn()
+	c := NewTestClient(conn)
+	gServer.Register(c, "user", "pass", "user")
+	c.Auth("user", "pass")
+
+	// Registered user who never connected
+	gServer.Register(NewTestClient(newTestConn()), "offline", "pass", "Offline")
+	gServer.GetUserInfo(c, "offline")
+	c.Flush()
+	if err := conn.CheckLastMessage(t, `{"action":"userinfo","data":{"nick":"Offline","user_status":"","email":"","phone":"","picture":"","role":"user","status":0,"error":"OK"}}`); err != nil {
+		t.Errorf("%v", err)
+	}
+	c.AddContact("offline")
+	c.Flush()
+	if err := conn.CheckLastMessage(t, `{"action":"addcontact","data":{"status":0,"error":"OK"}}`); err != nil {
+		t.Errorf("%v", err)
+	}
+	c.SetUserInfo("ava", "user@mail.ru", "", "busy")
+	verifyContacts(c)
+
+	// New session gets profile and contacts from account
+	conn2 := newTestConn()
+	c2 := NewTestClient(conn2)
+	c2.Auth("user", "pass")
+	if c2.avatar != "ava" || c2.status != "busy" || c2.email != "user@mail.ru" || !c2.emailVerified || c2.contacts["offline"] != "offline" {
+		t.Errorf("Profile is not restored %+v", c2.account())
+	}
+	c2.GetContactList()
+	c2.Flush()
+	if err := conn2.CheckLastMessage(t, `{"action":"contactlist","data":{"list":[{"uid":"offline","nick":"Offline","email":"","phone":"","picture":""}],"status":0,"error":"OK"}}`); err != nil {
+		t.Errorf("%v", err)
+	}
+}
+
+// TestServerOfflineMessage checks dialog with registered user who has no session
+func TestServerOfflineMessage(t *testing.T) {
+	gServer = newServer()
+	conn := newTestConn()
+	c := NewTestClient(conn)
+	gServer.Register(c, "user", "pass", "user")
+	c.Auth("user", "pass")
+	gServer.AddAccount("offline", "pass", "Offline")
+
+	gServer.SendMessage(c, "offline", "Hello", AttachData{}, MessageLinks{}, nil)
+	c.Flush()
+	if !strings.Contains(strings.Join(conn.Messages, ""), `{"action":"message","data":{"status":0,"error":"OK"}}`) {
+		t.Errorf("Message is rejected %v", conn.Messages)
+	}
+	gServer.GetHistory(c, "offline", "", 0)
+	c.Flush()
+	if last := conn.Messages[len(conn.Messages)-1]; !strings.Contains(last, `"body":"Hello"`) || !strings.Contains(last, `"status":0`) {
+		t.Errorf("Invalid history %s", last)
+	}
+
+	// User gets message from history after connect
+	conn2 := newTestConn()
+	c2 := NewTestClient(conn2)
+	c2.Auth("offline", "pass")
+	gServer.GetHistory(c2, "user", "", 0)
+	c2.Flush()
+	if last := conn2.Messages[len(conn2.Messages)-1]; !strings.Contains(last, `"body":"Hello"`) {
+		t.Errorf("Invalid history %s", last)
+	}
+}
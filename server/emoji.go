@@ -0,0 +1,90 @@
+package server
+
+import "unicode/utf8"
+
+// Special code points of emoji sequences
+const (
+	emojiZWJ       = 0x200D // Zero width joiner of sequences, e.g. family
+	emojiVariation = 0xFE0F // Emoji presentation of previous character
+	emojiKeycap    = 0x20E3 // Combining keycap after digit, # or *
+)
+
+// Ranges of pictographic characters which can be emoji (first and last code point)
+var emojiRanges = [][2]rune{
+	{0x00A9, 0x00A9}, {0x00AE, 0x00AE}, {0x203C, 0x203C}, {0x2049, 0x2049},
+	{0x2122, 0x2122}, {0x2139, 0x2139}, {0x2194, 0x2199}, {0x21A9, 0x21AA},
+	{0x231A, 0x231B}, {0x2328, 0x2328}, {0x23CF, 0x23CF}, {0x23E9, 0x23F3},
+	{0x23F8, 0x23FA}, {0x24C2, 0x24C2}, {0x25AA, 0x25AB}, {0x25B6, 0x25B6},
+	{0x25C0, 0x25C0}, {0x25FB, 0x25FE}, {0x2600, 0x27BF}, {0x2934, 0x2935},
+	{0x2B05, 0x2B07}, {0x2B1B, 0x2B1C}, {0x2B50, 0x2B50}, {0x2B55, 0x2B55},
+	{0x3030, 0x3030}, {0x303D, 0x303D}, {0x3297, 0x3297}, {0x3299, 0x3299},
+	{0x1F000, 0x1F1E5}, {0x1F200, 0x1F3FA}, {0x1F400, 0x1FAFF},
+}
+
+// isPictographic checks if character is emoji itself, not a modifier
+func isPictographic(r rune) bool {
+	for _, rng := range emojiRanges {
+		if r >= rng[0] && r <= rng[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// isRegional checks if character is a letter of flag
+func isRegional(r rune) bool {
+	return r >= 0x1F1E6 && r <= 0x1F1FF
+}
+
+// isSkinTone checks if character is a skin tone modifier
+func isSkinTone(r rune) bool {
+	return r >= 0x1F3FB && r <= 0x1F3FF
+}
+
+// isEmojiTag checks if character is a tag of subdivision flag, e.g. flag of Scotland
+func isEmojiTag(r rune) bool {
+	return r >= 0xE0020 && r <= 0xE007F
+}
+
+// validEmoji checks that s is one emoji: pictograph with modifiers, sequence of
+// them joined by ZWJ, flag of country or keycap
+func validEmoji(s string) bool {
+	if !utf8.ValidString(s) {
+		return false
+	}
+	r := []rune(s)
+	if len(r) == 0 {
+		return false
+	}
+	if isRegional(r[0]) {
+		return len(r) == 2 && isRegional(r[1])
+	}
+	if r[0] == '#' || r[0] == '*' || (r[0] >= '0' && r[0] <= '9') {
+		rest := r[1:]
+		if len(rest) > 0 && rest[0] == emojiVariation {
+			rest = rest[1:]
+		}
+		return len(rest) == 1 && rest[0] == emojiKeycap
+	}
+
+	i := 0
+	for {
+		if i >= len(r) || !isPictographic(r[i]) {
+			return false
+		}
+		i++
+		if i < len(r) && (r[i] == emojiVariation || isSkinTone(r[i])) {
+			i++
+		}
+		for i < len(r) && isEmojiTag(r[i]) {
+			i++
+		}
+		if i == len(r) {
+			return true
+		}
+		if r[i] != emojiZWJ {
+			return false
+		}
+		i++
+	}
+}
@@ -0,0 +1,40 @@
+package server
+
+import "testing"
+
+// TestValidEmoji checks validEmoji
+func TestValidEmoji(t *testing.T) {
+	var testData = []struct {
+		emoji string
+		ok    bool
+	}{
+		{"👍", true},
+		{"👍🏽", true},
+		{"❤️", true},
+		{"❤", true},
+		{"🏳️‍🌈", true},
+		{"👨‍👩‍👧‍👦", true},
+		{"🧑🏻‍❤️‍💋‍🧑🏼", true},
+		{"🇷🇺", true},
+		{"🏴\U000E0067\U000E0062\U000E0073\U000E0063\U000E0074\U000E007F", true},
+		{"1️⃣", true},
+		{"#⃣", true},
+		{"", false},
+		{"+1", false},
+		{":)", false},
+		{"a", false},
+		{"👍👍", false},
+		{"👍a", false},
+		{"👍‍", false},
+		{"🇷", false},
+		{"🇷🇺🇷", false},
+		{"🏽", false},
+		{"1", false},
+		{"\xff", false},
+	}
+	for _, val := range testData {
+		if ok := validEmoji(val.emoji); ok != val.ok {
+			t.Errorf("validEmoji(%q) = %v waits %v", val.emoji, ok, val.ok)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+// isConnLost checks that error means lost connection rather than invalid data.
+// Connection closed by server (kick, new connection of user) is lost too.
+func isConnLost(err error) bool {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, net.ErrClosed) || errors.Is(err, io.ErrClosedPipe) {
+		return true
+	}
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
+
+// setReadDeadline gives user ReadTimeout to send next request
+func (c *Client) setReadDeadline() {
+	if timeout := c.config().ReadTimeout; timeout > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(timeout))
+	}
+}
+
+// setWriteDeadline gives user WriteTimeout to read next message
+func (c *Client) setWriteDeadline() {
+	if timeout := c.config().WriteTimeout; timeout > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(timeout))
+	}
+}
+
+// heartbeat - send ping to user while connection is alive
+func (c *Client) heartbeat(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.Ping("ping")
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+// Ping sends to user ping (server heartbeat) or pong (answer to ping)
+func (c *Client) Ping(action string) {
+	m, err := json.Marshal(struct {
+		Action string      `json:"action"`
+		Data   SrvPingData `json:"data"`
+	}{
+		Action: action,
+		Data: SrvPingData{
+			Time: int(time.Now().Unix()),
+		},
+	})
+	if !c.CheckError(err, "Can't marhsal message") {
+		return
+	}
+	c.Send(m)
+}
+
+// connLost - user is offline after network error
+func (c *Client) connLost(err error) {
+	c.logger(LogQueue).Info("Connection lost", "err", err)
+	c.Disconnect()
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// newHeartbeatTestServer creates server with short timeouts
+func newHeartbeatTestServer(read, write, heartbeat time.Duration) {
+	config := DefaultConfig()
+	config.ReadTimeout = read
+	config.WriteTimeout = write
+	config.HeartbeatInterval = heartbeat
+	gServer = newServerWithConfig(config)
+}
+
+// waitDisconnect waits until client is offline
+func waitDisconnect(c *Client) bool {
+	for i := 0; i < 100; i++ {
+		if !c.isConnected() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+// readAction reads next message from connection and returns its action
+func readAction(dec *json.Decoder) string {
+	var m SrvMessage
+	if err := dec.Decode(&m); err != nil {
+		return err.Error()
+	}
+	return m.Action
+}
+
+// TestClientPing checks ping and pong actions
+func TestClientPing(t *testing.T) {
+	newHeartbeatTestServer(time.Second, time.Second, 0)
+
+	server, client := net.Pipe()
+	defer client.Close()
+	c := NewClient(server)
+	listenTest(t, c)
+
+	dec := json.NewDecoder(client)
+	if action := readAction(dec); action != "welcome" {
+		t.Fatalf("Invalid first message '%s'", action)
+	}
+	fmt.Fprint(client, "{\"action\":\"ping\"}")
+	if action := readAction(dec); action != "pong" {
+		t.Errorf("Invalid answer to ping '%s'", action)
+	}
+	// Answer to server ping has no answer
+	fmt.Fprint(client, "{\"action\":\"pong\"}{\"action\":\"ping\"}")
+	if action := readAction(dec); action != "pong" {
+		t.Errorf("Invalid answer to ping '%s'", action)
+	}
+}
+
+// TestClientHeartbeat checks server pings
+func TestClientHeartbeat(t *testing.T) {
+	newHeartbeatTestServer(time.Second, time.Second, 20*time.Millisecond)
+
+	server, client := net.Pipe()
+	defer client.Close()
+	c := NewClient(server)
+	listenTest(t, c)
+
+	dec := json.NewDecoder(client)
+	readAction(dec)
+	for i := 0; i < 2; i++ {
+		if action := readAction(dec); action != "ping" {
+			t.Errorf("Invalid heartbeat '%s'", action)
+		}
+	}
+}
+
+// TestClientReadTimeout checks disconnect of silent client
+func TestClientReadTimeout(t *testing.T) {
+	newHeartbeatTestServer(50*time.Millisecond, time.Second, 0)
+
+	server, client := net.Pipe()
+	defer client.Close()
+	c := NewClient(server)
+	listenTest(t, c)
+
+	dec := json.NewDecoder(client)
+	readAction(dec)
+	if !waitDisconnect(c) {
+		t.Errorf("Silent client was not disconnected")
+	}
+
+	// Messages to disconnected client are kept offline
+	c.Send([]byte("message"))
+	c.Flush()
+	if len(c.offlineMessages) != 1 {
+		t.Errorf("Invalid offline messages %s", c.offlineMessages)
+	}
+}
+
+// TestClientWriteTimeout checks disconnect of client which doesn't read
+func TestClientWriteTimeout(t *testing.T) {
+	newHeartbeatTestServer(time.Second, 50*time.Millisecond, 0)
+
+	server, client := net.Pipe()
+	defer client.Close()
+	c := NewClient(server)
+	go c.write()
+
+	c.Send([]byte("message"))
+	if !waitDisconnect(c) {
+		t.Fatalf("Stuck client was not disconnected")
+	}
+	c.Flush()
+	if fmt.Sprintf("%s", c.offlineMessages) != "[message]" {
+		t.Errorf("Invalid offline messages %s", c.offlineMessages)
+	}
+}
+
+// TestClientReadClosed checks that connection closed by server is not an invalid request
+func TestClientReadClosed(t *testing.T) {
+	newHeartbeatTestServer(time.Second, time.Second, 0)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Can't listen: %v", err)
+	}
+	defer listener.Close()
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Can't connect: %v", err)
+	}
+	defer client.Close()
+	server, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Can't accept: %v", err)
+	}
+
+	c := NewTestClient(server)
+	done := make(chan struct{})
+	go func() {
+		c.read()
+		close(done)
+	}()
+	if action := readAction(json.NewDecoder(client)); action != "welcome" {
+		t.Fatalf("Invalid first message '%s'", action)
+	}
+
+	// Kick closes connection while read goroutine waits for request
+	c.Disconnect()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Read goroutine was not stopped")
+	}
+	c.Flush()
+	if offline := c.takeOfflineMessages(); len(offline) != 0 {
+		t.Errorf("Error was kept offline %s", offline)
+	}
+}
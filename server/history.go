@@ -0,0 +1,309 @@
+package server
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// StoredMessage is a message saved in history
+type StoredMessage struct {
+	Mid     string     // Message ID
+	From    string     // UserID of author
+	To      string     // UserID of recipient
+	Nick    string     // Nickname of author
+	Body    string     // Text of message
+	Attach  AttachData // Attachment of message
+	Time    int        // Time of sending
+	Edited  int        // Time of last edit (0 - was not edited)
+	Deleted bool       // Message was deleted by author
+
+	ReplyTo   string       // Message ID of replied message
+	Forwarded *ForwardData // Original message of forwarded one
+	Envelopes []Envelope   // Ciphertexts of end-to-end encrypted message
+
+	Reactions map[string]map[string]bool // map key - emoji; val - set of uids
+}
+
+// Event returns message in ev_message format
+func (m *StoredMessage) Event() EvSrvMessage {
+	return EvSrvMessage{
+		Mid:           m.Mid,
+		From:          m.From,
+		Nick:          m.Nick,
+		Body:          m.Body,
+		Time:          m.Time,
+		Attach:        m.Attach,
+		ReplyTo:       m.ReplyTo,
+		ForwardedFrom: m.Forwarded,
+		Envelopes:     m.Envelopes,
+	}
+}
+
+// Data returns message in history format
+func (m *StoredMessage) Data() MessageData {
+	data := MessageData{
+		Mid:           m.Mid,
+		From:          m.From,
+		To:            m.To,
+		Nick:          m.Nick,
+		Body:          m.Body,
+		Time:          m.Time,
+		Attach:        m.Attach,
+		ReplyTo:       m.ReplyTo,
+		ForwardedFrom: m.Forwarded,
+		Edited:        m.Edited,
+		Envelopes:     m.Envelopes,
+	}
+	for emoji, users := range m.Reactions {
+		data.Reactions = append(data.Reactions, ReactionData{
+			Emoji: emoji,
+			Count: len(users),
+		})
+	}
+	sort.Slice(data.Reactions, func(i, j int) bool {
+		return data.Reactions[i].Emoji < data.Reactions[j].Emoji
+	})
+	return data
+}
+
+// Preview returns short form of message for list of conversations
+func (m *StoredMessage) Preview() *MessagePreview {
+	body := []rune(m.Body)
+	if len(body) > previewLength {
+		body = append(body[:previewLength], '…')
+	}
+	return &MessagePreview{
+		Mid:       m.Mid,
+		From:      m.From,
+		Body:      string(body),
+		Mime:      m.Attach.Mime,
+		Time:      m.Time,
+		Edited:    m.Edited,
+		Encrypted: len(m.Envelopes) > 0,
+	}
+}
+
+// Visible checks that user is a participant of dialog with message
+func (m *StoredMessage) Visible(uid string) bool {
+	return m.From == uid || m.To == uid
+}
+
+// History is a storage of sent messages
+type History struct {
+	mutex    sync.RWMutex
+	lastID   int
+	messages map[string]*StoredMessage // map key - mid
+	dialogs  map[string][]string       // map key - dialog key; val - mids in order of sending
+	index    *SearchIndex
+}
+
+// NewHistory is constructor of History
+func NewHistory() *History {
+	return &History{
+		messages: make(map[string]*StoredMessage),
+		dialogs:  make(map[string][]string),
+		index:    NewSearchIndex(),
+	}
+}
+
+// DialogKey returns key of dialog between two users
+func DialogKey(uid1 string, uid2 string) string {
+	if uid1 > uid2 {
+		uid1, uid2 = uid2, uid1
+	}
+	return uid1 + ":" + uid2
+}
+
+// Add saves message and assigns message ID to it
+func (h *History) Add(m *StoredMessage) string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.lastID++
+	m.Mid = strconv.Itoa(h.lastID)
+	h.messages[m.Mid] = m
+	key := DialogKey(m.From, m.To)
+	h.dialogs[key] = append(h.dialogs[key], m.Mid)
+	h.index.Add(m.Mid, m.Body)
+	return m.Mid
+}
+
+// NewID reserves message ID for message which is not kept in history
+func (h *History) NewID() string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.lastID++
+	return strconv.Itoa(h.lastID)
+}
+
+// Get returns copy of message by message ID
+func (h *History) Get(mid string) (StoredMessage, bool) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	m, ok := h.messages[mid]
+	if !ok {
+		return StoredMessage{}, false
+	}
+	return *m, true
+}
+
+// Edit changes body of message
+func (h *History) Edit(mid string, body string, time int) (StoredMessage, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	m, ok := h.messages[mid]
+	if !ok || m.Deleted {
+		return StoredMessage{}, false
+	}
+	h.index.Remove(mid, m.Body)
+	h.index.Add(mid, body)
+	m.Body = body
+	m.Edited = time
+	return *m, true
+}
+
+// Delete marks message as deleted and erases its content
+func (h *History) Delete(mid string) (StoredMessage, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	m, ok := h.messages[mid]
+	if !ok || m.Deleted {
+		return StoredMessage{}, false
+	}
+	h.index.Remove(mid, m.Body)
+	m.Deleted = true
+	m.Body = ""
+	m.Attach = AttachData{}
+	m.Reactions = nil
+	return *m, true
+}
+
+// React adds or removes reaction of user to message and returns
+// number of such reactions and whether reactions are changed
+func (h *History) React(mid string, uid string, emoji string, remove bool) (int, bool, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	m, ok := h.messages[mid]
+	if !ok || m.Deleted {
+		return 0, false, false
+	}
+	if m.Reactions == nil {
+		m.Reactions = make(map[string]map[string]bool)
+	}
+	users := m.Reactions[emoji]
+	changed := users[uid] != !remove
+	if remove {
+		delete(users, uid)
+		if len(users) == 0 {
+			delete(m.Reactions, emoji)
+		}
+		return len(users), changed, true
+	}
+	if users == nil {
+		users = make(map[string]bool)
+		m.Reactions[emoji] = users
+	}
+	users[uid] = true
+	return len(users), changed, true
+}
+
+// Dialog returns up to limit not deleted messages of dialog sent before
+// message with mid before (all messages if before is empty)
+func (h *History) Dialog(uid1 string, uid2 string, before string, limit int) []MessageData {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	mids := h.dialogs[DialogKey(uid1, uid2)]
+	if before != "" {
+		for i, mid := range mids {
+			if mid == before {
+				mids = mids[:i]
+				break
+			}
+		}
+	}
+
+	list := make([]MessageData, 0)
+	for i := len(mids) - 1; i >= 0 && len(list) < limit; i-- {
+		m := h.messages[mids[i]]
+		if !m.Deleted {
+			list = append(list, m.Data())
+		}
+	}
+	// Restore order of sending
+	for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
+		list[i], list[j] = list[j], list[i]
+	}
+	return list
+}
+
+// Last returns copy of last not deleted message of dialog
+func (h *History) Last(uid1 string, uid2 string) (StoredMessage, bool) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	mids := h.dialogs[DialogKey(uid1, uid2)]
+	for i := len(mids) - 1; i >= 0; i-- {
+		if m := h.messages[mids[i]]; !m.Deleted {
+			return *m, true
+		}
+	}
+	return StoredMessage{}, false
+}
+
+// Unread returns count of not deleted messages sent by peer to user after
+// message with mid after (all messages of peer if after is empty)
+func (h *History) Unread(uid string, peer string, after string) int {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	count := 0
+	mids := h.dialogs[DialogKey(uid, peer)]
+	for i := len(mids) - 1; i >= 0 && (after == "" || midLess(after, mids[i])); i-- {
+		if m := h.messages[mids[i]]; !m.Deleted && m.From == peer && m.To == uid {
+			count++
+		}
+	}
+	return count
+}
+
+// Search returns up to limit newest messages of user's dialogs
+// which contain all words of query and match filter
+func (h *History) Search(uid string, query string, filter SearchFilter, limit int) []MessageData {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	found := make([]*StoredMessage, 0)
+	for _, mid := range h.index.Find(query) {
+		m := h.messages[mid]
+		if !m.Deleted && m.Visible(uid) && filter.Match(m) {
+			found = append(found, m)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].Time != found[j].Time {
+			return found[i].Time > found[j].Time
+		}
+		return midLess(found[j].Mid, found[i].Mid)
+	})
+
+	list := make([]MessageData, 0)
+	for i := 0; i < len(found) && i < limit; i++ {
+		list = append(list, found[i].Data())
+	}
+	return list
+}
+
+// midLess compares message IDs in order of sending
+func midLess(mid1 string, mid2 string) bool {
+	if len(mid1) != len(mid2) {
+		return len(mid1) < len(mid2)
+	}
+	return mid1 < mid2
+}
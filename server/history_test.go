@@ -0,0 +1,124 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestHistoryAdd checks History.Add and History.Get
+func TestHistoryAdd(t *testing.T) {
+	h := NewHistory()
+
+	mid1 := h.Add(&StoredMessage{From: "user1", To: "user2", Body: "first"})
+	mid2 := h.Add(&StoredMessage{From: "user2", To: "user1", Body: "second"})
+	if mid1 == "" || mid1 == mid2 {
+		t.Errorf("Invalid message ids '%s' and '%s'", mid1, mid2)
+	}
+
+	m, ok := h.Get(mid2)
+	if !ok || m.Body != "second" || m.Mid != mid2 {
+		t.Errorf("Get('%s') = (%v, %v)", mid2, m, ok)
+	}
+	if _, ok = h.Get("unknown"); ok {
+		t.Errorf("Found unknown message")
+	}
+
+	mids := h.dialogs[DialogKey("user1", "user2")]
+	if len(mids) != 2 || mids[0] != mid1 || mids[1] != mid2 {
+		t.Errorf("Invalid dialog %v", mids)
+	}
+}
+
+// TestHistoryEditDelete checks History.Edit and History.Delete
+func TestHistoryEditDelete(t *testing.T) {
+	h := NewHistory()
+	mid := h.Add(&StoredMessage{From: "user1", To: "user2", Body: "body",
+		Attach: AttachData{"txt", "data"}})
+
+	m, ok := h.Edit(mid, "new body", 100)
+	if !ok || m.Body != "new body" || m.Edited != 100 {
+		t.Errorf("Edit('%s') = (%v, %v)", mid, m, ok)
+	}
+
+	m, ok = h.Delete(mid)
+	if !ok || !m.Deleted || m.Body != "" || m.Attach.Data != "" {
+		t.Errorf("Delete('%s') = (%v, %v)", mid, m, ok)
+	}
+
+	if _, ok = h.Edit(mid, "body", 200); ok {
+		t.Errorf("Deleted message was edited")
+	}
+	if _, ok = h.Delete(mid); ok {
+		t.Errorf("Deleted message was deleted twice")
+	}
+}
+
+// TestHistoryReact checks History.React and aggregation of reactions
+func TestHistoryReact(t *testing.T) {
+	h := NewHistory()
+	mid := h.Add(&StoredMessage{From: "user1", To: "user2", Body: "body"})
+
+	var testData = []struct {
+		uid, emoji string
+		remove     bool
+		count      int
+		changed    bool
+	}{
+		{"user1", "+1", false, 1, true},
+		{"user2", "+1", false, 2, true},
+		{"user2", "+1", false, 2, false},
+		{"user2", ":)", false, 1, true},
+		{"user1", "+1", true, 1, true},
+		{"user1", ":(", true, 0, false},
+	}
+	for _, val := range testData {
+		count, changed, ok := h.React(mid, val.uid, val.emoji, val.remove)
+		if !ok || count != val.count || changed != val.changed {
+			t.Errorf("React(%v) = (%v, %v, %v) waits (%v, %v)", val, count, changed, ok, val.count, val.changed)
+		}
+	}
+
+	list := h.Dialog("user2", "user1", "", 10)
+	if len(list) != 1 {
+		t.Fatalf("Invalid dialog %v", list)
+	}
+	reactions := list[0].Reactions
+	if len(reactions) != 2 || reactions[0] != (ReactionData{"+1", 1}) || reactions[1] != (ReactionData{":)", 1}) {
+		t.Errorf("Invalid reactions %v", reactions)
+	}
+
+	if _, _, ok := h.React("unknown", "user1", "+1", false); ok {
+		t.Errorf("Reaction to unknown message")
+	}
+}
+
+// TestHistoryDialog checks History.Dialog
+func TestHistoryDialog(t *testing.T) {
+	h := NewHistory()
+	for i := 0; i < 5; i++ {
+		h.Add(&StoredMessage{From: "user1", To: "user2"})
+		h.Add(&StoredMessage{From: "user1", To: "user3"})
+	}
+	h.Delete("9")
+
+	var testData = []struct {
+		before string
+		limit  int
+		mids   []string
+	}{
+		{"", 10, []string{"1", "3", "5", "7"}},
+		{"", 2, []string{"5", "7"}},
+		{"7", 2, []string{"3", "5"}},
+		{"1", 2, []string{}},
+	}
+	for _, val := range testData {
+		list := h.Dialog("user1", "user2", val.before, val.limit)
+		mids := make([]string, 0)
+		for _, m := range list {
+			mids = append(mids, m.Mid)
+		}
+		if fmt.Sprint(mids) != fmt.Sprint(val.mids) {
+			t.Errorf("Dialog(%v) = %v waits %v", val, mids, val.mids)
+		}
+	}
+}
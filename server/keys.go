@@ -0,0 +1,242 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Limits of public key directory
+const (
+	maxKeyDevices  = 10   // Max count of devices of one user
+	maxPrekeys     = 100  // Max count of one-time prekeys of one device
+	maxKeyLength   = 1024 // Max length of one key or signature (base64)
+	maxDeviceIDLen = 64   // Max length of device ID
+
+	// One requester gets one one-time prekey of device per window, so he can't exhaust them
+	prekeyWindow = time.Hour
+)
+
+// Envelope is a ciphertext of message for one device, server doesn't inspect it
+type Envelope struct {
+	User       string `json:"uid"`        // Owner of device: recipient or author of message
+	Device     string `json:"device"`     // Device which can decrypt ciphertext
+	Type       int    `json:"type"`       // Type of ciphertext defined by clients (e.g. prekey or normal message)
+	Ciphertext string `json:"ciphertext"` // Base64 of ciphertext
+}
+
+// SignedPrekey is a medium-term key signed by identity key
+type SignedPrekey struct {
+	Id        int    `json:"id"`
+	Key       string `json:"key"`
+	Signature string `json:"signature"`
+}
+
+// Prekey is a one-time key, it is given to one requester only
+type Prekey struct {
+	Id  int    `json:"id"`
+	Key string `json:"key"`
+}
+
+// DeviceKeys are public keys of one device
+type DeviceKeys struct {
+	IdentityKey  string
+	SignedPrekey SignedPrekey
+	Prekeys      []Prekey
+}
+
+// givenPrekey is a one-time prekey of device given to requester
+type givenPrekey struct {
+	identityKey string // Identity key of device, new one allows new prekey
+	time        time.Time
+}
+
+// KeyDirectory keeps public keys of devices of users
+type KeyDirectory struct {
+	mutex   sync.Mutex
+	devices map[string]map[string]*DeviceKeys // map key - uid; val - map key - device ID
+	given   map[string]map[string]givenPrekey // map key - uid of requester; val - map key - uid and device ID
+}
+
+// NewKeyDirectory is constructor of KeyDirectory
+func NewKeyDirectory() *KeyDirectory {
+	return &KeyDirectory{
+		devices: make(map[string]map[string]*DeviceKeys),
+		given:   make(map[string]map[string]givenPrekey),
+	}
+}
+
+// Upload stores keys of device and returns count of its one-time prekeys.
+// New identity key replaces all keys of device.
+func (d *KeyDirectory) Upload(uid string, req CltUploadKeys) (int, int, error) {
+	if req.Device == "" || req.IdentityKey == "" || req.SignedPrekey.Key == "" || req.SignedPrekey.Signature == "" {
+		return 0, ErrEmptyField, errors.New("Empty field")
+	}
+	if len(req.Device) > maxDeviceIDLen || len(req.IdentityKey) > maxKeyLength ||
+		len(req.SignedPrekey.Key) > maxKeyLength || len(req.SignedPrekey.Signature) > maxKeyLength {
+		return 0, ErrTooLarge, errors.New("Key is too large")
+	}
+	for _, p := range req.Prekeys {
+		if p.Key == "" || len(p.Key) > maxKeyLength {
+			return 0, ErrInvalidData, errors.New("Invalid prekey")
+		}
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	devices, ok := d.devices[uid]
+	if !ok {
+		devices = make(map[string]*DeviceKeys)
+		d.devices[uid] = devices
+	}
+	keys, ok := devices[req.Device]
+	if !ok && len(devices) >= maxKeyDevices {
+		return 0, ErrTooLarge, errors.New("Too many devices")
+	}
+	if !ok || keys.IdentityKey != req.IdentityKey {
+		keys = &DeviceKeys{IdentityKey: req.IdentityKey}
+		devices[req.Device] = keys
+	}
+	keys.SignedPrekey = req.SignedPrekey
+	keys.Prekeys = append(keys.Prekeys, req.Prekeys...)
+	if len(keys.Prekeys) > maxPrekeys {
+		keys.Prekeys = keys.Prekeys[len(keys.Prekeys)-maxPrekeys:]
+	}
+	return len(keys.Prekeys), ErrOK, nil
+}
+
+// Bundles returns key bundles of all devices of user for requester sorted by device ID.
+// Each bundle takes one one-time prekey of device, but requester gets one prekey of
+// device per prekeyWindow. Bundle without prekey is still valid for key agreement.
+func (d *KeyDirectory) Bundles(requester string, uid string) []KeyBundle {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	now := time.Now()
+	given, ok := d.given[requester]
+	if !ok {
+		given = make(map[string]givenPrekey)
+		d.given[requester] = given
+	}
+	for key, g := range given {
+		if now.Sub(g.time) >= prekeyWindow {
+			delete(given, key)
+		}
+	}
+
+	bundles := make([]KeyBundle, 0)
+	for device, keys := range d.devices[uid] {
+		bundle := KeyBundle{
+			Device:       device,
+			IdentityKey:  keys.IdentityKey,
+			SignedPrekey: keys.SignedPrekey,
+		}
+		key := uid + "/" + device
+		g, ok := given[key]
+		if len(keys.Prekeys) > 0 && (!ok || g.identityKey != keys.IdentityKey) {
+			prekey := keys.Prekeys[0]
+			bundle.Prekey = &prekey
+			keys.Prekeys = keys.Prekeys[1:]
+			given[key] = givenPrekey{identityKey: keys.IdentityKey, time: now}
+		}
+		bundles = append(bundles, bundle)
+	}
+	sort.Slice(bundles, func(i, j int) bool { return bundles[i].Device < bundles[j].Device })
+	return bundles
+}
+
+// RemoveDevice removes keys of device of user
+func (d *KeyDirectory) RemoveDevice(uid string, device string) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if _, ok := d.devices[uid][device]; !ok {
+		return false
+	}
+	delete(d.devices[uid], device)
+	return true
+}
+
+// Remove removes keys of all devices of user
+func (d *KeyDirectory) Remove(uid string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	delete(d.devices, uid)
+	delete(d.given, uid)
+}
+
+// checkEnvelopes checks that envelopes are addressed to participants of dialog
+func (s *MessageServer) checkEnvelopes(envelopes []Envelope, from string, to string) (int, error) {
+	size := 0
+	for _, e := range envelopes {
+		if e.User != from && e.User != to {
+			return ErrInvalidData, errors.New("Envelope for another user")
+		}
+		if e.Device == "" || e.Ciphertext == "" {
+			return ErrEmptyField, errors.New("Empty envelope")
+		}
+		size += len(e.Ciphertext)
+	}
+	if max := s.config.MaxEnvelopeSize; max > 0 && size > max {
+		return ErrTooLarge, errors.New("Message is too large")
+	}
+	return ErrOK, nil
+}
+
+// UploadKeys user publishes public keys of his device
+func (s *MessageServer) UploadKeys(c *Client, req CltUploadKeys) {
+	count, status, err := s.keys.Upload(c.cid, req)
+	if err != nil {
+		c.Error("uploadkeys", err.Error(), status, false)
+		return
+	}
+	m := SrvUploadKeys{Prekeys: count}
+	m.Status = ErrOK
+	m.Error = "OK"
+	mess, err := json.Marshal(struct {
+		Action string        `json:"action"`
+		Data   SrvUploadKeys `json:"data"`
+	}{
+		Action: "uploadkeys",
+		Data:   m,
+	})
+	if !c.CheckError(err, "Can't marhsal answer") {
+		return
+	}
+	c.Send(mess)
+}
+
+// GetKeys user gets key bundles of devices of another user
+func (s *MessageServer) GetKeys(c *Client, uid string) {
+	if !s.Exists(uid) {
+		c.Error("getkeys", "User not found", ErrUserNotFound, false)
+		return
+	}
+	m := SrvKeys{User: uid, Devices: s.keys.Bundles(c.cid, uid)}
+	m.Status = ErrOK
+	m.Error = "OK"
+	mess, err := json.Marshal(struct {
+		Action string  `json:"action"`
+		Data   SrvKeys `json:"data"`
+	}{
+		Action: "getkeys",
+		Data:   m,
+	})
+	if !c.CheckError(err, "Can't marhsal answer") {
+		return
+	}
+	c.Send(mess)
+}
+
+// RemoveDeviceKeys user removes keys of his device
+func (s *MessageServer) RemoveDeviceKeys(c *Client, device string) {
+	if !s.keys.RemoveDevice(c.cid, device) {
+		c.Error("removekeys", "Device not found", ErrUserNotFound, false)
+		return
+	}
+	c.Ok("removekeys")
+}
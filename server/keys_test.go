@@ -0,0 +1,193 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+// testKeys returns keys of device with count of one-time prekeys
+func testKeys(device string, identity string, prekeys int) CltUploadKeys {
+	req := CltUploadKeys{
+		Device:       device,
+		IdentityKey:  identity,
+		SignedPrekey: SignedPrekey{Id: 1, Key: "spk", Signature: "sig"},
+	}
+	for i := 1; i <= prekeys; i++ {
+		req.Prekeys = append(req.Prekeys, Prekey{Id: i, Key: "pk" + string(rune('0'+i))})
+	}
+	return req
+}
+
+// TestKeyDirectory checks upload of keys and consuming of one-time prekeys
+func TestKeyDirectory(t *testing.T) {
+	d := NewKeyDirectory()
+	if _, status, _ := d.Upload("user", CltUploadKeys{Device: "phone"}); status != ErrEmptyField {
+		t.Errorf("Keys without identity key are accepted: %d", status)
+	}
+	if count, _, err := d.Upload("user", testKeys("phone", "ik1", 2)); err != nil || count != 2 {
+		t.Errorf("Invalid count of prekeys %d %v", count, err)
+	}
+	d.Upload("user", testKeys("laptop", "ik2", 0))
+
+	bundles := d.Bundles("user1", "user")
+	if len(bundles) != 2 || bundles[0].Device != "laptop" || bundles[1].Device != "phone" {
+		t.Fatalf("Invalid bundles %v", bundles)
+	}
+	if bundles[0].Prekey != nil || bundles[1].Prekey == nil || bundles[1].Prekey.Id != 1 {
+		t.Errorf("Invalid prekeys of bundles %v", bundles)
+	}
+	if bundles = d.Bundles("user2", "user"); bundles[1].Prekey == nil || bundles[1].Prekey.Id != 2 {
+		t.Errorf("One-time prekey is given twice %v", bundles)
+	}
+	if bundles = d.Bundles("user3", "user"); bundles[1].Prekey != nil || bundles[1].SignedPrekey.Key != "spk" {
+		t.Errorf("Invalid bundle without prekeys %v", bundles)
+	}
+
+	// New identity key drops old prekeys
+	d.Upload("user", testKeys("phone", "ik3", 1))
+	if count, _, _ := d.Upload("user", testKeys("phone", "ik4", 1)); count != 1 {
+		t.Errorf("Prekeys of old identity key are kept: %d", count)
+	}
+	if !d.RemoveDevice("user", "laptop") || d.RemoveDevice("user", "laptop") {
+		t.Errorf("Invalid removing of device")
+	}
+	if bundles = d.Bundles("user1", "user"); len(bundles) != 1 || bundles[0].IdentityKey != "ik4" {
+		t.Errorf("Invalid bundles after removing %v", bundles)
+	}
+}
+
+// TestKeyDirectoryRequester checks that one requester can't exhaust one-time prekeys
+func TestKeyDirectoryRequester(t *testing.T) {
+	d := NewKeyDirectory()
+	d.Upload("user", testKeys("phone", "ik1", 3))
+
+	if bundles := d.Bundles("other", "user"); bundles[0].Prekey == nil || bundles[0].Prekey.Id != 1 {
+		t.Errorf("Invalid first bundle %v", bundles)
+	}
+	for i := 0; i < 5; i++ {
+		if bundles := d.Bundles("other", "user"); bundles[0].Prekey != nil || bundles[0].IdentityKey != "ik1" {
+			t.Errorf("One-time prekey is given to requester again %v", bundles)
+		}
+	}
+	if bundles := d.Bundles("another", "user"); bundles[0].Prekey == nil || bundles[0].Prekey.Id != 2 {
+		t.Errorf("Prekey is not given to another requester %v", bundles)
+	}
+
+	// Prekey of new identity key and prekey after window are given again
+	d.Upload("user", testKeys("phone", "ik2", 2))
+	if bundles := d.Bundles("other", "user"); bundles[0].Prekey == nil || bundles[0].Prekey.Id != 1 {
+		t.Errorf("Prekey of new identity key is not given %v", bundles)
+	}
+	g := d.given["other"]["user/phone"]
+	g.time = g.time.Add(-prekeyWindow)
+	d.given["other"]["user/phone"] = g
+	if bundles := d.Bundles("other", "user"); bundles[0].Prekey == nil || bundles[0].Prekey.Id != 2 {
+		t.Errorf("Prekey is not given after window %v", bundles)
+	}
+}
+
+// TestServerKeys checks key requests of clients
+func TestServerKeys(t *testing.T) {
+	gServer = newServer()
+	conn1 := newTestConn()
+	conn2 := newTestConn()
+	c1 := NewTestClient(conn1)
+	c2 := NewTestClient(conn2)
+	c1.Register("user1", "pass", "nick1")
+	c2.Register("user2", "pass", "nick2")
+
+	gServer.UploadKeys(c1, testKeys("phone", "ik", 1))
+	c1.Flush()
+	if err := conn1.CheckLastMessage(t, "{\"action\":\"uploadkeys\",\"data\":{\"prekeys\":1,\"status\":0,\"error\":\"OK\"}}"); err != nil {
+		t.Errorf("%v", err)
+	}
+
+	gServer.GetKeys(c2, "user1")
+	c2.Flush()
+	answer := "{\"action\":\"getkeys\",\"data\":{\"uid\":\"user1\",\"devices\":[{\"device\":\"phone\",\"identity_key\":\"ik\"," +
+		"\"signed_prekey\":{\"id\":1,\"key\":\"spk\",\"signature\":\"sig\"},\"prekey\":{\"id\":1,\"key\":\"pk1\"}}],\"status\":0,\"error\":\"OK\"}}"
+	if err := conn2.CheckLastMessage(t, answer); err != nil {
+		t.Errorf("%v", err)
+	}
+	gServer.GetKeys(c2, "unknown")
+	c2.Flush()
+	if err := conn2.CheckLastMessage(t, "{\"action\":\"getkeys\",\"data\":{\"status\":8,\"error\":\"User not found\"}}"); err != nil {
+		t.Errorf("%v", err)
+	}
+
+	gServer.RemoveDeviceKeys(c1, "phone")
+	c1.Flush()
+	if err := conn1.CheckLastMessage(t, "{\"action\":\"removekeys\",\"data\":{\"status\":0,\"error\":\"OK\"}}"); err != nil {
+		t.Errorf("%v", err)
+	}
+}
+
+// TestServerEncryptedMessage checks relay of envelopes
+func TestServerEncryptedMessage(t *testing.T) {
+	gServer = newServer()
+	conn1 := newTestConn()
+	conn2 := newTestConn()
+	c1 := NewTestClient(conn1)
+	c2 := NewTestClient(conn2)
+	c1.Register("user1", "pass", "nick1")
+	c2.Register("user2", "pass", "nick2")
+	gServer.Register(NewTestClient(newTestConn()), "user3", "pass", "nick3")
+	c1.AddContact("user2")
+	c1.Flush()
+
+	envelopes := []Envelope{
+		{User: "user2", Device: "phone", Type: 3, Ciphertext: "c2VjcmV0"},
+		{User: "user1", Device: "laptop", Type: 1, Ciphertext: "b3du"},
+	}
+	gServer.SendMessage(c1, "user2", "", AttachData{}, MessageLinks{}, envelopes)
+	c2.Flush()
+	last := conn2.Messages[len(conn2.Messages)-1]
+	if !strings.Contains(last, "\"body\":\"\"") ||
+		!strings.Contains(last, "\"envelopes\":[{\"uid\":\"user2\",\"device\":\"phone\",\"type\":3,\"ciphertext\":\"c2VjcmV0\"}") {
+		t.Errorf("Invalid encrypted message %s", last)
+	}
+	mid := gServer.history.Dialog("user1", "user2", "", 1)[0].Mid
+
+	gServer.EditMessage(c1, mid, "plain")
+	c1.Flush()
+	if err := conn1.CheckLastMessage(t, "{\"action\":\"editmessage\",\"data\":{\"status\":3,\"error\":\"Encrypted message can't be edited\"}}"); err != nil {
+		t.Errorf("%v", err)
+	}
+
+	gServer.SendMessage(c1, "user2", "", AttachData{}, MessageLinks{}, []Envelope{{User: "user3", Device: "d", Ciphertext: "x"}})
+	c1.Flush()
+	if err := conn1.CheckLastMessage(t, "{\"action\":\"message\",\"data\":{\"status\":3,\"error\":\"Envelope for another user\"}}"); err != nil {
+		t.Errorf("%v", err)
+	}
+
+	gServer.config.MaxEnvelopeSize = 4
+	gServer.SendMessage(c1, "user2", "", AttachData{}, MessageLinks{}, envelopes)
+	c1.Flush()
+	if err := conn1.CheckLastMessage(t, "{\"action\":\"message\",\"data\":{\"status\":13,\"error\":\"Message is too large\"}}"); err != nil {
+		t.Errorf("%v", err)
+	}
+}
+
+// TestServerOwnEnvelopeLimit checks that server uses its own limit of ciphertexts
+func TestServerOwnEnvelopeLimit(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxEnvelopeSize = 4
+	s := newServerWithConfig(config)
+	gServer = newServer()
+
+	envelopes := []Envelope{{User: "user2", Device: "phone", Ciphertext: "c2VjcmV0"}}
+	if status, _ := s.checkEnvelopes(envelopes, "user1", "user2"); status != ErrTooLarge {
+		t.Errorf("Limit of server is ignored: %d", status)
+	}
+	if status, _ := gServer.checkEnvelopes(envelopes, "user1", "user2"); status != ErrOK {
+		t.Errorf("Limit of another server is used: %d", status)
+	}
+}
+
+// TestRedactCiphertext checks that ciphertexts are not written to log
+func TestRedactCiphertext(t *testing.T) {
+	raw := RedactJSON([]byte(`{"action":"message","data":{"uid":"user","envelopes":[{"device":"phone","ciphertext":"c2VjcmV0"}]}}`))
+	if strings.Contains(raw, "c2VjcmV0") {
+		t.Errorf("Ciphertext is in log %s", raw)
+	}
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"encoding/base64"
+	"errors"
+	"io"
+)
+
+// errFrameTooLarge is returned by frameReader when request exceeds MaxFrameSize
+var errFrameTooLarge = errors.New("Frame is too large")
+
+// frameReader forbids reading after limit, so decoder can't buffer
+// more than one frame of allowed size
+type frameReader struct {
+	r     io.Reader
+	read  int64 // Count of bytes read from r
+	limit int64 // Reading is forbidden after this offset (0 - no limit)
+}
+
+// Read reads data from r up to limit
+func (f *frameReader) Read(p []byte) (int, error) {
+	if f.limit > 0 {
+		if f.read >= f.limit {
+			return 0, errFrameTooLarge
+		}
+		if int64(len(p)) > f.limit-f.read {
+			p = p[:f.limit-f.read]
+		}
+	}
+	n, err := f.r.Read(p)
+	f.read += int64(n)
+	return n, err
+}
+
+// startFrame allows to read next frame which starts at offset
+func (f *frameReader) startFrame(offset int64, maxSize int) {
+	if maxSize <= 0 {
+		f.limit = 0
+		return
+	}
+	f.limit = offset + int64(maxSize)
+}
+
+// attachSize returns size of attachment data decoded from base64
+func attachSize(attach AttachData) int {
+	return base64.StdEncoding.DecodedLen(len(attach.Data))
+}
+
+// checkMessageSize checks body and attachment of message by server limits
+func (s *MessageServer) checkMessageSize(body string, attach AttachData) bool {
+	config := s.config
+	if config.MaxBodyLength > 0 && len(body) > config.MaxBodyLength {
+		return false
+	}
+	if config.MaxAttachSize > 0 && attachSize(attach) > config.MaxAttachSize {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+// TestFrameReader checks that decoder can't read more than one frame of max size
+func TestFrameReader(t *testing.T) {
+	data := "{\"action\":\"first\"}{\"action\":\"" + strings.Repeat("a", 1000) + "\"}"
+	frames := &frameReader{r: strings.NewReader(data)}
+	dec := json.NewDecoder(frames)
+
+	var m CltRequest
+	frames.startFrame(dec.InputOffset(), 100)
+	err := dec.Decode(&m)
+	if err != nil || m.Action != "first" {
+		t.Errorf("Decode = (%v, %v) waits first frame", m, err)
+	}
+
+	frames.startFrame(dec.InputOffset(), 100)
+	err = dec.Decode(&m)
+	if err != errFrameTooLarge {
+		t.Errorf("Decode of large frame returned %v", err)
+	}
+	if frames.read > 200 {
+		t.Errorf("Read %v bytes, waits not more than two frames", frames.read)
+	}
+
+	// No limit
+	frames = &frameReader{r: strings.NewReader(data)}
+	dec = json.NewDecoder(frames)
+	for i := 0; i < 2; i++ {
+		frames.startFrame(dec.InputOffset(), 0)
+		if err = dec.Decode(&m); err != nil {
+			t.Errorf("Decode without limit returned %v", err)
+		}
+	}
+}
+
+// TestClientFrameTooLarge checks closing of connection after too large request
+func TestClientFrameTooLarge(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxFrameSize = 100
+	gServer = newServerWithConfig(config)
+
+	server, client := net.Pipe()
+	defer client.Close()
+	c := NewClient(server)
+	listenTest(t, c)
+
+	dec := json.NewDecoder(client)
+	readAction(dec)
+	go fmt.Fprintf(client, "{\"action\":\"auth\",\"data\":{\"login\":\"%s\"}}", strings.Repeat("a", 1000))
+
+	var m struct {
+		Action string           `json:"action"`
+		Data   SrvStatusMessage `json:"data"`
+	}
+	err := dec.Decode(&m)
+	if err != nil || m.Data.Status != ErrTooLarge {
+		t.Errorf("Invalid answer (%v, %v)", m, err)
+	}
+	if !waitDisconnect(c) {
+		t.Errorf("Connection was not closed")
+	}
+}
+
+// TestServerMessageLimits checks limits of message body, attachment and import
+func TestServerMessageLimits(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxBodyLength = 10
+	config.MaxAttachSize = 6
+	config.MaxImportContacts = 2
+	gServer = newServerWithConfig(config)
+
+	conn := newTestConn()
+	c1 := NewTestClient(conn)
+	c2 := NewTestClient(newTestConn())
+	gServer.Register(c1, "user1", "pass", "nick1")
+	gServer.Register(c2, "user2", "pass", "nick2")
+	c1.Auth("user1", "pass")
+	c2.Auth("user2", "pass")
+
+	ansLarge := "{\"action\":\"%s\",\"data\":{\"status\":13,\"error\":\"%s\"}}"
+	ansOk := "{\"action\":\"message\",\"data\":{\"status\":0,\"error\":\"OK\"}}"
+
+	var testData = []struct {
+		body   string
+		attach AttachData
+		ok     bool
+	}{
+		{"0123456789", AttachData{"txt", "MTIzNDU2"}, true},
+		{"01234567890", AttachData{}, false},
+		{"0", AttachData{"txt", "MTIzNDU2Nzg="}, false},
+	}
+	for _, val := range testData {
+		gServer.SendMessage(c1, c2.uid, val.body, val.attach, MessageLinks{}, nil)
+		c1.Flush()
+		ans := fmt.Sprintf(ansLarge, "message", "Message is too large")
+		if val.ok {
+			// Answer is followed by ev_message
+			ans = conn.Messages[len(conn.Messages)-1]
+			conn.Messages = conn.Messages[:len(conn.Messages)-1]
+			if !strings.HasPrefix(ans, "{\"action\":\"ev_message\"") {
+				t.Errorf("SendMessage(%v) - invalid event %s", val, ans)
+			}
+			ans = ansOk
+		}
+		err := conn.CheckLastMessage(t, ans)
+		if nil != err {
+			t.Errorf("SendMessage(%v) - %v", val, err)
+		}
+	}
+
+	gServer.EditMessage(c1, "1", "01234567890")
+	err := conn.CheckLastMessage(t, fmt.Sprintf(ansLarge, "editmessage", "Message is too large"))
+	if nil != err {
+		t.Errorf("%v", err)
+	}
+
+	c1.ImportContacts(make([]Contact, 3))
+	err = conn.CheckLastMessage(t, fmt.Sprintf(ansLarge, "import", "Too many contacts"))
+	if nil != err {
+		t.Errorf("%v", err)
+	}
+}
+
+// TestServerOwnLimits checks that server uses its own limits, not limits of gServer
+func TestServerOwnLimits(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxBodyLength = 10
+	s := newServerWithConfig(config)
+	gServer = newServer()
+
+	if s.checkMessageSize("01234567890", AttachData{}) {
+		t.Errorf("Limit of server is ignored")
+	}
+	if !gServer.checkMessageSize("01234567890", AttachData{}) {
+		t.Errorf("Limit of another server is used")
+	}
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// Log subsystems, each one can have its own level
+const (
+	LogServer  = "server"  // Start of server, auth and registration
+	LogClient  = "client"  // Requests of users and answers to them
+	LogQueue   = "queue"   // Send queues and connections
+	LogMetrics = "metrics" // Metrics endpoint
+)
+
+const (
+	logRedacted  = "***"       // Value of sensitive field in log
+	logSubsystem = "subsystem" // Key of subsystem in log record
+)
+
+// Fields which are never written to log
+var redactedFields = map[string]bool{
+	"pass":       true,
+	"body":       true,
+	"picture":    true,
+	"ciphertext": true,
+	"code":       true,
+	"payload":    true,
+	"contacts":   true,
+}
+
+// isRedacted checks if field with key inside groups is sensitive
+func isRedacted(groups []string, key string) bool {
+	if redactedFields[key] {
+		return true
+	}
+	return key == "data" && len(groups) > 0 && groups[len(groups)-1] == "attach"
+}
+
+// redactValue replaces sensitive fields of decoded JSON value
+func redactValue(groups []string, v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for key, field := range val {
+			if isRedacted(groups, key) {
+				val[key] = logRedacted
+			} else {
+				val[key] = redactValue(append(groups, key), field)
+			}
+		}
+	case []interface{}:
+		for i, item := range val {
+			val[i] = redactValue(groups, item)
+		}
+	}
+	return v
+}
+
+// RedactJSON returns JSON with sensitive fields replaced for logging
+func RedactJSON(raw []byte) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return logRedacted
+	}
+	data, err := json.Marshal(redactValue(nil, v))
+	if err != nil {
+		return logRedacted
+	}
+	return string(data)
+}
+
+// redactAttr is slog.HandlerOptions.ReplaceAttr hiding sensitive attributes
+func redactAttr(groups []string, a slog.Attr) slog.Attr {
+	if isRedacted(groups, a.Key) {
+		return slog.String(a.Key, logRedacted)
+	}
+	return a
+}
+
+// levelHandler filters records by level of subsystem
+type levelHandler struct {
+	slog.Handler
+	level slog.Level
+}
+
+// Enabled checks level of record
+func (h *levelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+// WithAttrs returns handler with attributes keeping level of subsystem
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelHandler{Handler: h.Handler.WithAttrs(attrs), level: h.level}
+}
+
+// WithGroup returns handler with group keeping level of subsystem
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{Handler: h.Handler.WithGroup(name), level: h.level}
+}
+
+// Loggers are loggers of subsystems with common output
+type Loggers struct {
+	mutex   sync.Mutex
+	handler slog.Handler
+	level   slog.Level            // Level of subsystems missing in levels
+	levels  map[string]slog.Level // map key - subsystem
+	loggers map[string]*slog.Logger
+}
+
+// NewLoggers is constructor of Loggers, they write to output of config or to stderr
+func NewLoggers(config Config) *Loggers {
+	var w io.Writer = os.Stderr
+	if config.LogOutput != nil {
+		w = config.LogOutput
+	}
+	opts := &slog.HandlerOptions{
+		Level:       slog.LevelDebug - 4, // Levels are checked by levelHandler
+		ReplaceAttr: redactAttr,
+	}
+	var handler slog.Handler
+	if config.LogFormat == "json" {
+		handler = slog.NewJSONHandler(w, opts)
+	} else {
+		handler = slog.NewTextHandler(w, opts)
+	}
+	return &Loggers{
+		handler: handler,
+		level:   config.LogLevel,
+		levels:  config.LogLevels,
+		loggers: make(map[string]*slog.Logger),
+	}
+}
+
+// Get returns logger of subsystem
+func (l *Loggers) Get(subsystem string) *slog.Logger {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	logger, ok := l.loggers[subsystem]
+	if !ok {
+		level, ok := l.levels[subsystem]
+		if !ok {
+			level = l.level
+		}
+		handler := &levelHandler{Handler: l.handler, level: level}
+		logger = slog.New(handler).With(logSubsystem, subsystem)
+		l.loggers[subsystem] = logger
+	}
+	return logger
+}
+
+// Loggers used before server is created
+var defaultLoggers = NewLoggers(DefaultConfig())
+
+// logger returns logger of subsystem of running server
+func logger(subsystem string) *slog.Logger {
+	if gServer == nil || gServer.loggers == nil {
+		return defaultLoggers.Get(subsystem)
+	}
+	return gServer.loggers.Get(subsystem)
+}
+
+// Counter of requests of all clients
+var requestSeq int64
+
+// nextRequestID returns id of new request for log records
+func nextRequestID() string {
+	return strconv.FormatInt(atomic.AddInt64(&requestSeq, 1), 10)
+}
+
+// logger returns logger of subsystem with fields of client
+func (c *Client) logger(subsystem string) *slog.Logger {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.loggerLocked(subsystem)
+}
+
+// loggerLocked returns logger of subsystem with fields of client, c.mutex must be locked
+func (c *Client) loggerLocked(subsystem string) *slog.Logger {
+	l := defaultLoggers.Get(subsystem)
+	if c.server != nil && c.server.loggers != nil {
+		l = c.server.loggers.Get(subsystem)
+	}
+	l = l.With("ip", c.ip)
+	if c.uid != "" {
+		l = l.With("uid", c.uid)
+	}
+	if c.requestID != "" {
+		l = l.With("req", c.requestID)
+	}
+	return l
+}
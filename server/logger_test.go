@@ -0,0 +1,122 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+)
+
+// TestRedactJSON checks hiding of sensitive fields of requests
+func TestRedactJSON(t *testing.T) {
+	raw := `{"login":"user","pass":"hash","message":{"body":"secret","attach":{"mime":"image/png","data":"AAAA"}},` +
+		`"list":[{"picture":"BBBB","nick":"nick"}],"data":"visible"}`
+	text := RedactJSON([]byte(raw))
+	for _, secret := range []string{"hash", "secret", "AAAA", "BBBB"} {
+		if strings.Contains(text, secret) {
+			t.Errorf("Sensitive value '%s' in '%s'", secret, text)
+		}
+	}
+	for _, visible := range []string{"user", "image/png", "nick", "visible"} {
+		if !strings.Contains(text, visible) {
+			t.Errorf("Value '%s' is lost in '%s'", visible, text)
+		}
+	}
+	raw = `{"action":"import","data":{"format":"vcard","payload":"TEL:+79990001122",` +
+		`"contacts":[{"name":"Friend","phone":"+79990003344"}]}}`
+	text = RedactJSON([]byte(raw))
+	for _, secret := range []string{"79990001122", "Friend", "79990003344"} {
+		if strings.Contains(text, secret) {
+			t.Errorf("Address book value '%s' in '%s'", secret, text)
+		}
+	}
+	if RedactJSON([]byte("{bad json pass")) != logRedacted {
+		t.Errorf("Invalid JSON is written to log")
+	}
+}
+
+// TestLoggersLevels checks levels of subsystems and redaction of attributes
+func TestLoggersLevels(t *testing.T) {
+	var buf bytes.Buffer
+	config := DefaultConfig()
+	config.LogFormat = "json"
+	config.LogOutput = &buf
+	config.LogLevels = map[string]slog.Level{LogClient: slog.LevelDebug, LogQueue: slog.LevelError}
+	loggers := NewLoggers(config)
+
+	loggers.Get(LogClient).Debug("client debug", "pass", "hash")
+	loggers.Get(LogQueue).Warn("queue warn")
+	loggers.Get(LogServer).Debug("server debug")
+	loggers.Get(LogServer).Info("server info", slog.Group("attach", "mime", "image/png", "data", "AAAA"))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Invalid log records %v", lines)
+	}
+	var record struct {
+		Msg       string `json:"msg"`
+		Subsystem string `json:"subsystem"`
+		Pass      string `json:"pass"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if record.Msg != "client debug" || record.Subsystem != LogClient || record.Pass != logRedacted {
+		t.Errorf("Invalid log record %s", lines[0])
+	}
+	if !strings.Contains(lines[1], "server info") || strings.Contains(lines[1], "AAAA") {
+		t.Errorf("Invalid log record %s", lines[1])
+	}
+}
+
+// TestClientLogger checks fields of client in log records
+func TestClientLogger(t *testing.T) {
+	var buf bytes.Buffer
+	config := DefaultConfig()
+	config.LogOutput = &buf
+	gServer = newServerWithConfig(config)
+
+	c := NewClient(newTestConn())
+	c.uid = "uid1"
+	c.requestID = "7"
+	c.logger(LogClient).Info("Request", "action", "message")
+
+	text := buf.String()
+	for _, field := range []string{"subsystem=client", "ip=", "uid=uid1", "req=7", "action=message"} {
+		if !strings.Contains(text, field) {
+			t.Errorf("Field '%s' is missing in '%s'", field, text)
+		}
+	}
+}
+
+// TestClientLoggerConcurrent checks log records of client from other goroutines
+// while client reads requests
+func TestClientLoggerConcurrent(t *testing.T) {
+	config := DefaultConfig()
+	config.LogOutput = io.Discard
+	gServer = newServerWithConfig(config)
+
+	server, client := net.Pipe()
+	defer client.Close()
+	c := NewClient(server)
+	listenTest(t, c)
+
+	dec := json.NewDecoder(client)
+	readAction(dec)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			c.logger(LogQueue).Info("Other goroutine")
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		fmt.Fprint(client, "{\"action\":\"ping\"}")
+		readAction(dec)
+	}
+	<-done
+}
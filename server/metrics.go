@@ -0,0 +1,258 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Upper bounds of request latency histogram in seconds
+var latencyBuckets = []float64{0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+// Actions of requests with own latency histogram, others are counted as "unknown"
+var metricActions = map[string]bool{
+	"ping": true, "pong": true, "register": true, "auth": true,
+	"setuserinfo": true, "userinfo": true, "contactlist": true,
+	"addcontact": true, "delcontact": true, "message": true,
+	"editmessage": true, "deletemessage": true, "react": true,
+	"history": true, "searchmessages": true, "import": true,
+	"setrole": true, "roles": true, "uploadkeys": true,
+	"getkeys": true, "removekeys": true, "sendcode": true,
+	"verify": true, "conversations": true, "setconversation": true,
+	"markread": true, "sync": true,
+}
+
+// histogram is a cumulative histogram of request latencies
+type histogram struct {
+	counts []int64 // Count of observations for each bucket
+	sum    float64
+	count  int64
+}
+
+// observe adds observation to histogram
+func (h *histogram) observe(seconds float64) {
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// Metrics are counters of server activity
+type Metrics struct {
+	mutex     sync.Mutex
+	requests  map[string]*histogram // map key - action
+	errors    map[int]int64         // map key - error code
+	auths     map[string]int64      // map key - "ok" or "fail"
+	messages  int64
+	bytesIn   int64
+	bytesOut  int64
+	startTime time.Time
+}
+
+// NewMetrics is constructor of Metrics
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests:  make(map[string]*histogram),
+		errors:    make(map[int]int64),
+		auths:     make(map[string]int64),
+		startTime: time.Now(),
+	}
+}
+
+// metrics returns metrics of server of client
+func (c *Client) metrics() *Metrics {
+	if c.server == nil {
+		return nil
+	}
+	return c.server.metrics
+}
+
+// Request registers processed request of action
+func (m *Metrics) Request(action string, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	if !metricActions[action] {
+		action = "unknown"
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	h, ok := m.requests[action]
+	if !ok {
+		h = &histogram{counts: make([]int64, len(latencyBuckets))}
+		m.requests[action] = h
+	}
+	h.observe(duration.Seconds())
+}
+
+// Error registers error answer with code
+func (m *Metrics) Error(code int) {
+	if m == nil {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.errors[code]++
+}
+
+// Auth registers result of auth
+func (m *Metrics) Auth(ok bool) {
+	if m == nil {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if ok {
+		m.auths["ok"]++
+	} else {
+		m.auths["fail"]++
+	}
+}
+
+// Message registers sent message
+func (m *Metrics) Message() {
+	if m == nil {
+		return
+	}
+	atomic.AddInt64(&m.messages, 1)
+}
+
+// BytesIn registers bytes read from users
+func (m *Metrics) BytesIn(n int) {
+	if m == nil {
+		return
+	}
+	atomic.AddInt64(&m.bytesIn, int64(n))
+}
+
+// BytesOut registers bytes written to users
+func (m *Metrics) BytesOut(n int) {
+	if m == nil {
+		return
+	}
+	atomic.AddInt64(&m.bytesOut, int64(n))
+}
+
+// metricsWriter writes metrics in prometheus text format
+type metricsWriter struct {
+	w   io.Writer
+	err error
+}
+
+// header writes help and type of metric
+func (mw *metricsWriter) header(name string, kind string, help string) {
+	mw.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// printf writes formatted line
+func (mw *metricsWriter) printf(format string, args ...interface{}) {
+	if mw.err == nil {
+		_, mw.err = fmt.Fprintf(mw.w, format, args...)
+	}
+}
+
+// value writes metric with one value
+func (mw *metricsWriter) value(name string, kind string, help string, value interface{}) {
+	mw.header(name, kind, help)
+	mw.printf("%s %v\n", name, value)
+}
+
+// formatFloat formats value like prometheus does
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// WriteMetrics writes metrics of server in prometheus text format
+func (s *MessageServer) WriteMetrics(w io.Writer) error {
+	m := s.metrics
+	mw := &metricsWriter{w: w}
+
+	connected, offline := 0, 0
+	for _, c := range s.clientList() {
+		c.mutex.Lock()
+		if c.connected {
+			connected++
+		} else {
+			offline += len(c.offlineMessages)
+		}
+		c.mutex.Unlock()
+	}
+	queues := s.QueueStats()
+
+	mw.value("tm_connected_clients", "gauge", "Count of online users.", connected)
+	mw.value("tm_registered_users", "gauge", "Count of registered users.", s.CountUsers())
+	mw.value("tm_offline_messages", "gauge", "Messages waiting for offline users.", offline)
+	mw.value("tm_send_queue_messages", "gauge", "Messages in send queues of online users.", queues.Queued+queues.Spilled)
+	mw.value("tm_send_queue_max_depth", "gauge", "Max count of messages waiting for one online user.", queues.MaxDepth)
+	mw.value("tm_send_queue_dropped_total", "counter", "Messages dropped from full send queues.", queues.Dropped)
+	mw.value("tm_slow_clients_disconnected_total", "counter", "Users disconnected because of full send queue.", queues.Disconnected)
+	mw.value("tm_messages_total", "counter", "Count of sent messages.", atomic.LoadInt64(&m.messages))
+	mw.value("tm_received_bytes_total", "counter", "Bytes read from users.", atomic.LoadInt64(&m.bytesIn))
+	mw.value("tm_sent_bytes_total", "counter", "Bytes written to users.", atomic.LoadInt64(&m.bytesOut))
+	mw.value("tm_goroutines", "gauge", "Count of goroutines.", runtime.NumGoroutine())
+	mw.value("tm_uptime_seconds", "gauge", "Time since server start.", formatFloat(time.Since(m.startTime).Seconds()))
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	mw.header("tm_auth_total", "counter", "Count of auth attempts by result.")
+	for _, result := range []string{"fail", "ok"} {
+		mw.printf("tm_auth_total{result=%q} %d\n", result, m.auths[result])
+	}
+
+	mw.header("tm_errors_total", "counter", "Count of error answers by code.")
+	codes := make([]int, 0, len(m.errors))
+	for code := range m.errors {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		mw.printf("tm_errors_total{code=\"%d\"} %d\n", code, m.errors[code])
+	}
+
+	mw.header("tm_request_duration_seconds", "histogram", "Latency of requests by action.")
+	actions := make([]string, 0, len(m.requests))
+	for action := range m.requests {
+		actions = append(actions, action)
+	}
+	sort.Strings(actions)
+	for _, action := range actions {
+		h := m.requests[action]
+		for i, bound := range latencyBuckets {
+			mw.printf("tm_request_duration_seconds_bucket{action=%q,le=\"%s\"} %d\n", action, formatFloat(bound), h.counts[i])
+		}
+		mw.printf("tm_request_duration_seconds_bucket{action=%q,le=\"+Inf\"} %d\n", action, h.count)
+		mw.printf("tm_request_duration_seconds_sum{action=%q} %s\n", action, formatFloat(h.sum))
+		mw.printf("tm_request_duration_seconds_count{action=%q} %d\n", action, h.count)
+	}
+	return mw.err
+}
+
+// ServeHTTP is a handler of metrics endpoint
+func (s *MessageServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	err := s.WriteMetrics(w)
+	CheckError(err, "Can't write metrics", false)
+}
+
+// StartMetrics starts http server with metrics on addr
+func (s *MessageServer) StartMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", s)
+	logger(LogMetrics).Info("Metrics start", "addr", addr)
+	err := http.ListenAndServe(addr, mux)
+	CheckError(err, "Can't start metrics", false)
+}
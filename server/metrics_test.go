@@ -0,0 +1,102 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// checkMetrics checks that metrics text contains all lines
+func checkMetrics(t *testing.T, text string, lines ...string) {
+	for _, line := range lines {
+		if !strings.Contains(text, line+"\n") {
+			t.Errorf("Metrics have no line '%s'", line)
+		}
+	}
+}
+
+// TestMetricsRequest checks latency histogram of requests
+func TestMetricsRequest(t *testing.T) {
+	gServer = newServer()
+	m := gServer.metrics
+	m.Request("message", 2*time.Millisecond)
+	m.Request("message", 2*time.Second)
+	m.Request("bad action", time.Millisecond)
+	m.Error(ErrNeedAuth)
+	m.Error(ErrNeedAuth)
+
+	var buf bytes.Buffer
+	if err := gServer.WriteMetrics(&buf); err != nil {
+		t.Fatalf("%v", err)
+	}
+	checkMetrics(t, buf.String(),
+		"tm_request_duration_seconds_bucket{action=\"message\",le=\"0.001\"} 0",
+		"tm_request_duration_seconds_bucket{action=\"message\",le=\"0.005\"} 1",
+		"tm_request_duration_seconds_bucket{action=\"message\",le=\"5\"} 2",
+		"tm_request_duration_seconds_bucket{action=\"message\",le=\"+Inf\"} 2",
+		"tm_request_duration_seconds_sum{action=\"message\"} 2.002",
+		"tm_request_duration_seconds_count{action=\"message\"} 2",
+		"tm_request_duration_seconds_count{action=\"unknown\"} 1",
+		"tm_errors_total{code=\"6\"} 2",
+	)
+}
+
+// TestMetricsHandler checks metrics of connected client
+func TestMetricsHandler(t *testing.T) {
+	newHeartbeatTestServer(time.Second, time.Second, 0)
+
+	server, client := net.Pipe()
+	defer client.Close()
+	c := NewClient(server)
+	listenTest(t, c)
+
+	dec := json.NewDecoder(client)
+	readAction(dec)
+	request := "{\"action\":\"register\",\"data\":{\"login\":\"login\",\"pass\":\"pass\",\"nick\":\"nick\"}}" +
+		"{\"action\":\"ping\"}"
+	fmt.Fprint(client, request)
+	for i := 0; i < 3; i++ {
+		readAction(dec)
+	}
+
+	// Failed auth closes connection
+	server2, client2 := net.Pipe()
+	defer client2.Close()
+	listenTest(t, NewClient(server2))
+	dec2 := json.NewDecoder(client2)
+	readAction(dec2)
+	auth := "{\"action\":\"auth\",\"data\":{\"login\":\"login\",\"pass\":\"bad\"}}"
+	fmt.Fprint(client2, auth)
+	readAction(dec2)
+	readAction(dec2)
+
+	// Request is measured after the connection is closed
+	var text string
+	for i := 0; i < 100; i++ {
+		rec := httptest.NewRecorder()
+		gServer.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		text = rec.Body.String()
+		if strings.Contains(text, "tm_request_duration_seconds_count{action=\"auth\"}") {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	checkMetrics(t, text,
+		"tm_registered_users 1",
+		"tm_auth_total{result=\"fail\"} 1",
+		"tm_auth_total{result=\"ok\"} 1",
+		"tm_connected_clients 1",
+		"tm_errors_total{code=\"2\"} 1",
+		"tm_request_duration_seconds_count{action=\"register\"} 1",
+		"tm_request_duration_seconds_count{action=\"auth\"} 1",
+		fmt.Sprintf("tm_received_bytes_total %d", len(request)+len(auth)),
+	)
+	if !strings.Contains(text, "# TYPE tm_request_duration_seconds histogram\n") {
+		t.Errorf("Metrics have no type of histogram")
+	}
+}
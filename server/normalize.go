@@ -0,0 +1,158 @@
+package server
+
+import (
+	"sort"
+	"strings"
+)
+
+// phoneRegion is a numbering plan of country for phones in national format
+type phoneRegion struct {
+	Code   string // Country calling code
+	Trunk  string // Trunk prefix of national format, e.g. "8" in 8 912 345-67-89
+	Length int    // Length of national number without trunk prefix
+}
+
+// phoneRegions are regions known for Config.PhoneRegion
+var phoneRegions = map[string]phoneRegion{
+	"RU": {Code: "7", Trunk: "8", Length: 10},
+	"KZ": {Code: "7", Trunk: "8", Length: 10},
+	"BY": {Code: "375", Trunk: "80", Length: 9},
+	"UA": {Code: "380", Trunk: "0", Length: 9},
+	"US": {Code: "1", Trunk: "1", Length: 10},
+	"CA": {Code: "1", Trunk: "1", Length: 10},
+	"GB": {Code: "44", Trunk: "0", Length: 10},
+	"DE": {Code: "49", Trunk: "0", Length: 0},
+	"FR": {Code: "33", Trunk: "0", Length: 9},
+}
+
+// Lengths of international number without "+"
+const (
+	minPhoneDigits = 3
+	maxPhoneDigits = 15 // E.164
+)
+
+// NormalizePhone returns phone in E.164 format (+79123456789). Phone without
+// country code is read in national format of region ("" - only international format).
+// Result is false for text which is not a phone number.
+func NormalizePhone(phone string, region string) (string, bool) {
+	phone = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(phone), "tel:"))
+	plus := strings.HasPrefix(phone, "+")
+	digits := make([]byte, 0, len(phone))
+	for i, r := range phone {
+		switch {
+		case r >= '0' && r <= '9':
+			digits = append(digits, byte(r))
+		case r == '+' && i == 0:
+		case r == ' ' || r == '-' || r == '(' || r == ')' || r == '.' || r == '/':
+		default:
+			return "", false
+		}
+	}
+	number := string(digits)
+
+	switch {
+	case plus:
+	case strings.HasPrefix(number, "00"):
+		// International call prefix
+		number = number[2:]
+	default:
+		plan, ok := phoneRegions[strings.ToUpper(region)]
+		if !ok {
+			return "", false
+		}
+		switch {
+		case plan.Length > 0 && len(number) == plan.Length:
+		case plan.Length > 0 && strings.HasPrefix(number, plan.Code) && len(number) == len(plan.Code)+plan.Length:
+			// Country code without "+"
+			number = number[len(plan.Code):]
+		case strings.HasPrefix(number, plan.Trunk) && (plan.Length == 0 || len(number) == len(plan.Trunk)+plan.Length):
+			number = number[len(plan.Trunk):]
+		default:
+			return "", false
+		}
+		number = plan.Code + number
+	}
+
+	if len(number) < minPhoneDigits || len(number) > maxPhoneDigits || number[0] == '0' {
+		return "", false
+	}
+	return "+" + number, true
+}
+
+// NormalizeEmail returns canonical email: without spaces and "mailto:", in lower case.
+// Result is false for text which is not an email.
+func NormalizeEmail(email string) (string, bool) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	email = strings.TrimPrefix(email, "mailto:")
+	at := strings.LastIndex(email, "@")
+	if at <= 0 || at == len(email)-1 || strings.ContainsAny(email, " \t\r\n") {
+		return "", false
+	}
+	return email, true
+}
+
+// normalizePhone normalizes phone with default region of server
+func (s *MessageServer) normalizePhone(phone string) (string, bool) {
+	return NormalizePhone(phone, s.config.PhoneRegion)
+}
+
+// Reindex normalizes emails and phones of all accounts and rebuilds index of verified ones.
+// It is needed once for entries stored before normalization or after change of
+// Config.PhoneRegion. If several users have the same email or phone, it stays with
+// the user who comes first by login. Returns count of changed and conflicting entries.
+func (s *MessageServer) Reindex() (int, int) {
+	s.dirMutex.Lock()
+	uids := make([]string, 0, len(s.accounts))
+	for uid := range s.accounts {
+		uids = append(uids, uid)
+	}
+	sort.Strings(uids)
+
+	changed, conflicts := 0, 0
+	emails := make(map[string]string)
+	phones := make(map[string]string)
+	index := func(verified *bool, address *string, normalize func(string) (string, bool), idx map[string]string, uid string) {
+		if *address == "" {
+			return
+		}
+		normalized, ok := normalize(*address)
+		if !ok {
+			return
+		}
+		if normalized != *address {
+			*address = normalized
+			changed++
+		}
+		if !*verified {
+			return
+		}
+		if _, exist := idx[normalized]; exist {
+			// Address stays with first user, others have to verify another one
+			*verified = false
+			conflicts++
+			return
+		}
+		idx[normalized] = uid
+	}
+	for _, uid := range uids {
+		a := s.accounts[uid]
+		index(&a.EmailVerified, &a.Email, NormalizeEmail, emails, uid)
+		index(&a.PhoneVerified, &a.Phone, s.normalizePhone, phones, uid)
+	}
+	s.emails = emails
+	s.phones = phones
+	s.dirMutex.Unlock()
+
+	// Sessions get changed profiles under their locks, they are used by own goroutines
+	for _, c := range s.clientList() {
+		c.mutex.Lock()
+		if a, ok := s.Account(c.cid); ok {
+			c.email, c.phone = a.Email, a.Phone
+			c.emailVerified, c.phoneVerified = a.EmailVerified, a.PhoneVerified
+		}
+		c.mutex.Unlock()
+	}
+
+	logger(LogServer).Info("Contacts are reindexed", "changed", changed, "conflicts", conflicts)
+	return changed, conflicts
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+// TestNormalizePhone checks international and national formats of phones
+func TestNormalizePhone(t *testing.T) {
+	tests := []struct {
+		phone, region, result string
+		ok                    bool
+	}{
+		{"+7 (912) 345-67-89", "RU", "+79123456789", true},
+		{"89123456789", "RU", "+79123456789", true},
+		{"79123456789", "RU", "+79123456789", true},
+		{"9123456789", "RU", "+79123456789", true},
+		{"tel:+7-912-345-67-89", "", "+79123456789", true},
+		{"0079123456789", "", "+79123456789", true},
+		{"8 029 123-45-67", "BY", "+375291234567", true},
+		{"(212) 555-0100", "us", "+12125550100", true},
+		{"89123456789", "", "", false},
+		{"8912345", "RU", "", false},
+		{"+7999123123123", "RU", "+7999123123123", true},
+		{"+1234567890123456", "", "", false},
+		{"phone", "RU", "", false},
+		{"", "RU", "", false},
+	}
+	for _, test := range tests {
+		result, ok := NormalizePhone(test.phone, test.region)
+		if result != test.result || ok != test.ok {
+			t.Errorf("NormalizePhone(%q, %q) = %q, %v instead of %q, %v", test.phone, test.region, result, ok, test.result, test.ok)
+		}
+	}
+}
+
+// TestNormalizeEmail checks case, spaces and invalid emails
+func TestNormalizeEmail(t *testing.T) {
+	tests := []struct {
+		email, result string
+		ok            bool
+	}{
+		{" Ivan.Petrov@Mail.RU ", "ivan.petrov@mail.ru", true},
+		{"mailto:ivan@mail.ru", "ivan@mail.ru", true},
+		{"ivan", "", false},
+		{"@mail.ru", "", false},
+		{"ivan@", "", false},
+		{"iv an@mail.ru", "", false},
+	}
+	for _, test := range tests {
+		result, ok := NormalizeEmail(test.email)
+		if result != test.result || ok != test.ok {
+			t.Errorf("NormalizeEmail(%q) = %q, %v instead of %q, %v", test.email, result, ok, test.result, test.ok)
+		}
+	}
+}
+
+// TestServerFindNormalized checks search by phones and emails in other formats
+func TestServerFindNormalized(t *testing.T) {
+	gServer = newServer()
+	c := NewTestClient(newTestConn())
+	gServer.Register(c, "user", "pass", "user")
+	c.Auth("user", "pass")
+	c.SetUserInfo("", "Ivan@Mail.ru", "8 (912) 345-67-89", "")
+	verifyContacts(c)
+
+	if c.email != "ivan@mail.ru" || c.phone != "+79123456789" {
+		t.Errorf("Data is not normalized: %s %s", c.email, c.phone)
+	}
+	for _, query := range [][2]string{{"IVAN@mail.ru", ""}, {"", "+7 912 345 67 89"}, {"", "9123456789"}, {"unknown", "89123456789"}} {
+		if user, ok := gServer.FindUser(query[0], query[1]); !ok || user != c {
+			t.Errorf("User is not found by %v", query)
+		}
+	}
+	if _, ok := gServer.FindUser("", "345-67-89"); ok {
+		t.Errorf("User is found by part of phone")
+	}
+
+	c.SetUserInfo("", "", "+7 900 000-00-00", "")
+	if _, ok := gServer.FindUser("", "89123456789"); ok {
+		t.Errorf("User is found by old phone")
+	}
+}
+
+// TestServerReindex checks normalization of stored entries
+func TestServerReindex(t *testing.T) {
+	gServer = newServer()
+	users := make([]*Client, 3)
+	for i, login := range []string{"user1", "user2", "user3"} {
+		users[i] = NewTestClient(newTestConn())
+		gServer.Register(users[i], login, "pass", login)
+		users[i].Auth(login, "pass")
+	}
+	// Entries stored before normalization
+	accounts := []Account{
+		{Email: "User1@Mail.ru", Phone: "8 912 345-67-89", EmailVerified: true, PhoneVerified: true},
+		{Email: "user2@mail.ru", Phone: "+79123456789", EmailVerified: true, PhoneVerified: true},
+		{Phone: "not a phone"},
+	}
+	for i, c := range users {
+		c.email, c.phone = accounts[i].Email, accounts[i].Phone
+		c.emailVerified, c.phoneVerified = accounts[i].EmailVerified, accounts[i].PhoneVerified
+		gServer.saveProfile(c)
+	}
+	gServer.emails = map[string]string{"User1@Mail.ru": "user1", "user2@mail.ru": "user2"}
+	gServer.phones = map[string]string{"8 912 345-67-89": "user1", "+79123456789": "user2"}
+
+	h := NewAdminHandler(gServer, "secret")
+	w := adminRequest(h, "secret", "POST", "/admin/reindex", "")
+	if !strings.Contains(w.Body.String(), `{"changed":2,"conflicts":1,"status":0,"error":"OK"}`) {
+		t.Errorf("Invalid answer %s", w.Body.String())
+	}
+	if user, ok := gServer.FindUser("user1@mail.ru", ""); !ok || user != users[0] {
+		t.Errorf("User is not found by email")
+	}
+	if user, ok := gServer.FindUser("", "+79123456789"); !ok || user != users[0] {
+		t.Errorf("Phone is not kept by first user")
+	}
+	if users[2].phone != "not a phone" || len(gServer.phones) != 1 {
+		t.Errorf("Invalid phone is changed %v", gServer.phones)
+	}
+	if users[0].email != "user1@mail.ru" || users[1].phoneVerified {
+		t.Errorf("Session is not updated %s %v", users[0].email, users[1].phoneVerified)
+	}
+}
+
+// TestServerReindexOnline checks reindex while user changes his profile, test is useful with -race
+func TestServerReindexOnline(t *testing.T) {
+	gServer = newServer()
+	gServer.Register(NewTestClient(newTestConn()), "user", "pass", "user")
+	h := NewAdminHandler(gServer, "secret")
+
+	server, client := net.Pipe()
+	defer client.Close()
+	c := NewClient(server)
+	listenTest(t, c)
+	dec := json.NewDecoder(client)
+	readAction(dec)
+	fmt.Fprint(client, `{"action":"auth","data":{"login":"user","pass":"pass"}}`)
+	readAction(dec)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			adminRequest(h, "secret", "POST", "/admin/reindex", "")
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		fmt.Fprintf(client, `{"action":"setuserinfo","data":{"user_status":"","email":"User%d@Mail.ru"}}`, i)
+		if action := readAction(dec); action != "setuserinfo" {
+			t.Fatalf("Invalid answer to setuserinfo '%s'", action)
+		}
+	}
+	<-done
+
+	adminRequest(h, "secret", "POST", "/admin/reindex", "")
+	if email, verified := c.address(VerifyEmail); email != "user9@mail.ru" || verified {
+		t.Errorf("Invalid email of session %s %v", email, verified)
+	}
+}
@@ -0,0 +1,222 @@
+package server
+
+import (
+	"sync/atomic"
+)
+
+// QueuePolicy is a behaviour of Client when its send queue is full
+type QueuePolicy int
+
+// Policies of full send queue
+const (
+	QueueDropOldest QueuePolicy = iota // The oldest queued message is dropped
+	QueueDisconnect                    // Slow client is disconnected, queued messages are kept offline
+	QueueSpill                         // Messages are kept offline until client reads the queue
+)
+
+// outMessage is an item of Client send queue
+type outMessage struct {
+	data  []byte        // Data to write (nil - nothing to write)
+	close bool          // Close connection after writing
+	done  chan struct{} // Closed when all previous messages are written
+}
+
+// QueueStats is a state of send queues of all clients
+type QueueStats struct {
+	Queued       int   // Messages in send queues
+	MaxDepth     int   // Max count of messages waiting for one client
+	Spilled      int   // Messages waiting in offline storage of online clients
+	Dropped      int64 // Messages dropped by QueueDropOldest
+	Disconnected int64 // Clients disconnected by QueueDisconnect
+}
+
+// queueCounters are totals of full queue events
+type queueCounters struct {
+	dropped      int64
+	disconnected int64
+}
+
+// currentConfig returns settings of running server
+func currentConfig() Config {
+	if gServer == nil {
+		return DefaultConfig()
+	}
+	return gServer.config
+}
+
+// config returns settings of server of client. Goroutines of client don't read
+// gServer, it may be replaced, e.g. by the next test.
+func (c *Client) config() Config {
+	if c.server == nil {
+		return DefaultConfig()
+	}
+	return c.server.config
+}
+
+// Send puts data to send queue without blocking.
+// When the queue is full the QueuePolicy of server is applied.
+func (c *Client) Send(data []byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// Keep order while spilled messages are not written
+	if !c.connected || len(c.offlineMessages) > 0 {
+		c.offlineMessages = append(c.offlineMessages, data)
+		return
+	}
+
+	select {
+	case c.outgoing <- outMessage{data: data}:
+		return
+	default:
+	}
+
+	switch c.config().QueuePolicy {
+	case QueueDropOldest:
+		select {
+		case m := <-c.outgoing:
+			c.drop(m)
+		default:
+		}
+		select {
+		case c.outgoing <- outMessage{data: data}:
+		default:
+			c.drop(outMessage{data: data})
+		}
+
+	case QueueDisconnect:
+		c.loggerLocked(LogQueue).Warn("Disconnect slow client")
+		c.disconnect()
+		c.conn.Close()
+		if c.server != nil {
+			atomic.AddInt64(&c.server.queues.disconnected, 1)
+		}
+		c.offlineMessages = append(c.offlineMessages, data)
+
+	case QueueSpill:
+		// Queue is not spilled: write goroutine waiting for the emptied queue
+		// would never write offline messages
+		c.offlineMessages = append(c.offlineMessages, data)
+	}
+}
+
+// sendFirst sends data before offline messages, e.g. answer to auth goes before messages
+// of previous connection of user. It never blocks: data which doesn't fit in send queue
+// is the first offline message.
+func (c *Client) sendFirst(data []byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.connected && len(c.offlineMessages) == 0 {
+		select {
+		case c.outgoing <- outMessage{data: data}:
+			return
+		default:
+		}
+	}
+	c.offlineMessages = append([][]byte{data}, c.offlineMessages...)
+	if !c.connected {
+		return
+	}
+	// Empty item wakes write goroutine, it writes offline messages when queue is empty
+	select {
+	case c.outgoing <- outMessage{}:
+	default:
+	}
+}
+
+// drop finishes dropped item of queue
+func (c *Client) drop(m outMessage) {
+	if m.data != nil && c.server != nil {
+		atomic.AddInt64(&c.server.queues.dropped, 1)
+	}
+	if m.done != nil {
+		close(m.done)
+	}
+}
+
+// spillQueue moves queued messages to offline storage, c.mutex must be locked
+func (c *Client) spillQueue() {
+	queued := make([][]byte, 0, len(c.outgoing))
+	for {
+		select {
+		case m := <-c.outgoing:
+			if m.data != nil {
+				queued = append(queued, m.data)
+			}
+			if m.done != nil {
+				close(m.done)
+			}
+		default:
+			// Queued messages are older than spilled ones
+			if len(queued) > 0 {
+				c.offlineMessages = append(queued, c.offlineMessages...)
+			}
+			return
+		}
+	}
+}
+
+// Flush waits until all queued messages are written
+func (c *Client) Flush() {
+	c.waitQueue(outMessage{done: make(chan struct{})})
+}
+
+// Close closes connection after all queued messages are written
+func (c *Client) Close() {
+	c.waitQueue(outMessage{close: true, done: make(chan struct{})})
+}
+
+// waitQueue puts item to send queue and waits for it. After disconnect
+// there is nothing to wait: queue is moved to offline storage.
+func (c *Client) waitQueue(m outMessage) {
+	select {
+	case <-c.stopped:
+		return
+	default:
+	}
+	select {
+	case c.outgoing <- m:
+	case <-c.stopped:
+		return
+	}
+	select {
+	case <-m.done:
+	case <-c.stopped:
+	}
+}
+
+// QueueDepth returns count of messages waiting to be written
+func (c *Client) QueueDepth() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	depth := len(c.outgoing)
+	if c.connected {
+		depth += len(c.offlineMessages)
+	}
+	return depth
+}
+
+// QueueStats returns state of send queues of all clients
+func (s *MessageServer) QueueStats() QueueStats {
+	stats := QueueStats{
+		Dropped:      atomic.LoadInt64(&s.queues.dropped),
+		Disconnected: atomic.LoadInt64(&s.queues.disconnected),
+	}
+	for _, c := range s.clientList() {
+		c.mutex.Lock()
+		queued, spilled := len(c.outgoing), 0
+		if c.connected {
+			spilled = len(c.offlineMessages)
+		}
+		c.mutex.Unlock()
+
+		stats.Queued += queued
+		stats.Spilled += spilled
+		if queued+spilled > stats.MaxDepth {
+			stats.MaxDepth = queued + spilled
+		}
+	}
+	return stats
+}
@@ -0,0 +1,244 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newQueueTestServer creates server with small send queues
+func newQueueTestServer(policy QueuePolicy) {
+	config := DefaultConfig()
+	config.SendQueueSize = 2
+	config.QueuePolicy = policy
+	gServer = newServerWithConfig(config)
+}
+
+// TestClientSendDropOldest checks Client.Send with QueueDropOldest
+func TestClientSendDropOldest(t *testing.T) {
+	newQueueTestServer(QueueDropOldest)
+
+	conn := newTestConn()
+	c := NewClient(conn)
+	gServer.Clients["user"] = c
+	for i := 0; i < 5; i++ {
+		c.Send([]byte(fmt.Sprint(i)))
+	}
+
+	stats := gServer.QueueStats()
+	if stats.Dropped != 3 || stats.Queued != 2 || stats.MaxDepth != 2 {
+		t.Errorf("Invalid queue stats %+v", stats)
+	}
+
+	go c.write()
+	c.Flush()
+	if fmt.Sprint(conn.Messages) != "[3 4]" {
+		t.Errorf("Invalid messages %v waits [3 4]", conn.Messages)
+	}
+}
+
+// TestClientSendDisconnect checks Client.Send with QueueDisconnect
+func TestClientSendDisconnect(t *testing.T) {
+	newQueueTestServer(QueueDisconnect)
+
+	conn := newTestConn()
+	c := NewClient(conn)
+	for i := 0; i < 3; i++ {
+		c.Send([]byte(fmt.Sprint(i)))
+	}
+
+	if !conn.Closed || c.isConnected() {
+		t.Errorf("Slow client was not disconnected")
+	}
+	if gServer.QueueStats().Disconnected != 1 {
+		t.Errorf("Disconnect was not counted")
+	}
+
+	// Messages are kept offline in order of sending
+	go c.write()
+	c.Send([]byte("3"))
+	c.Flush()
+	if len(conn.Messages) != 0 {
+		t.Errorf("Messages were written to closed connection %v", conn.Messages)
+	}
+	if offline := c.takeOfflineMessages(); fmt.Sprintf("%s", offline) != "[0 1 2 3]" {
+		t.Errorf("Invalid offline messages %s", offline)
+	}
+}
+
+// TestClientSendSpill checks Client.Send with QueueSpill
+func TestClientSendSpill(t *testing.T) {
+	newQueueTestServer(QueueSpill)
+
+	conn := newTestConn()
+	c := NewClient(conn)
+	gServer.Clients["user"] = c
+	for i := 0; i < 5; i++ {
+		c.Send([]byte(fmt.Sprint(i)))
+	}
+
+	if conn.Closed || !c.isConnected() {
+		t.Errorf("Client was disconnected")
+	}
+	stats := gServer.QueueStats()
+	if stats.Spilled != 3 || stats.Queued != 2 || c.QueueDepth() != 5 {
+		t.Errorf("Invalid queue stats %+v (depth %v)", stats, c.QueueDepth())
+	}
+
+	go c.write()
+	c.Send([]byte("5"))
+	c.Flush()
+	if fmt.Sprint(conn.Messages) != "[0 1 2 3 4 5]" {
+		t.Errorf("Invalid messages %v", conn.Messages)
+	}
+	if c.QueueDepth() != 0 {
+		t.Errorf("Queue is not empty after flush")
+	}
+}
+
+// TestClientSendSpillWriting checks that messages sent while queue is
+// written are not stuck in offline storage
+func TestClientSendSpillWriting(t *testing.T) {
+	newQueueTestServer(QueueSpill)
+
+	server, user := net.Pipe()
+	defer user.Close()
+	c := NewTestClient(server)
+	received := make(chan int)
+	go func() {
+		r := bufio.NewReader(user)
+		count := 0
+		for {
+			if _, err := r.ReadString('\n'); err != nil {
+				return
+			}
+			count++
+			received <- count
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		c.Send([]byte(fmt.Sprintln(i)))
+	}
+	for count := 0; count < 100; {
+		select {
+		case count = <-received:
+		case <-time.After(time.Second):
+			t.Fatalf("Only %d of 100 messages are written", count)
+		}
+	}
+}
+
+// TestClientClose checks that Client.Error writes answer before closing
+func TestClientClose(t *testing.T) {
+	newQueueTestServer(QueueSpill)
+
+	conn := newTestConn()
+	c := NewTestClient(conn)
+	c.Send([]byte("first"))
+	c.Error("test", "Test error", ErrInvalidData, true)
+
+	if !conn.Closed || c.isConnected() {
+		t.Errorf("Connection was not closed")
+	}
+	ansErr := "{\"action\":\"test\",\"data\":{\"status\":3,\"error\":\"Test error\"}}"
+	if fmt.Sprint(conn.Messages) != fmt.Sprintf("[first %s]", ansErr) {
+		t.Errorf("Invalid messages %v", conn.Messages)
+	}
+}
+
+// TestServerAuthTakeover checks that new connection of user gets messages which old
+// connection didn't write, and write goroutine of old connection stops
+func TestServerAuthTakeover(t *testing.T) {
+	newQueueTestServer(QueueSpill)
+	gServer.Register(NewTestClient(newTestConn()), "user", "pass", "user")
+
+	server, user := net.Pipe()
+	defer user.Close()
+	old := NewTestClient(server)
+	old.Auth("user", "pass")
+	// Old connection reads auth answer only, so "1" is being written
+	if _, err := bufio.NewReader(user).ReadString('}'); err != nil {
+		t.Fatalf("Can't read auth answer: %v", err)
+	}
+	for i := 1; i <= 4; i++ {
+		old.Send([]byte(fmt.Sprint(i)))
+	}
+
+	conn := newTestConn()
+	c := NewTestClient(conn)
+	c.Auth("user", "pass")
+	c.Flush()
+	select {
+	case <-old.stopped:
+	case <-time.After(time.Second):
+		t.Fatalf("Write goroutine of old connection was not stopped")
+	}
+	if len(conn.Messages) != 5 || fmt.Sprint(conn.Messages[1:]) != "[1 2 3 4]" {
+		t.Errorf("Invalid messages %v", conn.Messages)
+	}
+}
+
+// TestClientAuthQueueFull checks that answer to auth doesn't wait for full send queue
+func TestClientAuthQueueFull(t *testing.T) {
+	newQueueTestServer(QueueSpill)
+	gServer.Register(NewTestClient(newTestConn()), "user", "pass", "user")
+
+	conn := newTestConn()
+	c := NewClient(conn)
+	c.Send([]byte("1"))
+	c.Send([]byte("2"))
+	done := make(chan struct{})
+	go func() {
+		c.Auth("user", "pass")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Auth is blocked by full send queue")
+	}
+
+	go c.write()
+	c.Flush()
+	if len(conn.Messages) != 3 || fmt.Sprint(conn.Messages[:2]) != "[1 2]" || !strings.Contains(conn.Messages[2], `"action":"auth"`) {
+		t.Errorf("Invalid messages %v", conn.Messages)
+	}
+}
+
+// TestClientAuthOfflineMessages checks that messages of previous session are written
+// after answer to auth without other requests
+func TestClientAuthOfflineMessages(t *testing.T) {
+	newQueueTestServer(QueueSpill)
+	c1 := NewTestClient(newTestConn())
+	old := NewTestClient(newTestConn())
+	gServer.Register(c1, "user1", "pass", "nick1")
+	gServer.Register(old, "user2", "pass", "nick2")
+	c1.Auth("user1", "pass")
+	old.Auth("user2", "pass")
+	old.Flush()
+	old.Disconnect()
+	gServer.SendMessage(c1, "user2", "while offline", AttachData{}, MessageLinks{}, nil)
+
+	server, client := net.Pipe()
+	defer client.Close()
+	c := NewClient(server)
+	listenTest(t, c)
+
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	dec := json.NewDecoder(client)
+	readAction(dec)
+	// Write goroutine is idle when auth comes
+	fmt.Fprint(client, `{"action":"ping"}`)
+	readAction(dec)
+	time.Sleep(10 * time.Millisecond)
+	fmt.Fprint(client, `{"action":"auth","data":{"login":"user2","pass":"pass"}}`)
+	for _, action := range []string{"auth", "ev_message"} {
+		if got := readAction(dec); got != action {
+			t.Errorf("Invalid message '%s' waits '%s'", got, action)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// AnyAction is a key of limit applied to all requests from one ip
+const AnyAction = "*"
+
+// sweepInterval is a period of removing of unused buckets, failures and bans
+const sweepInterval = time.Minute
+
+// Limit is a setting of token bucket
+type Limit struct {
+	Rate  float64 // Tokens added per second
+	Burst int     // Max count of tokens in bucket
+}
+
+// bucket is a token bucket of one action of one user
+type bucket struct {
+	action string
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter limits rate of requests with token buckets
+type RateLimiter struct {
+	mutex   sync.Mutex
+	limits  map[string]Limit   // map key - action
+	buckets map[string]*bucket // map key - action and user
+	swept   time.Time
+	now     func() time.Time
+}
+
+// NewRateLimiter is constructor of RateLimiter
+func NewRateLimiter(limits map[string]Limit) *RateLimiter {
+	return &RateLimiter{
+		limits:  limits,
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Allow takes cost tokens from bucket of action for user (uid or ip).
+// If there are not enough tokens it returns time to wait.
+func (l *RateLimiter) Allow(action string, user string, cost int) (bool, time.Duration) {
+	limit, ok := l.limits[action]
+	if !ok {
+		return true, 0
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+	key := action + "|" + user
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{action: action, tokens: float64(limit.Burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	b.last = now
+
+	// Request can't cost more than full bucket
+	need := math.Min(float64(cost), float64(limit.Burst))
+	if b.tokens >= need {
+		b.tokens -= need
+		return true, 0
+	}
+	if limit.Rate <= 0 {
+		return false, 0
+	}
+	wait := (need - b.tokens) / limit.Rate
+	return false, time.Duration(wait * float64(time.Second))
+}
+
+// sweep removes buckets which are full again: new bucket is the same.
+// l.mutex must be locked.
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < sweepInterval {
+		return
+	}
+	l.swept = now
+	for key, b := range l.buckets {
+		limit := l.limits[b.action]
+		if limit.Rate > 0 && b.tokens+now.Sub(b.last).Seconds()*limit.Rate >= float64(limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// RateLimitError is returned when user has to wait before next request
+type RateLimitError struct {
+	Wait time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("Too many requests, retry after %v", e.Wait)
+}
+
+// AuthGuard bans ip addresses after repeated auth failures
+type AuthGuard struct {
+	mutex    sync.Mutex
+	failures map[string][]time.Time // map key - ip; val - times of failures
+	bans     map[string]time.Time   // map key - ip; val - end of ban
+	max      int
+	window   time.Duration
+	banTime  time.Duration
+	swept    time.Time
+	now      func() time.Time
+}
+
+// NewAuthGuard is constructor of AuthGuard. Ip is banned for banTime
+// after max failures during window.
+func NewAuthGuard(max int, window time.Duration, banTime time.Duration) *AuthGuard {
+	return &AuthGuard{
+		failures: make(map[string][]time.Time),
+		bans:     make(map[string]time.Time),
+		max:      max,
+		window:   window,
+		banTime:  banTime,
+		now:      time.Now,
+	}
+}
+
+// Banned returns time left till the end of ban of ip
+func (g *AuthGuard) Banned(ip string) (bool, time.Duration) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	now := g.now()
+	g.sweep(now)
+	end, ok := g.bans[ip]
+	if !ok {
+		return false, 0
+	}
+	left := end.Sub(now)
+	if left <= 0 {
+		delete(g.bans, ip)
+		return false, 0
+	}
+	return true, left
+}
+
+// Fail registers auth failure from ip
+func (g *AuthGuard) Fail(ip string) {
+	if g.max <= 0 {
+		return
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	now := g.now()
+	g.sweep(now)
+	failures := make([]time.Time, 0, len(g.failures[ip])+1)
+	for _, t := range g.failures[ip] {
+		if now.Sub(t) < g.window {
+			failures = append(failures, t)
+		}
+	}
+	failures = append(failures, now)
+
+	if len(failures) >= g.max {
+		g.bans[ip] = now.Add(g.banTime)
+		delete(g.failures, ip)
+		return
+	}
+	g.failures[ip] = failures
+}
+
+// Reset forgets auth failures from ip
+func (g *AuthGuard) Reset(ip string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	delete(g.failures, ip)
+}
+
+// sweep removes ended bans and failures out of window, g.mutex must be locked
+func (g *AuthGuard) sweep(now time.Time) {
+	if now.Sub(g.swept) < sweepInterval {
+		return
+	}
+	g.swept = now
+	for ip, end := range g.bans {
+		if !now.Before(end) {
+			delete(g.bans, ip)
+		}
+	}
+	for ip, failures := range g.failures {
+		if now.Sub(failures[len(failures)-1]) >= g.window {
+			delete(g.failures, ip)
+		}
+	}
+}
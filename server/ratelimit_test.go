@@ -0,0 +1,169 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+// TestRateLimiterAllow checks RateLimiter.Allow
+func TestRateLimiterAllow(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := NewRateLimiter(map[string]Limit{
+		"message": {Rate: 1, Burst: 2},
+		"import":  {Rate: 10, Burst: 100},
+	})
+	l.now = func() time.Time { return now }
+
+	var testData = []struct {
+		action, user string
+		cost         int
+		pass         time.Duration
+		ok           bool
+		wait         time.Duration
+	}{
+		{"message", "user1", 1, 0, true, 0},
+		{"message", "user1", 1, 0, true, 0},
+		{"message", "user1", 1, 0, false, time.Second},
+		{"message", "user2", 1, 0, true, 0},
+		{"message", "user1", 1, 500 * time.Millisecond, false, 500 * time.Millisecond},
+		{"message", "user1", 1, 500 * time.Millisecond, true, 0},
+		{"unknown", "user1", 1000, 0, true, 0},
+		{"import", "user1", 70, 0, true, 0},
+		{"import", "user1", 50, 0, false, 2 * time.Second},
+		{"import", "user1", 5000, 7 * time.Second, true, 0},
+	}
+	for _, val := range testData {
+		now = now.Add(val.pass)
+		ok, wait := l.Allow(val.action, val.user, val.cost)
+		if ok != val.ok || wait != val.wait {
+			t.Errorf("Allow(%v) = (%v, %v) waits (%v, %v)", val, ok, wait, val.ok, val.wait)
+		}
+	}
+}
+
+// TestAuthGuard checks AuthGuard.Fail, AuthGuard.Banned and AuthGuard.Reset
+func TestAuthGuard(t *testing.T) {
+	now := time.Unix(1000, 0)
+	g := NewAuthGuard(3, time.Minute, 10*time.Minute)
+	g.now = func() time.Time { return now }
+
+	g.Fail("ip1")
+	g.Fail("ip1")
+	g.Reset("ip1")
+	g.Fail("ip1")
+	g.Fail("ip1")
+	if banned, _ := g.Banned("ip1"); banned {
+		t.Errorf("Ip was banned after reset of failures")
+	}
+
+	// Old failures are not counted
+	now = now.Add(2 * time.Minute)
+	g.Fail("ip1")
+	g.Fail("ip1")
+	if banned, _ := g.Banned("ip1"); banned {
+		t.Errorf("Ip was banned by old failures")
+	}
+
+	g.Fail("ip1")
+	if banned, left := g.Banned("ip1"); !banned || left != 10*time.Minute {
+		t.Errorf("Banned('ip1') = (%v, %v) waits (%v, %v)", banned, left, true, 10*time.Minute)
+	}
+	if banned, _ := g.Banned("ip2"); banned {
+		t.Errorf("Another ip was banned")
+	}
+
+	now = now.Add(10 * time.Minute)
+	if banned, _ := g.Banned("ip1"); banned {
+		t.Errorf("Ban was not finished")
+	}
+}
+
+// TestRateLimiterSweep checks removing of full buckets, old failures and ended bans
+func TestRateLimiterSweep(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := NewRateLimiter(map[string]Limit{
+		"message": {Rate: 1, Burst: 2},
+		"slow":    {Rate: 0.001, Burst: 2},
+	})
+	l.now = func() time.Time { return now }
+	l.Allow("message", "user1", 1)
+	l.Allow("message", "user2", 1)
+	l.Allow("slow", "user1", 1)
+
+	now = now.Add(sweepInterval)
+	l.Allow("message", "user3", 1)
+	if len(l.buckets) != 2 {
+		t.Errorf("Full buckets are not removed: %v", l.buckets)
+	}
+	// Bucket which is not full keeps tokens
+	if ok, _ := l.Allow("slow", "user1", 2); ok {
+		t.Errorf("Tokens of bucket are lost")
+	}
+
+	g := NewAuthGuard(2, time.Minute, 10*time.Minute)
+	g.now = func() time.Time { return now }
+	g.Fail("ip1")
+	g.Fail("ip1")
+	g.Fail("ip2")
+	if len(g.bans) != 1 || len(g.failures) != 1 {
+		t.Fatalf("Invalid bans %v and failures %v", g.bans, g.failures)
+	}
+	now = now.Add(10 * time.Minute)
+	g.Banned("ip3")
+	if len(g.bans) != 0 || len(g.failures) != 0 {
+		t.Errorf("Bans %v and failures %v are not removed", g.bans, g.failures)
+	}
+}
+
+// TestClientAuthBan checks ban of Client.Auth after repeated failures
+func TestClientAuthBan(t *testing.T) {
+	gServer = newServer()
+
+	c := NewTestClient(newTestConn())
+	gServer.Register(c, "login", "pass", "nick")
+
+	ansBan := "{\"action\":\"auth\",\"data\":{\"retry_after\":900,\"status\":12,\"error\":\"Too many requests\"}}"
+
+	for i := 0; i < gServer.config.AuthMaxFailures; i++ {
+		c.Auth("login", "wrong")
+	}
+
+	conn := newTestConn()
+	c = NewTestClient(conn)
+	if c.Auth("login", "pass") {
+		t.Errorf("Banned client was authorized")
+	}
+	err := conn.CheckLastMessage(t, ansBan)
+	if nil != err {
+		t.Errorf("%v", err)
+	}
+	if !conn.Closed {
+		t.Errorf("Connection of banned client was not closed")
+	}
+}
+
+// TestClientAllow checks rate limits of Client requests
+func TestClientAllow(t *testing.T) {
+	gServer = newServer()
+	gServer.limiter = NewRateLimiter(map[string]Limit{"message": {Rate: 1, Burst: 1}})
+
+	conn := newTestConn()
+	c := NewTestClient(conn)
+	c.uid = "user"
+
+	ansLimited := "{\"action\":\"message\",\"data\":{\"retry_after\":1,\"status\":12,\"error\":\"Too many requests\"}}"
+
+	if !c.allow("message", 1) {
+		t.Errorf("First request was limited")
+	}
+	if c.allow("message", 1) {
+		t.Errorf("Second request was not limited")
+	}
+	err := conn.CheckLastMessage(t, ansLimited)
+	if nil != err {
+		t.Errorf("%v", err)
+	}
+	if conn.Closed {
+		t.Errorf("Connection was closed")
+	}
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+)
+
+// Role is a set of permissions of user
+type Role string
+
+// Roles of users
+const (
+	RoleUser      Role = "user"      // Regular user
+	RoleModerator Role = "moderator" // User who can delete any message
+	RoleAdmin     Role = "admin"     // User who manages roles
+	RoleBot       Role = "bot"       // Automated account
+)
+
+// Permission is a right to do some actions
+type Permission string
+
+// Permissions of roles
+const (
+	PermPublic      Permission = "public"       // Actions allowed before auth
+	PermChat        Permission = "chat"         // Messages and own profile
+	PermContacts    Permission = "contacts"     // Contact list and import
+	PermModerate    Permission = "moderate"     // Delete messages of other users
+	PermManageRoles Permission = "manage_roles" // Change roles of users
+)
+
+// Permissions granted to each role
+var rolePermissions = map[Role]map[Permission]bool{
+	RoleUser:      {PermChat: true, PermContacts: true},
+	RoleModerator: {PermChat: true, PermContacts: true, PermModerate: true},
+	RoleAdmin:     {PermChat: true, PermContacts: true, PermModerate: true, PermManageRoles: true},
+	RoleBot:       {PermChat: true},
+}
+
+// Permission required for each action, actions missing here need PermChat
+var actionPermissions = map[string]Permission{
+	"register":    PermPublic,
+	"auth":        PermPublic,
+	"ping":        PermPublic,
+	"pong":        PermPublic,
+	"contactlist": PermContacts,
+	"addcontact":  PermContacts,
+	"delcontact":  PermContacts,
+	"import":      PermContacts,
+	"setrole":     PermManageRoles,
+	"roles":       PermManageRoles,
+}
+
+// ValidRole checks if role exists
+func ValidRole(role Role) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// Role returns role of user
+func (s *MessageServer) Role(uid string) Role {
+	s.dirMutex.RLock()
+	defer s.dirMutex.RUnlock()
+
+	a, ok := s.accounts[uid]
+	if !ok || a.Role == "" {
+		return RoleUser
+	}
+	return a.Role
+}
+
+// HasPermission checks if role of user grants permission
+func (s *MessageServer) HasPermission(uid string, perm Permission) bool {
+	return rolePermissions[s.Role(uid)][perm]
+}
+
+// Authorize is the central check of access of client to action
+func (s *MessageServer) Authorize(c *Client, action string) (int, error) {
+	perm, ok := actionPermissions[action]
+	if !ok {
+		perm = PermChat
+	}
+	if perm == PermPublic {
+		return ErrOK, nil
+	}
+	if c.uid == "" {
+		return ErrNeedAuth, errors.New("Need auth")
+	}
+	if !s.HasPermission(c.uid, perm) {
+		return ErrAccessDenied, errors.New("Access denied")
+	}
+	return ErrOK, nil
+}
+
+// setRole changes role of registered user. Role of bot is given only by CreateBot.
+func (s *MessageServer) setRole(uid string, role Role) (int, error) {
+	if !ValidRole(role) || role == RoleBot {
+		return ErrInvalidData, errors.New("Invalid role")
+	}
+	if s.IsBot(uid) {
+		return ErrAccessDenied, errors.New("Can't change role of bot")
+	}
+	if !s.UpdateAccount(uid, func(a *Account) { a.Role = role }) {
+		return ErrUserNotFound, errors.New("User not found")
+	}
+	logger(LogServer).Info("Role is changed", "uid", uid, "role", string(role))
+	return ErrOK, nil
+}
+
+// SetRole admin changes role of user
+func (s *MessageServer) SetRole(c *Client, uid string, role Role) {
+	if uid == c.cid {
+		c.Error("setrole", "Can't change own role", ErrAccessDenied, false)
+		return
+	}
+	status, err := s.setRole(uid, role)
+	if err != nil {
+		c.Error("setrole", err.Error(), status, false)
+		return
+	}
+	c.Ok("setrole")
+}
+
+// roleList returns users with roles other than RoleUser sorted by uid
+func (s *MessageServer) roleList() []RoleData {
+	s.dirMutex.RLock()
+	defer s.dirMutex.RUnlock()
+
+	list := make([]RoleData, 0)
+	for uid, a := range s.accounts {
+		if a.Role != "" && a.Role != RoleUser {
+			list = append(list, RoleData{Uid: uid, Role: a.Role})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Uid < list[j].Uid })
+	return list
+}
+
+// GetRoles admin gets users with special roles
+func (s *MessageServer) GetRoles(c *Client) {
+	m := SrvRoles{Roles: s.roleList()}
+	m.Status = ErrOK
+	m.Error = "OK"
+	mess, err := json.Marshal(struct {
+		Action string   `json:"action"`
+		Data   SrvRoles `json:"data"`
+	}{
+		Action: "roles",
+		Data:   m,
+	})
+	if !c.CheckError(err, "Can't marhsal answer") {
+		return
+	}
+	c.Send(mess)
+}
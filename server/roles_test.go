@@ -0,0 +1,114 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestServerAuthorize checks access of roles to actions
+func TestServerAuthorize(t *testing.T) {
+	gServer = newServer()
+	gServer.CreateBot("bot", "Bot")
+
+	c := NewClient(newTestConn())
+	if status, _ := gServer.Authorize(c, "auth"); status != ErrOK {
+		t.Errorf("Public action is denied: %d", status)
+	}
+	if status, _ := gServer.Authorize(c, "message"); status != ErrNeedAuth {
+		t.Errorf("Action is allowed before auth: %d", status)
+	}
+	c.uid = "user"
+	if status, _ := gServer.Authorize(c, "message"); status != ErrOK {
+		t.Errorf("Message is denied for user: %d", status)
+	}
+	if status, _ := gServer.Authorize(c, "setrole"); status != ErrAccessDenied {
+		t.Errorf("Setrole is allowed for user: %d", status)
+	}
+	c.uid = "bot"
+	if status, _ := gServer.Authorize(c, "import"); status != ErrAccessDenied {
+		t.Errorf("Import is allowed for bot: %d", status)
+	}
+}
+
+// TestServerSetRole checks role management by admin
+func TestServerSetRole(t *testing.T) {
+	config := DefaultConfig()
+	config.Admins = []string{"admin"}
+	gServer = newServerWithConfig(config)
+
+	conn := newTestConn()
+	admin := NewTestClient(conn)
+	gServer.Register(admin, "admin", "pass", "admin")
+	gServer.Register(NewTestClient(newTestConn()), "user", "pass", "user")
+	gServer.CreateBot("bot", "Bot")
+	if gServer.Role("admin") != RoleAdmin || gServer.Role("user") != RoleUser || gServer.Role("bot") != RoleBot {
+		t.Fatalf("Invalid roles after registration")
+	}
+
+	answers := []struct {
+		uid    string
+		role   Role
+		answer string
+	}{
+		{"user", RoleModerator, "{\"action\":\"setrole\",\"data\":{\"status\":0,\"error\":\"OK\"}}"},
+		{"user", "king", "{\"action\":\"setrole\",\"data\":{\"status\":3,\"error\":\"Invalid role\"}}"},
+		{"user", RoleBot, "{\"action\":\"setrole\",\"data\":{\"status\":3,\"error\":\"Invalid role\"}}"},
+		{"bot", RoleUser, "{\"action\":\"setrole\",\"data\":{\"status\":10,\"error\":\"Can't change role of bot\"}}"},
+		{"unknown", RoleModerator, "{\"action\":\"setrole\",\"data\":{\"status\":8,\"error\":\"User not found\"}}"},
+		{"admin", RoleUser, "{\"action\":\"setrole\",\"data\":{\"status\":10,\"error\":\"Can't change own role\"}}"},
+	}
+	for _, a := range answers {
+		gServer.SetRole(admin, a.uid, a.role)
+		admin.Flush()
+		if err := conn.CheckLastMessage(t, a.answer); err != nil {
+			t.Errorf("%v", err)
+		}
+	}
+	if a, _ := gServer.Account("user"); a.Role != RoleModerator {
+		t.Errorf("Role is not changed")
+	}
+	if gServer.IsBot("user") || !gServer.IsBot("bot") {
+		t.Errorf("Bot status is changed by role")
+	}
+
+	gServer.GetRoles(admin)
+	admin.Flush()
+	ans := "{\"action\":\"roles\",\"data\":{\"list\":[{\"uid\":\"admin\",\"role\":\"admin\"},{\"uid\":\"bot\",\"role\":\"bot\"},{\"uid\":\"user\",\"role\":\"moderator\"}],\"status\":0,\"error\":\"OK\"}}"
+	if err := conn.CheckLastMessage(t, ans); err != nil {
+		t.Errorf("%v", err)
+	}
+}
+
+// TestServerModeratorDelete checks deletion of message by moderator
+func TestServerModeratorDelete(t *testing.T) {
+	gServer = newServer()
+	conns := make([]*testConn, 3)
+	clients := make([]*Client, 3)
+	for i := range clients {
+		conns[i] = newTestConn()
+		clients[i] = NewTestClient(conns[i])
+		login := fmt.Sprintf("user%d", i)
+		gServer.Register(clients[i], login, "pass", login)
+		clients[i].Auth(login, "pass")
+	}
+	gServer.setRole("user2", RoleModerator)
+
+	gServer.SendMessage(clients[0], "user1", "spam", AttachData{}, MessageLinks{}, nil)
+	mid := gServer.history.lastID
+
+	gServer.DeleteMessage(clients[1], fmt.Sprint(mid))
+	clients[1].Flush()
+	ans := "{\"action\":\"deletemessage\",\"data\":{\"status\":10,\"error\":\"Only author can change message\"}}"
+	if err := conns[1].CheckLastMessage(t, ans); err != nil {
+		t.Errorf("%v", err)
+	}
+
+	gServer.DeleteMessage(clients[2], fmt.Sprint(mid))
+	event := fmt.Sprintf("{\"action\":\"ev_message_deleted\",\"data\":{\"mid\":\"%d\",\"from\":\"user0\"}}", mid)
+	for _, i := range []int{0, 1, 2} {
+		clients[i].Flush()
+		if err := conns[i].CheckLastMessage(t, event); err != nil {
+			t.Errorf("%v", err)
+		}
+	}
+}
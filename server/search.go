@@ -0,0 +1,110 @@
+package server
+
+import (
+	"strings"
+	"unicode"
+)
+
+// SearchFilter is a set of conditions of message search
+type SearchFilter struct {
+	Peer   string // UserID of another participant of dialog
+	After  int    // Messages sent not earlier than this time
+	Before int    // Messages sent not later than this time (0 - any)
+	Mime   string // Mime type of attach, "type/*" matches any subtype
+}
+
+// Match checks message by filter conditions
+func (f *SearchFilter) Match(m *StoredMessage) bool {
+	if f.Peer != "" && m.From != f.Peer && m.To != f.Peer {
+		return false
+	}
+	if m.Time < f.After || (f.Before != 0 && m.Time > f.Before) {
+		return false
+	}
+	if f.Mime != "" {
+		if strings.HasSuffix(f.Mime, "*") {
+			return strings.HasPrefix(m.Attach.Mime, strings.TrimSuffix(f.Mime, "*"))
+		}
+		return m.Attach.Mime == f.Mime
+	}
+	return true
+}
+
+// Tokenize splits text to lowercase words.
+// Letter 'ё' is replaced by 'е' as russian users write it both ways.
+func Tokenize(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, word := range words {
+		words[i] = strings.Replace(word, "ё", "е", -1)
+	}
+	return words
+}
+
+// SearchIndex is an inverted index of message bodies
+type SearchIndex struct {
+	tokens map[string]map[string]bool // map key - token; val - set of mids
+}
+
+// NewSearchIndex is constructor of SearchIndex
+func NewSearchIndex() *SearchIndex {
+	return &SearchIndex{
+		tokens: make(map[string]map[string]bool),
+	}
+}
+
+// Add indexes text of message
+func (idx *SearchIndex) Add(mid string, text string) {
+	for _, token := range Tokenize(text) {
+		mids, ok := idx.tokens[token]
+		if !ok {
+			mids = make(map[string]bool)
+			idx.tokens[token] = mids
+		}
+		mids[mid] = true
+	}
+}
+
+// Remove removes text of message from index
+func (idx *SearchIndex) Remove(mid string, text string) {
+	for _, token := range Tokenize(text) {
+		if mids, ok := idx.tokens[token]; ok {
+			delete(mids, mid)
+			if len(mids) == 0 {
+				delete(idx.tokens, token)
+			}
+		}
+	}
+}
+
+// Find returns mids of messages which contain all words of query
+func (idx *SearchIndex) Find(query string) []string {
+	tokens := Tokenize(query)
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	// Start from the rarest token
+	rarest := idx.tokens[tokens[0]]
+	for _, token := range tokens[1:] {
+		if mids := idx.tokens[token]; len(mids) < len(rarest) {
+			rarest = mids
+		}
+	}
+
+	result := make([]string, 0)
+	for mid := range rarest {
+		found := true
+		for _, token := range tokens {
+			if !idx.tokens[token][mid] {
+				found = false
+				break
+			}
+		}
+		if found {
+			result = append(result, mid)
+		}
+	}
+	return result
+}
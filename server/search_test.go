@@ -0,0 +1,105 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestTokenize checks Tokenize
+func TestTokenize(t *testing.T) {
+	var testData = []struct {
+		text, tokens string
+	}{
+		{"", "[]"},
+		{"Hello, World!", "[hello world]"},
+		{"Привет, МИР! Ёжик в тумане", "[привет мир ежик в тумане]"},
+		{"test@mail.ru +7(999)123", "[test mail ru 7 999 123]"},
+	}
+	for _, val := range testData {
+		tokens := fmt.Sprint(Tokenize(val.text))
+		if tokens != val.tokens {
+			t.Errorf("Tokenize('%s') = %s waits %s", val.text, tokens, val.tokens)
+		}
+	}
+}
+
+// TestSearchIndex checks SearchIndex.Add, SearchIndex.Remove and SearchIndex.Find
+func TestSearchIndex(t *testing.T) {
+	idx := NewSearchIndex()
+	idx.Add("1", "Привет, как дела?")
+	idx.Add("2", "Дела идут отлично")
+
+	var testData = []struct {
+		query string
+		count int
+	}{
+		{"дела", 2},
+		{"ДЕЛА привет", 1},
+		{"привет отлично", 0},
+		{"неизвестно", 0},
+		{"!!!", 0},
+	}
+	for _, val := range testData {
+		if mids := idx.Find(val.query); len(mids) != val.count {
+			t.Errorf("Find('%s') = %v waits %v results", val.query, mids, val.count)
+		}
+	}
+
+	idx.Remove("1", "Привет, как дела?")
+	if mids := idx.Find("привет"); len(mids) != 0 {
+		t.Errorf("Found removed message %v", mids)
+	}
+	if _, ok := idx.tokens["привет"]; ok {
+		t.Errorf("Empty token was not removed")
+	}
+}
+
+// TestHistorySearch checks History.Search with filters
+func TestHistorySearch(t *testing.T) {
+	h := NewHistory()
+	h.Add(&StoredMessage{From: "user1", To: "user2", Body: "Встреча завтра", Time: 100})
+	h.Add(&StoredMessage{From: "user2", To: "user1", Body: "Встреча отменена", Time: 200,
+		Attach: AttachData{"image/png", "data"}})
+	h.Add(&StoredMessage{From: "user1", To: "user3", Body: "Встреча в силе", Time: 300,
+		Attach: AttachData{"text/plain", "data"}})
+	h.Add(&StoredMessage{From: "user2", To: "user3", Body: "Встреча без меня", Time: 400})
+
+	var testData = []struct {
+		uid, query string
+		filter     SearchFilter
+		mids       string
+	}{
+		{"user1", "встреча", SearchFilter{}, "[3 2 1]"},
+		{"user3", "встреча", SearchFilter{}, "[4 3]"},
+		{"user1", "встреча", SearchFilter{Peer: "user2"}, "[2 1]"},
+		{"user1", "встреча", SearchFilter{After: 150, Before: 250}, "[2]"},
+		{"user1", "встреча", SearchFilter{Mime: "image/png"}, "[2]"},
+		{"user1", "встреча", SearchFilter{Mime: "text/*"}, "[3]"},
+		{"user1", "встреча завтра", SearchFilter{}, "[1]"},
+	}
+	for _, val := range testData {
+		mids := make([]string, 0)
+		for _, m := range h.Search(val.uid, val.query, val.filter, 10) {
+			mids = append(mids, m.Mid)
+		}
+		if fmt.Sprint(mids) != val.mids {
+			t.Errorf("Search(%v) = %v waits %v", val, mids, val.mids)
+		}
+	}
+
+	// Index is updated by edit and delete
+	h.Edit("1", "Встреча послезавтра", 500)
+	if list := h.Search("user1", "завтра", SearchFilter{}, 10); len(list) != 0 {
+		t.Errorf("Found old body of edited message %v", list)
+	}
+	if list := h.Search("user1", "послезавтра", SearchFilter{}, 10); len(list) != 1 {
+		t.Errorf("Not found new body of edited message %v", list)
+	}
+	h.Delete("1")
+	if list := h.Search("user1", "послезавтра", SearchFilter{}, 10); len(list) != 0 {
+		t.Errorf("Found deleted message %v", list)
+	}
+	if list := h.Search("user1", "встреча", SearchFilter{}, 1); len(list) != 1 {
+		t.Errorf("Limit is not applied %v", list)
+	}
+}
@@ -3,60 +3,110 @@ package server
 import (
 	"encoding/json"
 	"errors"
-	"log"
 	"net"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
 // Error codes
 const (
-	ErrOK              = 0 // All OK
-	ErrAlreadyExist    = 1 // Login or Nickname or Channel already exist
-	ErrInvalidPass     = 2 // Invalid login or password
-	ErrInvalidData     = 3 // Invalid JSON
-	ErrEmptyField      = 4 // Empty Nick, Login, Password or Channel
-	ErrAlreadyRegister = 5 // User is already registered
-	ErrNeedAuth        = 6 // User has to auth
-	ErrNeedRegister    = 7 // User has to register
-	ErrUserNotFound    = 8 // User not found by uid
+	ErrOK              = 0  // All OK
+	ErrAlreadyExist    = 1  // Login, Nickname, Channel, email or phone already exist
+	ErrInvalidPass     = 2  // Invalid login or password
+	ErrInvalidData     = 3  // Invalid JSON
+	ErrEmptyField      = 4  // Empty Nick, Login, Password or Channel
+	ErrAlreadyRegister = 5  // User is already registered
+	ErrNeedAuth        = 6  // User has to auth
+	ErrNeedRegister    = 7  // User has to register
+	ErrUserNotFound    = 8  // User not found by uid
+	ErrMessageNotFound = 9  // Message not found by mid
+	ErrAccessDenied    = 10 // User has no rights for this action
+	ErrTimeExpired     = 11 // Time for this action is over
+	ErrRateLimited     = 12 // Too many requests, retry later
+	ErrTooLarge        = 13 // Request, message or attachment is too large
 )
 
+// Max length of emoji in reaction (in bytes), sequences joined by ZWJ are long
+const maxEmojiLen = 64
+
 ///////////////// Server Class ////////////////////////////////////////////////
 
 // Server is an interface of server
 type Server interface {
 	Start(port int)
+	Serve(l net.Listener)
 	Auth(c *Client, login string, pass string) (string, int, error)
 	GetUserData(uid string) (*Client, bool)
 	GetUserInfo(c *Client, uid string)
 	Register(c *Client, login string, pass string, nick string) (int, error)
-	SendMessage(c *Client, uid string, body string, attach AttachData)
-	UpdateUserData(c *Client, email string, phone string)
+	SendMessage(c *Client, uid string, body string, attach AttachData, links MessageLinks, envelopes []Envelope)
+	UpdateUserData(c *Client, email string, phone string) (int, error)
+	EditMessage(c *Client, mid string, body string)
+	DeleteMessage(c *Client, mid string)
+	React(c *Client, mid string, emoji string, remove bool)
+	GetHistory(c *Client, uid string, before string, limit int)
+	SearchMessages(c *Client, query string, filter SearchFilter, limit int)
+	Notify(n SystemNotification) (int, error)
+	SetMOTD(motd string)
 }
 
 // MessageServer is global data storage
 type MessageServer struct {
-	Logins       map[string]string
-	Nicks        map[string]string
-	LoginsPasses map[string]string
-	Users        map[string]string
-	emails       map[string]string // map key - email; val - uid
-	phones       map[string]string // map key - phone; val - uid
-	Clients      map[string]*Client
+	*UserDirectory                    // Accounts of registered users
+	Clients        map[string]*Client // Live clients, they stay here after disconnect
+	clientsMutex   sync.RWMutex       // Guards Clients
+	history        *History
+	conversations  *Conversations
+	events         *EventLog
+	limiter        *RateLimiter
+	authGuard      *AuthGuard
+	queues         queueCounters
+	metrics        *Metrics
+	loggers        *Loggers
+	bans           map[string]time.Time // map key - uid; val - end of ban (zero - forever)
+	motd           string               // Message of the day
+	adminMutex     sync.Mutex           // Guards bans and motd
+	bots           map[string]*Bot      // map key - uid
+	botTokens      map[string]string    // map key - hash of API token; val - uid
+	botsMutex      sync.RWMutex         // Guards bots and botTokens
+	webhooks       *Webhooks
+	keys           *KeyDirectory
+	verifier       *Verifier
+	config         Config
 }
 
 // NewServer is constructor of Server
 func newServer() *MessageServer {
+	return newServerWithConfig(DefaultConfig())
+}
+
+// newServerWithConfig is constructor of Server with custom settings
+func newServerWithConfig(config Config) *MessageServer {
 	s := &MessageServer{
-		Logins:       make(map[string]string),
-		Nicks:        make(map[string]string),
-		LoginsPasses: make(map[string]string),
-		Users:        make(map[string]string),
-		emails:       make(map[string]string),
-		phones:       make(map[string]string),
-		Clients:      make(map[string]*Client),
+		UserDirectory: NewUserDirectory(),
+		Clients:       make(map[string]*Client),
+		history:       NewHistory(),
+		conversations: NewConversations(),
+		events:        NewEventLog(config.SyncLogSize),
+		limiter:       NewRateLimiter(config.RateLimits),
+		authGuard:     NewAuthGuard(config.AuthMaxFailures, config.AuthFailWindow, config.AuthBanTime),
+		metrics:       NewMetrics(),
+		loggers:       NewLoggers(config),
+		bans:          make(map[string]time.Time),
+		motd:          config.MOTD,
+		bots:          make(map[string]*Bot),
+		botTokens:     make(map[string]string),
+		keys:          NewKeyDirectory(),
+		config:        config,
 	}
+	s.webhooks = NewWebhooks(config, s.IsBot)
+	sender := config.VerifySender
+	if sender == nil {
+		sender = NewLocalSender()
+	}
+	s.verifier = NewVerifier(sender, config.VerifyCodeTTL, config.VerifyAttempts)
 	return s
 }
 
@@ -68,6 +118,12 @@ func CreateInstance() Server {
 	return gServer
 }
 
+// CreateInstanceWithConfig create instance of Server with custom settings
+func CreateInstanceWithConfig(config Config) Server {
+	gServer = newServerWithConfig(config)
+	return gServer
+}
+
 // GetInstance gets instance of Server
 func GetInstance() Server {
 	return gServer
@@ -77,9 +133,26 @@ func GetInstance() Server {
 func (s *MessageServer) Start(port int) {
 	psock, err := net.Listen("tcp", ":"+strconv.Itoa(port))
 	CheckError(err, "Can't create a server", true)
-	log.Printf("Server start on port %v \n", port)
+	logger(LogServer).Info("Server start", "port", port)
+	s.Serve(psock)
+}
+
+// Serve accepts connections of users from listener
+func (s *MessageServer) Serve(psock net.Listener) {
+	if s.config.MetricsAddr != "" {
+		go s.StartMetrics(s.config.MetricsAddr)
+	}
+	if s.config.AdminAddr != "" {
+		go s.StartAdmin(s.config.AdminAddr, s.config.AdminToken)
+	}
+	if s.config.BotAddr != "" {
+		go s.StartBotAPI(s.config.BotAddr)
+	}
 	for {
 		conn, err := psock.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
 		if CheckError(err, "Can't create connection", false) {
 			client := NewClient(conn)
 			client.Listen()
@@ -89,33 +162,53 @@ func (s *MessageServer) Start(port int) {
 
 // Auth checks auth of Client
 func (s *MessageServer) Auth(c *Client, login string, pass string) (string, int, error) {
+	if banned, wait := s.authGuard.Banned(c.Host()); banned {
+		return "", ErrRateLimited, &RateLimitError{Wait: wait}
+	}
 	if login == "" || pass == "" {
 		return "", ErrEmptyField, errors.New("Empty field")
 	}
 
-	nick, ok := s.Logins[login]
+	nick, p, ok := s.Credentials(login)
 	if !ok {
 		return "", ErrNeedRegister, errors.New("Need to register")
 	}
-	p, ok := s.LoginsPasses[login]
-	if !ok || p != pass {
+	if p == "" || p != pass {
+		s.authGuard.Fail(c.Host())
+		s.metrics.Auth(false)
+		c.logger(LogServer).Warn("Auth failed", "login", login)
 		return "", ErrInvalidPass, errors.New("Invalid login or password!")
 	}
+	s.authGuard.Reset(c.Host())
+	if s.Banned(login) {
+		return "", ErrAccessDenied, errors.New("User is banned")
+	}
+	s.metrics.Auth(true)
+
+	s.clientsMutex.Lock()
+	defer s.clientsMutex.Unlock()
 
 	var old *Client
 	old, ok = s.Clients[login]
 	if ok && old != c {
-		old.conn.Close() // Force close connection
-		c.status = old.status
-		c.avatar = old.avatar
-		c.email = old.email
-		c.phone = old.phone
-		c.contacts = old.contacts
-		c.offlineMessages = old.offlineMessages
+		// Force close connection, messages of old queue and the one being written stay offline
+		old.Disconnect()
+		old.waitWriting()
+		messages := old.takeOfflineMessages()
+		c.mutex.Lock()
+		c.offlineMessages = append(messages, c.offlineMessages...)
+		c.mutex.Unlock()
+	}
+	c.mutex.Lock()
+	if a, ok := s.Account(login); ok {
+		c.loadAccount(a)
 	}
-	s.Clients[login] = c
 	c.nick = nick
 	c.cid = login
+	c.mutex.Unlock()
+	s.Clients[login] = c
+	c.logger(LogServer).Info("Auth", "login", login)
+	s.webhooks.Emit(EventUserAuthenticated, WebhookUserData{Uid: login, Nick: nick, Ip: c.Host()})
 
 	return GetMD5Hash(login), ErrOK, nil
 }
@@ -125,35 +218,39 @@ func (s *MessageServer) Register(c *Client, login string, pass string, nick stri
 	if login == "" || nick == "" || pass == "" {
 		return ErrEmptyField, errors.New("Empty field")
 	}
-	_, ok := s.Nicks[nick]
-	if ok {
-		return ErrAlreadyExist, errors.New("Nick already was used")
-	}
-	_, ok = s.Logins[login]
-	if ok {
-		return ErrAlreadyExist, errors.New("Login already was used")
+	if status, err := s.AddAccount(login, pass, nick); err != nil {
+		return status, err
 	}
-	s.Nicks[nick] = login
-	s.Logins[login] = nick
-	s.LoginsPasses[login] = pass
+	c.mutex.Lock()
 	c.cid = login
-	s.Users[c.cid] = login
+	c.mutex.Unlock()
+	for _, admin := range s.config.Admins {
+		if admin == login {
+			s.UpdateAccount(login, func(a *Account) { a.Role = RoleAdmin })
+		}
+	}
+	c.logger(LogServer).Info("Register", "login", login, "nick", nick)
+	s.webhooks.Emit(EventUserRegistered, WebhookUserData{Uid: login, Nick: nick})
 	return ErrOK, nil
 }
 
-// GetUserInfo gets user info to another user
+// GetUserInfo gets user info to another user, user may be offline
 func (s *MessageServer) GetUserInfo(c *Client, uid string) {
-	client, ok := s.GetUserData(uid)
+	user, ok := s.profile(uid)
 	if !ok {
 		c.Error("userinfo", "User not found", ErrUserNotFound, false)
 		return
 	}
 	m := SrvUserInfo{
-		Nick:       client.nick,
-		UserStatus: client.status,
-		Email:      client.email,
-		Phone:      client.phone,
-		Avatar:     client.avatar,
+		Nick:       user.Nick,
+		UserStatus: user.Status,
+		Email:      user.Email,
+		Phone:      user.Phone,
+		Avatar:     user.Avatar,
+		Role:       s.Role(uid),
+
+		EmailVerified: user.EmailVerified,
+		PhoneVerified: user.PhoneVerified,
 	}
 	m.Status = ErrOK
 	m.Error = "OK"
@@ -170,25 +267,57 @@ func (s *MessageServer) GetUserInfo(c *Client, uid string) {
 		c.Disconnect()
 		return
 	}
-	c.outgoing <- mess
+	c.Send(mess)
+}
+
+// profile returns account of user. Clients without account (bots) give profile of session.
+func (s *MessageServer) profile(uid string) (Account, bool) {
+	if a, ok := s.Account(uid); ok {
+		return a, true
+	}
+	c, ok := s.GetUserData(uid)
+	if !ok {
+		return Account{}, false
+	}
+	return c.account(), true
+}
+
+// userData returns public profile of user for contact list and import
+func (s *MessageServer) userData(a Account) UserData {
+	return UserData{
+		Uid:    a.Uid,
+		Nick:   a.Nick,
+		Email:  a.Email,
+		Phone:  a.Phone,
+		Avatar: a.Avatar,
+		Bot:    s.IsBot(a.Uid),
+	}
 }
 
 // GetUserData returned user by UserID
 func (s *MessageServer) GetUserData(uid string) (*Client, bool) {
+	s.clientsMutex.RLock()
+	defer s.clientsMutex.RUnlock()
+
 	c, ok := s.Clients[uid]
 	return c, ok
 }
 
-// FindUser finds user by email or phone number
-func (s *MessageServer) FindUser(email string, phone string) (*Client, bool) {
-	uid, ok := "", false
-	if email != "" {
-		uid, ok = s.emails[email]
-	}
-	if !ok && phone != "" {
-		uid, ok = s.phones[phone]
+// clientList returns all users
+func (s *MessageServer) clientList() []*Client {
+	s.clientsMutex.RLock()
+	defer s.clientsMutex.RUnlock()
+
+	list := make([]*Client, 0, len(s.Clients))
+	for _, c := range s.Clients {
+		list = append(list, c)
 	}
+	return list
+}
 
+// FindUser finds user by email or phone number
+func (s *MessageServer) FindUser(email string, phone string) (*Client, bool) {
+	uid, ok := s.findUid(email, phone)
 	if ok {
 		var user *Client
 		user, _ = s.GetUserData(uid)
@@ -198,55 +327,415 @@ func (s *MessageServer) FindUser(email string, phone string) (*Client, bool) {
 	return nil, false
 }
 
+// findUid finds uid of user by verified email or phone number
+func (s *MessageServer) findUid(email string, phone string) (string, bool) {
+	uid, ok := "", false
+	if email, valid := NormalizeEmail(email); valid {
+		uid, ok = s.Owner(VerifyEmail, email)
+	}
+	if phone, valid := s.normalizePhone(phone); !ok && valid {
+		uid, ok = s.Owner(VerifyPhone, phone)
+	}
+	return uid, ok
+}
+
 // SendMessage user sends message to channel
-func (s *MessageServer) SendMessage(c *Client, uid string, body string, attach AttachData) {
-	if body == "" {
-		c.Error("message", "Body is empty", ErrEmptyField, false)
+func (s *MessageServer) SendMessage(c *Client, uid string, body string, attach AttachData, links MessageLinks, envelopes []Envelope) {
+	msg, status, err := s.prepareMessage(c, uid, body, attach, links, envelopes)
+	if err != nil {
+		c.Error("message", err.Error(), status, false)
 		return
 	}
+	c.Ok("message")
+	s.dispatchMessage(c, msg)
+}
+
+// prepareMessage checks message of client to registered user, he may have no session.
+// Encrypted message has envelopes and may have empty body.
+func (s *MessageServer) prepareMessage(c *Client, uid string, body string, attach AttachData, links MessageLinks, envelopes []Envelope) (*StoredMessage, int, error) {
+	var forwarded *ForwardData
+	if links.ForwardedFrom != "" {
+		// Forward copies original message exactly, otherwise text of user is shown as text of author
+		if body != "" || attach.Mime != "" || attach.Data != "" || len(envelopes) > 0 {
+			return nil, ErrInvalidData, errors.New("Forwarded message can't be changed")
+		}
+		orig, ok := s.history.Get(links.ForwardedFrom)
+		if !ok || orig.Deleted || !orig.Visible(c.cid) {
+			return nil, ErrMessageNotFound, errors.New("Forwarded message not found")
+		}
+		forwarded = orig.Forwarded
+		if forwarded == nil {
+			forwarded = &ForwardData{
+				Mid:  orig.Mid,
+				From: orig.From,
+				Nick: orig.Nick,
+			}
+		}
+		body = orig.Body
+		attach = orig.Attach
+	}
+
+	if body == "" && len(envelopes) == 0 {
+		return nil, ErrEmptyField, errors.New("Body is empty")
+	}
+	if !s.checkMessageSize(body, attach) {
+		return nil, ErrTooLarge, errors.New("Message is too large")
+	}
+	if status, err := s.checkEnvelopes(envelopes, c.cid, uid); err != nil {
+		return nil, status, err
+	}
 
-	user, ok := s.GetUserData(uid)
+	if _, ok := s.profile(uid); !ok {
+		return nil, ErrUserNotFound, errors.New("Invalid user")
+	}
+
+	if links.ReplyTo != "" {
+		orig, ok := s.history.Get(links.ReplyTo)
+		if !ok || orig.Deleted || DialogKey(orig.From, orig.To) != DialogKey(c.cid, uid) {
+			return nil, ErrMessageNotFound, errors.New("Replied message not found")
+		}
+	}
+
+	msg := &StoredMessage{
+		From:   c.cid,
+		To:     uid,
+		Nick:   c.nick,
+		Body:   body,
+		Time:   int(time.Now().Unix()),
+		Attach: attach,
+
+		ReplyTo:   links.ReplyTo,
+		Forwarded: forwarded,
+		Envelopes: envelopes,
+	}
+	return msg, ErrOK, nil
+}
+
+// dispatchMessage stores message in history and sends it to both users. Recipient
+// without session gets it from history.
+func (s *MessageServer) dispatchMessage(c *Client, msg *StoredMessage) {
+	s.history.Add(msg)
+	s.conversations.Message(msg)
+	s.record("ev_message", msg.Event(), msg.From, msg.To)
+	s.metrics.Message()
+	s.webhooks.Emit(EventMessageSent, msg.Data())
+
+	m, err := json.Marshal(struct {
+		Action string       `json:"action"`
+		Data   EvSrvMessage `json:"data"`
+	}{
+		Action: "ev_message",
+		Data:   msg.Event(),
+	})
+	if !c.CheckError(err, "Can't marhsal answer") {
+		return
+	}
+	if user, ok := s.GetUserData(msg.To); ok {
+		user.Send(m)
+	}
+	// Bot knows mid from answer of bot API and doesn't get its own messages
+	if !s.IsBot(c.cid) {
+		c.Send(m)
+	}
+}
+
+// checkAuthor finds message which author can still change
+func (s *MessageServer) checkAuthor(c *Client, action string, mid string) (StoredMessage, bool) {
+	msg, ok := s.history.Get(mid)
+	if !ok || msg.Deleted {
+		c.Error(action, "Message not found", ErrMessageNotFound, false)
+		return msg, false
+	}
+	if msg.From != c.cid {
+		c.Error(action, "Only author can change message", ErrAccessDenied, false)
+		return msg, false
+	}
+	if time.Since(time.Unix(int64(msg.Time), 0)) > s.config.EditWindow {
+		c.Error(action, "Time for changes is over", ErrTimeExpired, false)
+		return msg, false
+	}
+	return msg, true
+}
+
+// EditMessage author changes body of sent message
+func (s *MessageServer) EditMessage(c *Client, mid string, body string) {
+	if body == "" {
+		c.Error("editmessage", "Body is empty", ErrEmptyField, false)
+		return
+	}
+	if !s.checkMessageSize(body, AttachData{}) {
+		c.Error("editmessage", "Message is too large", ErrTooLarge, false)
+		return
+	}
+	orig, ok := s.checkAuthor(c, "editmessage", mid)
 	if !ok {
-		c.Error("message", "Invalid user", ErrUserNotFound, false)
 		return
 	}
-	c.Ok("message")
+	if len(orig.Envelopes) > 0 {
+		c.Error("editmessage", "Encrypted message can't be edited", ErrInvalidData, false)
+		return
+	}
+	msg, ok := s.history.Edit(mid, body, int(time.Now().Unix()))
+	if !ok {
+		c.Error("editmessage", "Message not found", ErrMessageNotFound, false)
+		return
+	}
+	c.Ok("editmessage")
 
+	edited := EvSrvMessageEdited{
+		Mid:  msg.Mid,
+		From: msg.From,
+		Body: msg.Body,
+		Time: msg.Edited,
+	}
+	s.record("ev_message_edited", edited, msg.From, msg.To)
 	m, err := json.Marshal(struct {
+		Action string             `json:"action"`
+		Data   EvSrvMessageEdited `json:"data"`
+	}{
+		Action: "ev_message_edited",
+		Data:   edited,
+	})
+	if !c.CheckError(err, "Can't marhsal answer") {
+		return
+	}
+	queued, err := json.Marshal(struct {
 		Action string       `json:"action"`
 		Data   EvSrvMessage `json:"data"`
 	}{
 		Action: "ev_message",
-		Data: EvSrvMessage{
-			From:   c.cid,
-			Nick:   c.nick,
-			Body:   body,
-			Time:   int(time.Now().Unix()),
-			Attach: attach,
-		},
+		Data:   msg.Event(),
 	})
 	if !c.CheckError(err, "Can't marhsal answer") {
 		return
 	}
-	user.outgoing <- m
-	c.outgoing <- m
+	if user, ok := s.GetUserData(msg.To); ok {
+		user.ReplaceOfflineMessage(mid, queued)
+		user.Send(m)
+	}
+	c.Send(m)
 }
 
-// UpdateUserData - update email and phone
-func (s *MessageServer) UpdateUserData(c *Client, email string, phone string) {
-	if email != "" {
-		if email != c.email && c.email != "" {
-			delete(s.emails, c.email)
+// DeleteMessage author removes sent message
+func (s *MessageServer) DeleteMessage(c *Client, mid string) {
+	if s.HasPermission(c.cid, PermModerate) {
+		// Moderator deletes any message without time limit
+		if msg, ok := s.history.Get(mid); !ok || msg.Deleted {
+			c.Error("deletemessage", "Message not found", ErrMessageNotFound, false)
+			return
 		}
-		c.email = email
-		s.emails[email] = c.login
+	} else if _, ok := s.checkAuthor(c, "deletemessage", mid); !ok {
+		return
 	}
+	msg, ok := s.history.Delete(mid)
+	if !ok {
+		c.Error("deletemessage", "Message not found", ErrMessageNotFound, false)
+		return
+	}
+	c.Ok("deletemessage")
 
-	if phone != "" {
-		if phone != c.phone && c.phone != "" {
-			delete(s.phones, c.phone)
+	deleted := EvSrvMessageDeleted{
+		Mid:  msg.Mid,
+		From: msg.From,
+	}
+	s.record("ev_message_deleted", deleted, msg.From, msg.To)
+	m, err := json.Marshal(struct {
+		Action string              `json:"action"`
+		Data   EvSrvMessageDeleted `json:"data"`
+	}{
+		Action: "ev_message_deleted",
+		Data:   deleted,
+	})
+	if !c.CheckError(err, "Can't marhsal answer") {
+		return
+	}
+	if user, ok := s.GetUserData(msg.To); ok {
+		user.ReplaceOfflineMessage(mid, nil)
+		user.Send(m)
+	}
+	if msg.From != c.cid {
+		if user, ok := s.GetUserData(msg.From); ok {
+			user.Send(m)
 		}
-		c.phone = phone
-		s.phones[phone] = c.login
 	}
+	if msg.To != c.cid {
+		c.Send(m)
+	}
+}
+
+// React user adds or removes emoji reaction to message
+func (s *MessageServer) React(c *Client, mid string, emoji string, remove bool) {
+	if emoji == "" {
+		c.Error("react", "Emoji is empty", ErrEmptyField, false)
+		return
+	}
+	if len(emoji) > maxEmojiLen || !validEmoji(emoji) {
+		c.Error("react", "Invalid emoji", ErrInvalidData, false)
+		return
+	}
+	msg, ok := s.history.Get(mid)
+	if !ok || msg.Deleted || !msg.Visible(c.cid) {
+		c.Error("react", "Message not found", ErrMessageNotFound, false)
+		return
+	}
+	count, changed, ok := s.history.React(mid, c.cid, emoji, remove)
+	if !ok {
+		c.Error("react", "Message not found", ErrMessageNotFound, false)
+		return
+	}
+	if !changed && remove {
+		c.Error("react", "Reaction not found", ErrInvalidData, false)
+		return
+	}
+	c.Ok("react")
+	// Repeated reaction changes nothing
+	if !changed {
+		return
+	}
+
+	reaction := EvSrvReaction{
+		Mid:     mid,
+		From:    c.cid,
+		Emoji:   emoji,
+		Removed: remove,
+		Count:   count,
+	}
+	s.record("ev_reaction", reaction, msg.From, msg.To)
+	m, err := json.Marshal(struct {
+		Action string        `json:"action"`
+		Data   EvSrvReaction `json:"data"`
+	}{
+		Action: "ev_reaction",
+		Data:   reaction,
+	})
+	if !c.CheckError(err, "Can't marhsal answer") {
+		return
+	}
+	peer := msg.To
+	if peer == c.cid {
+		peer = msg.From
+	}
+	if user, ok := s.GetUserData(peer); ok {
+		user.Send(m)
+	}
+}
+
+// GetHistory sends to user messages of dialog with another user
+func (s *MessageServer) GetHistory(c *Client, uid string, before string, limit int) {
+	if _, ok := s.profile(uid); !ok {
+		c.Error("history", "User not found", ErrUserNotFound, false)
+		return
+	}
+	if limit <= 0 || limit > s.config.HistoryLimit {
+		limit = s.config.HistoryLimit
+	}
+
+	list := SrvHistory{}
+	list.Status = ErrOK
+	list.Error = "OK"
+	list.Messages = s.history.Dialog(c.cid, uid, before, limit)
+
+	m, err := json.Marshal(struct {
+		Action string     `json:"action"`
+		Data   SrvHistory `json:"data"`
+	}{
+		Action: "history",
+		Data:   list,
+	})
+	if !c.CheckError(err, "Can't marhsal answer") {
+		return
+	}
+	c.Send(m)
+}
+
+// SearchMessages sends to user messages of his dialogs found by query
+func (s *MessageServer) SearchMessages(c *Client, query string, filter SearchFilter, limit int) {
+	if len(Tokenize(query)) == 0 {
+		c.Error("searchmessages", "Query is empty", ErrEmptyField, false)
+		return
+	}
+	if limit <= 0 || limit > s.config.SearchLimit {
+		limit = s.config.SearchLimit
+	}
+
+	list := SrvHistory{}
+	list.Status = ErrOK
+	list.Error = "OK"
+	list.Messages = s.history.Search(c.cid, query, filter, limit)
+
+	m, err := json.Marshal(struct {
+		Action string     `json:"action"`
+		Data   SrvHistory `json:"data"`
+	}{
+		Action: "searchmessages",
+		Data:   list,
+	})
+	if !c.CheckError(err, "Can't marhsal answer") {
+		return
+	}
+	c.Send(m)
+}
+
+// UpdateUserData - update email and phone. They are stored in normalized form.
+// New email or phone gets verification code, it is used by FindUser only after Verify.
+// Email or phone verified by another user is rejected.
+func (s *MessageServer) UpdateUserData(c *Client, email string, phone string) (int, error) {
+	email, emailValid := s.cleanAddress(VerifyEmail, email)
+	phone, phoneValid := s.cleanAddress(VerifyPhone, phone)
+	if owner, ok := s.Owner(VerifyEmail, email); emailValid && ok && owner != c.login {
+		return ErrAlreadyExist, errors.New("Email already was used")
+	}
+	if owner, ok := s.Owner(VerifyPhone, phone); phoneValid && ok && owner != c.login {
+		return ErrAlreadyExist, errors.New("Phone already was used")
+	}
+
+	if old, _ := c.address(VerifyEmail); email != "" && email != old {
+		s.Release(VerifyEmail, old, c.login)
+		c.setAddress(VerifyEmail, email, false)
+		s.verifier.Cancel(c.login, VerifyEmail)
+		if emailValid {
+			s.startVerification(c, VerifyEmail, email)
+		}
+	}
+	if old, _ := c.address(VerifyPhone); phone != "" && phone != old {
+		s.Release(VerifyPhone, old, c.login)
+		c.setAddress(VerifyPhone, phone, false)
+		s.verifier.Cancel(c.login, VerifyPhone)
+		if phoneValid {
+			s.startVerification(c, VerifyPhone, phone)
+		}
+	}
+	s.saveProfile(c)
+	return ErrOK, nil
+}
+
+// cleanAddress normalizes email or phone, invalid one is only trimmed
+func (s *MessageServer) cleanAddress(kind string, address string) (string, bool) {
+	normalized, valid := "", false
+	if kind == VerifyEmail {
+		normalized, valid = NormalizeEmail(address)
+	} else {
+		normalized, valid = s.normalizePhone(address)
+	}
+	if !valid {
+		return strings.TrimSpace(address), false
+	}
+	return normalized, true
+}
+
+// saveProfile writes profile of session to account of user
+func (s *MessageServer) saveProfile(c *Client) {
+	profile := c.account()
+	s.UpdateAccount(c.login, func(a *Account) {
+		a.Status = profile.Status
+		a.Avatar = profile.Avatar
+		a.Email = profile.Email
+		a.Phone = profile.Phone
+		a.EmailVerified = profile.EmailVerified
+		a.PhoneVerified = profile.PhoneVerified
+		a.Contacts = make(map[string]string, len(c.contacts))
+		for k, v := range c.contacts {
+			a.Contacts[k] = v
+		}
+	})
 }
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"strings"
 	"testing"
 	"time"
 )
@@ -149,14 +150,14 @@ func TestServerGetUserInfo(t *testing.T) {
 	phone := "+7999123123123"
 	status := "Test State"
 
-	ansOk := "{\"action\":\"userinfo\",\"data\":{\"nick\":\"user1\",\"user_status\":\"Test State\",\"email\":\"test@mail.ru\",\"phone\":\"+7999123123123\",\"picture\":\"Base64_Picture\",\"status\":0,\"error\":\"OK\"}}"
+	ansOk := "{\"action\":\"userinfo\",\"data\":{\"nick\":\"user1\",\"user_status\":\"Test State\",\"email\":\"test@mail.ru\",\"phone\":\"+7999123123123\",\"picture\":\"Base64_Picture\",\"role\":\"user\",\"status\":0,\"error\":\"OK\"}}"
 	ansNotFound := "{\"action\":\"userinfo\",\"data\":{\"status\":8,\"error\":\"User not found\"}}"
 
 	c1.Auth("user", "pass")
 	c1.SetUserInfo(ava, mail, phone, status)
 
 	gServer.GetUserInfo(c, c1.uid)
-	c.outgoing <- []byte("")
+	c.Flush()
 
 	err := conn.CheckLastMessage(t, ansOk)
 	if nil != err {
@@ -190,6 +191,8 @@ func TestServerFindUser(t *testing.T) {
 
 	c1.SetUserInfo("", mail1, phone1, "")
 	c2.SetUserInfo("", mail2, phone2, "")
+	verifyContacts(c1)
+	verifyContacts(c2)
 
 	c, ok := gServer.FindUser("", "")
 	if ok {
@@ -266,6 +269,7 @@ func TestServerSendMessage(t *testing.T) {
 
 		gServer.Register(tmp.client, tmp.login, tmp.pass, tmp.nick)
 		tmp.client.Auth(tmp.login, tmp.pass)
+		tmp.client.Flush()
 
 		testOk := fmt.Sprintf(
 			"{\"action\":\"auth\",\"data\":{\"sid\":\"%s\",\"cid\":\"%s\",\"nick\":\"%s\",\"status\":0,\"error\":\"OK\"}}",
@@ -294,10 +298,10 @@ func TestServerSendMessage(t *testing.T) {
 	ansOk := "{\"action\":\"message\",\"data\":{\"status\":0,\"error\":\"OK\"}}"
 	ansEmpy := "{\"action\":\"message\",\"data\":{\"status\":4,\"error\":\"Body is empty\"}}"
 	ansInvUser := "{\"action\":\"message\",\"data\":{\"status\":8,\"error\":\"Invalid user\"}}"
-	ansMessTmpl := "{\"action\":\"ev_message\",\"data\":{\"from\":\"%s\",\"nick\":\"%s\",\"body\":\"%s\",\"time\":%v,\"attach\":{\"mime\":\"%s\",\"data\":\"%s\"}}}"
+	ansMessTmpl := "{\"action\":\"ev_message\",\"data\":{\"mid\":\"%v\",\"from\":\"%s\",\"nick\":\"%s\",\"body\":\"%s\",\"time\":%v,\"attach\":{\"mime\":\"%s\",\"data\":\"%s\"}}}"
 
 	// Check empty body
-	gServer.SendMessage(c1.client, c2.client.uid, "", testAttaches[0])
+	gServer.SendMessage(c1.client, c2.client.uid, "", testAttaches[0], MessageLinks{}, nil)
 	err := c1.conn.CheckLastMessage(t, ansEmpy)
 	if nil != err {
 		t.Errorf(err.Error())
@@ -307,7 +311,7 @@ func TestServerSendMessage(t *testing.T) {
 	}
 
 	// Check invalid user
-	gServer.SendMessage(c1.client, "invalid", testMess, testAttaches[0])
+	gServer.SendMessage(c1.client, "invalid", testMess, testAttaches[0], MessageLinks{}, nil)
 	err = c1.conn.CheckLastMessage(t, ansInvUser)
 	if nil != err {
 		t.Errorf(err.Error())
@@ -317,9 +321,11 @@ func TestServerSendMessage(t *testing.T) {
 	}
 
 	// Check normal message to online
-	gServer.SendMessage(c1.client, c2.client.uid, testMess, testAttaches[0])
+	gServer.SendMessage(c1.client, c2.client.uid, testMess, testAttaches[0], MessageLinks{}, nil)
+	c1.client.Flush()
+	c2.client.Flush()
 
-	mess := fmt.Sprintf(ansMessTmpl, c1.login, c1.nick, testMess, int(time.Now().Unix()),
+	mess := fmt.Sprintf(ansMessTmpl, 1, c1.login, c1.nick, testMess, int(time.Now().Unix()),
 		testAttaches[0].Mime, testAttaches[0].Data)
 	err = c1.conn.CheckLastMessage(t, mess)
 	if nil != err {
@@ -334,8 +340,10 @@ func TestServerSendMessage(t *testing.T) {
 		t.Errorf(err.Error())
 	}
 
-	gServer.SendMessage(c1.client, c2.client.uid, testMess, testAttaches[1])
-	mess = fmt.Sprintf(ansMessTmpl, c1.login, c1.nick, testMess, int(time.Now().Unix()),
+	gServer.SendMessage(c1.client, c2.client.uid, testMess, testAttaches[1], MessageLinks{}, nil)
+	c1.client.Flush()
+	c2.client.Flush()
+	mess = fmt.Sprintf(ansMessTmpl, 2, c1.login, c1.nick, testMess, int(time.Now().Unix()),
 		testAttaches[1].Mime, testAttaches[1].Data)
 	err = c1.conn.CheckLastMessage(t, mess)
 	if nil != err {
@@ -350,8 +358,10 @@ func TestServerSendMessage(t *testing.T) {
 		t.Errorf(err.Error())
 	}
 
-	gServer.SendMessage(c1.client, c2.client.uid, testMess, testAttaches[2])
-	mess = fmt.Sprintf(ansMessTmpl, c1.login, c1.nick, testMess, int(time.Now().Unix()),
+	gServer.SendMessage(c1.client, c2.client.uid, testMess, testAttaches[2], MessageLinks{}, nil)
+	c1.client.Flush()
+	c2.client.Flush()
+	mess = fmt.Sprintf(ansMessTmpl, 3, c1.login, c1.nick, testMess, int(time.Now().Unix()),
 		testAttaches[2].Mime, testAttaches[2].Data)
 	err = c1.conn.CheckLastMessage(t, mess)
 	if nil != err {
@@ -368,10 +378,12 @@ func TestServerSendMessage(t *testing.T) {
 
 	// Check normal message to offline
 	c3.client.Disconnect()
-	mess = fmt.Sprintf(ansMessTmpl, c1.login, c1.nick, testMess, int(time.Now().Unix()),
+	mess = fmt.Sprintf(ansMessTmpl, 4, c1.login, c1.nick, testMess, int(time.Now().Unix()),
 		testAttaches[3].Mime, testAttaches[3].Data)
 
-	gServer.SendMessage(c1.client, c2.client.uid, testMess, testAttaches[3])
+	gServer.SendMessage(c1.client, c2.client.uid, testMess, testAttaches[3], MessageLinks{}, nil)
+	c1.client.Flush()
+	c2.client.Flush()
 	err = c1.conn.CheckLastMessage(t, mess)
 	if nil != err {
 		t.Errorf(err.Error())
@@ -383,7 +395,7 @@ func TestServerSendMessage(t *testing.T) {
 	conn := newTestConn()
 	c := NewTestClient(conn)
 	c.Auth(c3.login, c3.pass)
-	c.outgoing <- []byte("")
+	c.Flush()
 	err = conn.CheckLastMessage(t, mess)
 	if nil != err {
 		t.Errorf(err.Error())
@@ -393,3 +405,278 @@ func TestServerSendMessage(t *testing.T) {
 		t.Errorf("Offline messages didn't clear")
 	}
 }
+
+// TestServerEditMessage checks Server.EditMessage and Server.DeleteMessage
+func TestServerEditMessage(t *testing.T) {
+	gServer = newServer()
+
+	conn1 := newTestConn()
+	conn3 := newTestConn()
+	c1 := NewTestClient(conn1)
+	c2 := NewTestClient(newTestConn())
+	c3 := NewTestClient(conn3)
+	gServer.Register(c1, "user1", "pass", "nick1")
+	gServer.Register(c2, "user2", "pass", "nick2")
+	gServer.Register(c3, "user3", "pass", "nick3")
+	c1.Auth("user1", "pass")
+	c2.Auth("user2", "pass")
+	c3.Auth("user3", "pass")
+
+	// Recipient is offline
+	c2.Flush()
+	c2.Disconnect()
+	gServer.SendMessage(c1, c2.uid, "Test", AttachData{}, MessageLinks{}, nil)
+	gServer.SendMessage(c1, c2.uid, "Test2", AttachData{}, MessageLinks{}, nil)
+
+	ansEdited := "{\"action\":\"ev_message_edited\",\"data\":{\"mid\":\"1\",\"from\":\"user1\",\"body\":\"Edited\",\"time\":%v}}"
+	ansDeleted := "{\"action\":\"ev_message_deleted\",\"data\":{\"mid\":\"2\",\"from\":\"user1\"}}"
+	ansNotFound := "{\"action\":\"%s\",\"data\":{\"status\":9,\"error\":\"Message not found\"}}"
+	ansDenied := "{\"action\":\"editmessage\",\"data\":{\"status\":10,\"error\":\"Only author can change message\"}}"
+	ansExpired := "{\"action\":\"deletemessage\",\"data\":{\"status\":11,\"error\":\"Time for changes is over\"}}"
+
+	gServer.EditMessage(c3, "1", "Edited")
+	err := conn3.CheckLastMessage(t, ansDenied)
+	if nil != err {
+		t.Errorf("%v", err)
+	}
+
+	gServer.EditMessage(c1, "1", "Edited")
+	c1.Flush()
+	err = conn1.CheckLastMessage(t, fmt.Sprintf(ansEdited, int(time.Now().Unix())))
+	if nil != err {
+		t.Errorf("%v", err)
+	}
+
+	gServer.DeleteMessage(c1, "2")
+	c1.Flush()
+	err = conn1.CheckLastMessage(t, ansDeleted)
+	if nil != err {
+		t.Errorf("%v", err)
+	}
+
+	gServer.DeleteMessage(c1, "2")
+	err = conn1.CheckLastMessage(t, fmt.Sprintf(ansNotFound, "deletemessage"))
+	if nil != err {
+		t.Errorf("%v", err)
+	}
+	gServer.EditMessage(c1, "unknown", "Edited")
+	err = conn1.CheckLastMessage(t, fmt.Sprintf(ansNotFound, "editmessage"))
+	if nil != err {
+		t.Errorf("%v", err)
+	}
+
+	// Offline copy was edited and deleted copy was removed
+	c2.Flush()
+	msg, _ := gServer.history.Get("1")
+	mess := fmt.Sprintf("{\"action\":\"ev_message\",\"data\":{\"mid\":\"1\",\"from\":\"user1\",\"nick\":\"nick1\",\"body\":\"Edited\",\"time\":%v,\"attach\":{\"mime\":\"\",\"data\":\"\"}}}", msg.Time)
+	if string(c2.offlineMessages[0]) != mess {
+		t.Errorf("Invalid offline message (%s) instead (%s)", c2.offlineMessages[0], mess)
+	}
+	for _, queued := range c2.offlineMessages {
+		if strings.HasPrefix(string(queued), "{\"action\":\"ev_message\",\"data\":{\"mid\":\"2\"") {
+			t.Errorf("Deleted message is still in offline queue")
+		}
+	}
+
+	gServer.config.EditWindow = 0
+	gServer.SendMessage(c1, c2.uid, "Test3", AttachData{}, MessageLinks{}, nil)
+	time.Sleep(time.Millisecond)
+	gServer.DeleteMessage(c1, "3")
+	err = conn1.CheckLastMessage(t, ansExpired)
+	if nil != err {
+		t.Errorf("%v", err)
+	}
+}
+
+// TestServerSendMessageLinks checks replies and forwarding in Server.SendMessage
+func TestServerSendMessageLinks(t *testing.T) {
+	gServer = newServer()
+
+	conn1 := newTestConn()
+	conn2 := newTestConn()
+	conn3 := newTestConn()
+	c1 := NewTestClient(conn1)
+	c2 := NewTestClient(conn2)
+	c3 := NewTestClient(conn3)
+	gServer.Register(c1, "user1", "pass", "nick1")
+	gServer.Register(c2, "user2", "pass", "nick2")
+	gServer.Register(c3, "user3", "pass", "nick3")
+	c1.Auth("user1", "pass")
+	c2.Auth("user2", "pass")
+	c3.Auth("user3", "pass")
+
+	ansReplyNotFound := "{\"action\":\"message\",\"data\":{\"status\":9,\"error\":\"Replied message not found\"}}"
+	ansFwdNotFound := "{\"action\":\"message\",\"data\":{\"status\":9,\"error\":\"Forwarded message not found\"}}"
+	ansReply := "{\"action\":\"ev_message\",\"data\":{\"mid\":\"2\",\"from\":\"user2\",\"nick\":\"nick2\",\"body\":\"Reply\",\"time\":%v,\"attach\":{\"mime\":\"\",\"data\":\"\"},\"reply_to\":\"1\"}}"
+	ansFwd := "{\"action\":\"ev_message\",\"data\":{\"mid\":\"%v\",\"from\":\"user2\",\"nick\":\"nick2\",\"body\":\"Hello\",\"time\":%v,\"attach\":{\"mime\":\"txt\",\"data\":\"Text\"},\"forwarded_from\":{\"mid\":\"1\",\"from\":\"user1\",\"nick\":\"nick1\"}}}"
+
+	gServer.SendMessage(c1, c2.uid, "Hello", AttachData{"txt", "Text"}, MessageLinks{}, nil)
+
+	// Reply to message from another dialog
+	gServer.SendMessage(c3, c2.uid, "Reply", AttachData{}, MessageLinks{ReplyTo: "1"}, nil)
+	err := conn3.CheckLastMessage(t, ansReplyNotFound)
+	if nil != err {
+		t.Errorf("%v", err)
+	}
+
+	gServer.SendMessage(c2, c1.uid, "Reply", AttachData{}, MessageLinks{ReplyTo: "1"}, nil)
+	c2.Flush()
+	err = conn2.CheckLastMessage(t, fmt.Sprintf(ansReply, int(time.Now().Unix())))
+	if nil != err {
+		t.Errorf("%v", err)
+	}
+
+	// Forward of message which user didn't see
+	gServer.SendMessage(c3, c2.uid, "", AttachData{}, MessageLinks{ForwardedFrom: "1"}, nil)
+	err = conn3.CheckLastMessage(t, ansFwdNotFound)
+	if nil != err {
+		t.Errorf("%v", err)
+	}
+
+	gServer.SendMessage(c2, c3.uid, "", AttachData{}, MessageLinks{ForwardedFrom: "1"}, nil)
+	c3.Flush()
+	err = conn3.CheckLastMessage(t, fmt.Sprintf(ansFwd, 3, int(time.Now().Unix())))
+	if nil != err {
+		t.Errorf("%v", err)
+	}
+
+	// Forward with own text is rejected, comment is sent as separate message
+	gServer.SendMessage(c2, c3.uid, "Fake", AttachData{}, MessageLinks{ForwardedFrom: "1"}, nil)
+	c2.Flush()
+	err = conn2.CheckLastMessage(t, "{\"action\":\"message\",\"data\":{\"status\":3,\"error\":\"Forwarded message can't be changed\"}}")
+	if nil != err {
+		t.Errorf("%v", err)
+	}
+
+	// Forward of forwarded message keeps original author
+	gServer.SendMessage(c3, c2.uid, "", AttachData{}, MessageLinks{ForwardedFrom: "3"}, nil)
+	msg, ok := gServer.history.Get("4")
+	if !ok || msg.Forwarded == nil || msg.Forwarded.Mid != "1" || msg.Forwarded.From != "user1" {
+		t.Errorf("Invalid forwarded message %v", msg)
+	}
+}
+
+// TestServerReact checks Server.React and Server.GetHistory
+func TestServerReact(t *testing.T) {
+	gServer = newServer()
+
+	conn1 := newTestConn()
+	conn2 := newTestConn()
+	conn3 := newTestConn()
+	c1 := NewTestClient(conn1)
+	c2 := NewTestClient(conn2)
+	c3 := NewTestClient(conn3)
+	gServer.Register(c1, "user1", "pass", "nick1")
+	gServer.Register(c2, "user2", "pass", "nick2")
+	gServer.Register(c3, "user3", "pass", "nick3")
+	c1.Auth("user1", "pass")
+	c2.Auth("user2", "pass")
+	c3.Auth("user3", "pass")
+
+	gServer.SendMessage(c1, c2.uid, "Hello", AttachData{}, MessageLinks{}, nil)
+
+	ansEmpty := "{\"action\":\"react\",\"data\":{\"status\":4,\"error\":\"Emoji is empty\"}}"
+	ansNotFound := "{\"action\":\"react\",\"data\":{\"status\":9,\"error\":\"Message not found\"}}"
+	ansOk := "{\"action\":\"react\",\"data\":{\"status\":0,\"error\":\"OK\"}}"
+	evReaction := "{\"action\":\"ev_reaction\",\"data\":{\"mid\":\"1\",\"from\":\"user2\",\"emoji\":\"👍\",\"removed\":false,\"count\":1}}"
+	ansHistory := "{\"action\":\"history\",\"data\":{\"list\":[{\"mid\":\"1\",\"from\":\"user1\",\"to\":\"user2\",\"nick\":\"nick1\",\"body\":\"Hello\",\"time\":%v,\"attach\":{\"mime\":\"\",\"data\":\"\"},\"reactions\":[{\"emoji\":\"👍\",\"count\":1}]}],\"status\":0,\"error\":\"OK\"}}"
+	ansInvalid := "{\"action\":\"react\",\"data\":{\"status\":3,\"error\":\"Invalid emoji\"}}"
+	ansNoReaction := "{\"action\":\"react\",\"data\":{\"status\":3,\"error\":\"Reaction not found\"}}"
+	ansEmptyHistory := "{\"action\":\"history\",\"data\":{\"list\":[],\"status\":0,\"error\":\"OK\"}}"
+
+	gServer.React(c2, "1", "", false)
+	err := conn2.CheckLastMessage(t, ansEmpty)
+	if nil != err {
+		t.Errorf("%v", err)
+	}
+
+	for _, emoji := range []string{"+1", "ok", "👍👎"} {
+		gServer.React(c2, "1", emoji, false)
+		if err := conn2.CheckLastMessage(t, ansInvalid); err != nil {
+			t.Errorf("React(%s) - %v", emoji, err)
+		}
+	}
+
+	// Not a participant of dialog
+	gServer.React(c3, "1", "👍", false)
+	err = conn3.CheckLastMessage(t, ansNotFound)
+	if nil != err {
+		t.Errorf("%v", err)
+	}
+
+	gServer.React(c2, "1", "👍", false)
+	c1.Flush()
+	c2.Flush()
+	err = conn1.CheckLastMessage(t, evReaction)
+	if nil != err {
+		t.Errorf("%v", err)
+	}
+	err = conn2.CheckLastMessage(t, ansOk)
+	if nil != err {
+		t.Errorf("%v", err)
+	}
+
+	// Repeated reaction and removing of missing reaction have no events
+	events := len(conn1.Messages)
+	gServer.React(c2, "1", "👍", false)
+	c2.Flush()
+	if err := conn2.CheckLastMessage(t, ansOk); err != nil {
+		t.Errorf("%v", err)
+	}
+	gServer.React(c2, "1", "👎", true)
+	c2.Flush()
+	if err := conn2.CheckLastMessage(t, ansNoReaction); err != nil {
+		t.Errorf("%v", err)
+	}
+	c1.Flush()
+	if len(conn1.Messages) != events {
+		t.Errorf("Event of unchanged reactions %v", conn1.Messages[events:])
+	}
+
+	msg, _ := gServer.history.Get("1")
+	gServer.GetHistory(c1, c2.uid, "", 0)
+	c1.Flush()
+	err = conn1.CheckLastMessage(t, fmt.Sprintf(ansHistory, msg.Time))
+	if nil != err {
+		t.Errorf("%v", err)
+	}
+
+	gServer.GetHistory(c3, c2.uid, "", 0)
+	c3.Flush()
+	err = conn3.CheckLastMessage(t, ansEmptyHistory)
+	if nil != err {
+		t.Errorf("%v", err)
+	}
+}
+
+// TestServerSearchMessages checks Server.SearchMessages
+func TestServerSearchMessages(t *testing.T) {
+	gServer = newServer()
+
+	conn1 := newTestConn()
+	c1 := NewTestClient(conn1)
+	c2 := NewTestClient(newTestConn())
+	gServer.Register(c1, "user1", "pass", "nick1")
+	gServer.Register(c2, "user2", "pass", "nick2")
+	c1.Auth("user1", "pass")
+	c2.Auth("user2", "pass")
+
+	gServer.SendMessage(c2, c1.uid, "Привет", AttachData{}, MessageLinks{}, nil)
+	msg, _ := gServer.history.Get("1")
+
+	ansEmpty := "{\"action\":\"searchmessages\",\"data\":{\"status\":4,\"error\":\"Query is empty\"}}"
+	ansOk := "{\"action\":\"searchmessages\",\"data\":{\"list\":[{\"mid\":\"1\",\"from\":\"user2\",\"to\":\"user1\",\"nick\":\"nick2\",\"body\":\"Привет\",\"time\":%v,\"attach\":{\"mime\":\"\",\"data\":\"\"}}],\"status\":0,\"error\":\"OK\"}}"
+
+	gServer.SearchMessages(c1, " ", SearchFilter{}, 0)
+	err := conn1.CheckLastMessage(t, ansEmpty)
+	if nil != err {
+		t.Errorf("%v", err)
+	}
+
+	gServer.SearchMessages(c1, "ПРИВЕТ", SearchFilter{}, 0)
+	c1.Flush()
+	err = conn1.CheckLastMessage(t, fmt.Sprintf(ansOk, msg.Time))
+	if nil != err {
+		t.Errorf("%v", err)
+	}
+}
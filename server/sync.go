@@ -0,0 +1,172 @@
+package server
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SyncEvent is an event of user kept for catch-up of his devices
+type SyncEvent struct {
+	Seq    int             `json:"seq"`
+	Action string          `json:"action"`
+	Data   json.RawMessage `json:"data"`
+	Time   int             `json:"time"`
+}
+
+// userEvents is a tail of events of one user
+type userEvents struct {
+	seq    int         // Sequence number of last event
+	events []SyncEvent // Events in order of sequence numbers without gaps
+}
+
+// EventLog numbers events of each user and keeps the last ones
+type EventLog struct {
+	mutex sync.Mutex
+	size  int                    // Max count of kept events of one user (0 - no limit)
+	users map[string]*userEvents // map key - uid
+}
+
+// NewEventLog is constructor of EventLog
+func NewEventLog(size int) *EventLog {
+	return &EventLog{size: size, users: make(map[string]*userEvents)}
+}
+
+// Append adds event of user and returns its sequence number
+func (l *EventLog) Append(uid string, action string, data interface{}) (int, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return 0, err
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	u, ok := l.users[uid]
+	if !ok {
+		u = &userEvents{}
+		l.users[uid] = u
+	}
+	u.seq++
+	u.events = append(u.events, SyncEvent{Seq: u.seq, Action: action, Data: raw, Time: int(time.Now().Unix())})
+	if l.size > 0 && len(u.events) > l.size {
+		// Old part of array is freed when append moves events to new one
+		u.events = u.events[len(u.events)-l.size:]
+	}
+	return u.seq, nil
+}
+
+// Since returns up to limit events of user after sequence number seq and sequence number
+// of last returned event. Result is false if events after seq are lost: they are
+// dropped by size of log or seq is unknown, e.g. after restart of server.
+func (l *EventLog) Since(uid string, seq int, limit int) ([]SyncEvent, int, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	events := make([]SyncEvent, 0)
+	u, ok := l.users[uid]
+	if !ok {
+		return events, 0, seq == 0
+	}
+	first := u.seq + 1
+	if len(u.events) > 0 {
+		first = u.events[0].Seq
+	}
+	if seq > u.seq || seq < first-1 {
+		return events, u.seq, false
+	}
+	tail := u.events[seq-first+1:]
+	if limit > 0 && len(tail) > limit {
+		tail = tail[:limit]
+	}
+	events = append(events, tail...)
+	if len(events) == 0 {
+		return events, u.seq, true
+	}
+	return events, events[len(events)-1].Seq, true
+}
+
+// Remove removes events of user
+func (l *EventLog) Remove(uid string) {
+	l.mutex.Lock()
+	delete(l.users, uid)
+	l.mutex.Unlock()
+}
+
+// record adds event to logs of users, bots get events only by bot API
+func (s *MessageServer) record(action string, data interface{}, uids ...string) {
+	recorded := make(map[string]bool, len(uids))
+	for _, uid := range uids {
+		// Author and recipient are the same user in dialog with himself
+		if recorded[uid] || s.IsBot(uid) {
+			continue
+		}
+		recorded[uid] = true
+		if _, err := s.events.Append(uid, action, data); err != nil {
+			logger(LogServer).Error("Can't record event", "action", action, "err", err)
+		}
+	}
+}
+
+// recordProfile adds profile of user to his log and to logs of users who have him in contacts
+func (s *MessageServer) recordProfile(c *Client) {
+	a := c.account()
+	profile := EvSrvProfile{
+		Uid:        c.uid,
+		Nick:       a.Nick,
+		UserStatus: a.Status,
+		Email:      a.Email,
+		Phone:      a.Phone,
+		Avatar:     a.Avatar,
+	}
+	s.record("ev_profile", profile, append([]string{c.uid}, s.ContactOf(c.uid)...)...)
+}
+
+// Sync sends to user events after sequence number seq and unread counts of his conversations.
+// If events are lost, answer has reset flag and client loads state again by
+// contactlist, conversations and history.
+func (s *MessageServer) Sync(c *Client, seq int, limit int) {
+	if seq < 0 {
+		c.Error("sync", "Invalid sequence number", ErrInvalidData, false)
+		return
+	}
+	if s.config.SyncLimit > 0 && (limit <= 0 || limit > s.config.SyncLimit) {
+		limit = s.config.SyncLimit
+	}
+
+	answer := SrvSync{Unread: make([]UnreadData, 0)}
+	var complete bool
+	if limit > 0 {
+		// One more event shows that there are more events
+		answer.Events, answer.Seq, complete = s.events.Since(c.cid, seq, limit+1)
+	} else {
+		answer.Events, answer.Seq, complete = s.events.Since(c.cid, seq, 0)
+	}
+	answer.Reset = !complete
+	if limit > 0 && len(answer.Events) > limit {
+		answer.Events = answer.Events[:limit]
+		answer.Seq = answer.Events[limit-1].Seq
+		answer.More = true
+	}
+	for _, conv := range s.conversations.List(c.cid) {
+		if n := s.history.Unread(c.cid, conv.Peer, conv.ReadMid); n > 0 {
+			answer.Unread = append(answer.Unread, UnreadData{Uid: conv.Peer, Unread: n})
+		}
+	}
+	sort.Slice(answer.Unread, func(i, j int) bool { return answer.Unread[i].Uid < answer.Unread[j].Uid })
+	answer.Status = ErrOK
+	answer.Error = "OK"
+
+	m, err := json.Marshal(struct {
+		Action string  `json:"action"`
+		Data   SrvSync `json:"data"`
+	}{
+		Action: "sync",
+		Data:   answer,
+	})
+	if !c.CheckError(err, "Can't marhsal answer") {
+		return
+	}
+	c.Send(m)
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// lastSync decodes last answer to sync
+func lastSync(t *testing.T, c *Client, conn *testConn) SrvSync {
+	c.Flush()
+	var m struct {
+		Action string  `json:"action"`
+		Data   SrvSync `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(conn.Messages[len(conn.Messages)-1]), &m); err != nil {
+		t.Fatalf("Invalid answer: %v", err)
+	}
+	if m.Action != "sync" || m.Data.Status != ErrOK {
+		t.Fatalf("Invalid answer: %s", conn.Messages[len(conn.Messages)-1])
+	}
+	return m.Data
+}
+
+// actions returns actions of events
+func actions(events []SyncEvent) []string {
+	list := make([]string, 0, len(events))
+	for _, ev := range events {
+		list = append(list, ev.Action)
+	}
+	return list
+}
+
+// TestEventLog checks numbering and dropping of events
+func TestEventLog(t *testing.T) {
+	l := NewEventLog(3)
+	if events, seq, ok := l.Since("user", 0, 0); !ok || seq != 0 || len(events) != 0 {
+		t.Errorf("Invalid empty log %v %d %v", events, seq, ok)
+	}
+	for i := 1; i <= 5; i++ {
+		if seq, err := l.Append("user", "ev_contact_added", EvSrvContact{Uid: "user0"}); err != nil || seq != i {
+			t.Errorf("Invalid sequence number %d, waits %d (%v)", seq, i, err)
+		}
+	}
+	l.Append("other", "ev_contact_added", EvSrvContact{Uid: "user"})
+
+	events, seq, ok := l.Since("user", 2, 0)
+	if !ok || seq != 5 || len(events) != 3 || events[0].Seq != 3 || string(events[0].Data) != `{"uid":"user0"}` {
+		t.Errorf("Invalid events after 2: %v %d %v", events, seq, ok)
+	}
+	if events, seq, ok = l.Since("user", 3, 1); !ok || seq != 4 || len(events) != 1 {
+		t.Errorf("Invalid events with limit: %v %d %v", events, seq, ok)
+	}
+	if events, seq, ok = l.Since("user", 5, 0); !ok || seq != 5 || len(events) != 0 {
+		t.Errorf("Invalid events of synced user: %v %d %v", events, seq, ok)
+	}
+	// Dropped and unknown events
+	if _, seq, ok = l.Since("user", 1, 0); ok || seq != 5 {
+		t.Errorf("Dropped events are not detected")
+	}
+	if _, seq, ok = l.Since("user", 7, 0); ok || seq != 5 {
+		t.Errorf("Unknown sequence number is not detected")
+	}
+	l.Remove("user")
+	if _, _, ok = l.Since("user", 5, 0); ok {
+		t.Errorf("Events are not removed")
+	}
+}
+
+// TestServerSync checks catch-up of events missed by user
+func TestServerSync(t *testing.T) {
+	gServer = newServer()
+	conns := []*testConn{newTestConn(), newTestConn()}
+	users := make([]*Client, len(conns))
+	for i, login := range []string{"user1", "user2"} {
+		users[i] = NewTestClient(conns[i])
+		gServer.Register(users[i], login, "pass", login)
+		users[i].Auth(login, "pass")
+	}
+
+	gServer.Sync(users[1], 0, 0)
+	start := lastSync(t, users[1], conns[1])
+	if start.Reset || start.Seq != 0 || len(start.Events) != 0 || len(start.Unread) != 0 {
+		t.Errorf("Invalid first sync %+v", start)
+	}
+
+	users[1].AddContact("user1")
+	users[0].SetUserInfo("ava", "", "", "online")
+	gServer.SendMessage(users[0], "user2", "hello", AttachData{}, MessageLinks{}, nil)
+	gServer.SendMessage(users[0], "user2", "world", AttachData{}, MessageLinks{}, nil)
+	gServer.EditMessage(users[0], "1", "hi")
+	gServer.DeleteMessage(users[0], "2")
+	users[1].DelContact("user1")
+
+	gServer.Sync(users[1], 0, 0)
+	answer := lastSync(t, users[1], conns[1])
+	waits := []string{"ev_contact_added", "ev_profile", "ev_message", "ev_message", "ev_message_edited", "ev_message_deleted", "ev_contact_removed"}
+	if got := actions(answer.Events); len(got) != len(waits) {
+		t.Fatalf("Invalid events %v, waits %v", got, waits)
+	} else {
+		for i := range waits {
+			if got[i] != waits[i] || answer.Events[i].Seq != i+1 {
+				t.Errorf("Event %d is %s (seq %d), waits %s", i, got[i], answer.Events[i].Seq, waits[i])
+			}
+		}
+	}
+	if answer.Seq != len(waits) || answer.More || answer.Reset {
+		t.Errorf("Invalid cursor %+v", answer)
+	}
+	var profile EvSrvProfile
+	if json.Unmarshal(answer.Events[1].Data, &profile) != nil || profile.Uid != "user1" || profile.UserStatus != "online" {
+		t.Errorf("Invalid profile event %s", answer.Events[1].Data)
+	}
+	if len(answer.Unread) != 1 || answer.Unread[0] != (UnreadData{Uid: "user1", Unread: 1}) {
+		t.Errorf("Invalid unread counts %+v", answer.Unread)
+	}
+
+	// Author has own messages, profile and changes of conversation
+	gServer.MarkRead(users[1], "user1", "")
+	gServer.Sync(users[0], 0, 0)
+	if got := actions(lastSync(t, users[0], conns[0]).Events); len(got) != 5 || got[0] != "ev_profile" {
+		t.Errorf("Invalid events of author %v", got)
+	}
+	gServer.Sync(users[1], 5, 1)
+	answer = lastSync(t, users[1], conns[1])
+	if len(answer.Events) != 1 || answer.Seq != 6 || !answer.More || len(answer.Unread) != 0 {
+		t.Errorf("Invalid answer with limit %+v", answer)
+	}
+	gServer.Sync(users[1], answer.Seq, 0)
+	answer = lastSync(t, users[1], conns[1])
+	if got := actions(answer.Events); len(got) != 2 || got[1] != "ev_conversation" || answer.More {
+		t.Errorf("Invalid rest of events %v", got)
+	}
+
+	// Unknown cursor resets state of client
+	gServer.Sync(users[1], 100, 0)
+	if answer = lastSync(t, users[1], conns[1]); !answer.Reset || answer.Seq != 8 {
+		t.Errorf("Unknown cursor is accepted %+v", answer)
+	}
+	gServer.Sync(users[1], -1, 0)
+	users[1].Flush()
+	if err := conns[1].CheckLastMessage(t, `{"action":"sync","data":{"status":3,"error":"Invalid sequence number"}}`); err != nil {
+		t.Errorf("%v", err)
+	}
+}
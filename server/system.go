@@ -0,0 +1,131 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Default message of the day
+const defaultMOTD = "Happy New Year! Welcome to message server!"
+
+// Nick of server in announcements sent as ev_message
+const serverNick = "server"
+
+// errInvalidTarget is returned by Notify for unknown NotifyTarget
+var errInvalidTarget = errors.New("Invalid target of notification")
+
+// NotifyTarget is a set of receivers of system notification
+type NotifyTarget string
+
+// Receivers of system notification
+const (
+	NotifyAll    NotifyTarget = "all"    // All users, offline users get it after auth
+	NotifyOnline NotifyTarget = "online" // Only connected users
+	NotifyUsers  NotifyTarget = "users"  // Users from list, offline users get it after auth
+)
+
+// Kinds of system notifications
+const (
+	NotifyInfo        = "info"
+	NotifyWarning     = "warning"
+	NotifyMaintenance = "maintenance"
+)
+
+// SystemNotification is a message from server or its plugins
+type SystemNotification struct {
+	Kind   string       // NotifyInfo, NotifyWarning, NotifyMaintenance or custom kind
+	Text   string       // Text of notification
+	Target NotifyTarget // Receivers of notification
+	Users  []string     // UserIDs of receivers for NotifyUsers
+}
+
+// Notify sends ev_system to receivers and returns count of them
+func (s *MessageServer) Notify(n SystemNotification) (int, error) {
+	if n.Kind == "" {
+		n.Kind = NotifyInfo
+	}
+	var receivers []*Client
+	switch n.Target {
+	case NotifyAll, "":
+		receivers = s.clientList()
+	case NotifyOnline:
+		for _, c := range s.clientList() {
+			if c.isConnected() {
+				receivers = append(receivers, c)
+			}
+		}
+	case NotifyUsers:
+		for _, uid := range n.Users {
+			if c, ok := s.GetUserData(uid); ok {
+				receivers = append(receivers, c)
+			}
+		}
+	default:
+		return 0, errInvalidTarget
+	}
+
+	m, err := json.Marshal(struct {
+		Action string      `json:"action"`
+		Data   EvSrvSystem `json:"data"`
+	}{
+		Action: "ev_system",
+		Data: EvSrvSystem{
+			Id:   s.history.NewID(),
+			Kind: n.Kind,
+			Text: n.Text,
+			Time: int(time.Now().Unix()),
+		},
+	})
+	if !CheckError(err, "Can't marhsal message", false) {
+		return 0, err
+	}
+	for _, c := range receivers {
+		c.Send(m)
+	}
+	logger(LogServer).Info("System notification", "kind", n.Kind, "target", string(n.Target), "receivers", len(receivers))
+	return len(receivers), nil
+}
+
+// Broadcast sends announcement to all users as ev_message of server with empty from.
+// Returns count of receivers.
+func (s *MessageServer) Broadcast(body string) int {
+	m, err := json.Marshal(struct {
+		Action string       `json:"action"`
+		Data   EvSrvMessage `json:"data"`
+	}{
+		Action: "ev_message",
+		Data: EvSrvMessage{
+			Mid:  s.history.NewID(),
+			From: "",
+			Nick: serverNick,
+			Body: body,
+			Time: int(time.Now().Unix()),
+		},
+	})
+	if !CheckError(err, "Can't marhsal message", false) {
+		return 0
+	}
+	receivers := s.clientList()
+	for _, c := range receivers {
+		c.Send(m)
+	}
+	logger(LogServer).Info("Broadcast", "receivers", len(receivers))
+	return len(receivers)
+}
+
+// MOTD returns message of the day which is sent in welcome message
+func (s *MessageServer) MOTD() string {
+	s.adminMutex.Lock()
+	defer s.adminMutex.Unlock()
+
+	return s.motd
+}
+
+// SetMOTD changes message of the day for new connections
+func (s *MessageServer) SetMOTD(motd string) {
+	s.adminMutex.Lock()
+	defer s.adminMutex.Unlock()
+
+	s.motd = motd
+}
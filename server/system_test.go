@@ -0,0 +1,85 @@
+package server
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+)
+
+// systemEvents returns texts of ev_system events written to connection
+func systemEvents(conn *testConn) []string {
+	texts := make([]string, 0)
+	for _, message := range conn.Messages {
+		var m struct {
+			Action string      `json:"action"`
+			Data   EvSrvSystem `json:"data"`
+		}
+		if json.Unmarshal([]byte(message), &m) == nil && m.Action == "ev_system" {
+			texts = append(texts, m.Data.Text)
+		}
+	}
+	return texts
+}
+
+// TestServerNotify checks targets of system notifications
+func TestServerNotify(t *testing.T) {
+	gServer = newServer()
+	conns := make([]*testConn, 3)
+	clients := make([]*Client, 3)
+	for i, uid := range []string{"u0", "u1", "u2"} {
+		conns[i] = newTestConn()
+		clients[i] = NewTestClient(conns[i])
+		gServer.Clients[uid] = clients[i]
+	}
+	clients[2].Flush()
+	clients[2].Disconnect()
+
+	if n, _ := gServer.Notify(SystemNotification{Text: "all"}); n != 3 {
+		t.Errorf("Notification for all is sent to %d users", n)
+	}
+	if n, _ := gServer.Notify(SystemNotification{Text: "online", Target: NotifyOnline}); n != 2 {
+		t.Errorf("Notification for online is sent to %d users", n)
+	}
+	n, _ := gServer.Notify(SystemNotification{Text: "users", Target: NotifyUsers, Users: []string{"u1", "u2", "unknown"}})
+	if n != 2 {
+		t.Errorf("Notification for list is sent to %d users", n)
+	}
+	if _, err := gServer.Notify(SystemNotification{Text: "bad", Target: "bad"}); err == nil {
+		t.Errorf("Invalid target is accepted")
+	}
+
+	for _, c := range clients {
+		c.Flush()
+	}
+	if texts := systemEvents(conns[0]); len(texts) != 2 || texts[0] != "all" || texts[1] != "online" {
+		t.Errorf("Invalid notifications of online user %v", texts)
+	}
+	if texts := systemEvents(conns[1]); len(texts) != 3 {
+		t.Errorf("Invalid notifications of online user %v", texts)
+	}
+	// Offline user gets notifications after auth
+	if len(systemEvents(conns[2])) != 0 || len(clients[2].offlineMessages) != 2 {
+		t.Errorf("Notifications are not kept for offline user")
+	}
+}
+
+// TestClientMOTD checks welcome message with message of the day
+func TestClientMOTD(t *testing.T) {
+	config := DefaultConfig()
+	config.MOTD = "Server is updated"
+	gServer = newServerWithConfig(config)
+
+	server, client := net.Pipe()
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(time.Second))
+	listenTest(t, NewClient(server))
+
+	var m SrvWelcomeMessage
+	if err := json.NewDecoder(client).Decode(&m); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if m.Action != "welcome" || m.Message != "Server is updated" {
+		t.Errorf("Invalid welcome message %+v", m)
+	}
+}
@@ -4,16 +4,17 @@ import (
 	"crypto/md5"
 	"encoding/hex"
 	"encoding/json"
-	"log"
+	"os"
 )
 
 // CheckError checks errors and print log
 func CheckError(err error, message string, fatal bool) bool {
 	if err != nil {
 		if fatal {
-			log.Fatalln(message + ": " + err.Error())
+			logger(LogServer).Error(message, "err", err)
+			os.Exit(1)
 		} else {
-			log.Println(message + ": " + err.Error())
+			logger(LogServer).Warn(message, "err", err)
 		}
 	}
 	return err == nil
@@ -33,6 +34,11 @@ type CltRequest struct {
 	RawData json.RawMessage `json:"data,omitempty"`
 }
 
+type CltSetRole struct {
+	User string `json:"uid"`
+	Role Role   `json:"role"`
+}
+
 type CltAuth struct {
 	Login string `json:"login"`
 	Pass  string `json:"pass"`
@@ -81,9 +87,16 @@ type AttachData struct {
 	Data string `json:"data"`
 }
 
+type MessageLinks struct {
+	ReplyTo       string `json:"reply_to,omitempty"`
+	ForwardedFrom string `json:"forwarded_from,omitempty"`
+}
+
 type CltMessage struct {
-	Body   string     `json:"body"`
-	Attach AttachData `json:"attach,omitempty"`
+	Body      string     `json:"body"`
+	Attach    AttachData `json:"attach,omitempty"`
+	Envelopes []Envelope `json:"envelopes,omitempty"`
+	MessageLinks
 	CltUidReq
 }
 
@@ -94,8 +107,82 @@ type Contact struct {
 	MyID  string `json:"myid,omitempty"`
 }
 
+type CltEditMessage struct {
+	Mid  string `json:"mid"`
+	Body string `json:"body"`
+	CltBaseReq
+}
+
+type CltUploadKeys struct {
+	Device       string       `json:"device"`
+	IdentityKey  string       `json:"identity_key"`
+	SignedPrekey SignedPrekey `json:"signed_prekey"`
+	Prekeys      []Prekey     `json:"prekeys,omitempty"`
+}
+
+type CltDeviceReq struct {
+	Device string `json:"device"`
+}
+
+type CltMidReq struct {
+	Mid string `json:"mid"`
+	CltBaseReq
+}
+
+type CltReact struct {
+	Mid    string `json:"mid"`
+	Emoji  string `json:"emoji"`
+	Remove bool   `json:"remove,omitempty"`
+	CltBaseReq
+}
+
+type CltHistory struct {
+	User   string `json:"uid"`
+	Before string `json:"before,omitempty"`
+	Limit  int    `json:"limit,omitempty"`
+	CltBaseReq
+}
+
+type CltSearch struct {
+	Query  string `json:"query"`
+	User   string `json:"uid,omitempty"`
+	After  int    `json:"after,omitempty"`
+	Before int    `json:"before,omitempty"`
+	Mime   string `json:"mime,omitempty"`
+	Limit  int    `json:"limit,omitempty"`
+	CltBaseReq
+}
+
+type CltVerify struct {
+	Kind string `json:"kind"` // email or phone
+	Code string `json:"code,omitempty"`
+	CltBaseReq
+}
+
+type CltSetConversation struct {
+	User       string `json:"uid"`
+	Pinned     *bool  `json:"pinned,omitempty"`
+	Archived   *bool  `json:"archived,omitempty"`
+	MutedUntil *int   `json:"muted_until,omitempty"` // -1 - forever, 0 - unmute
+	CltBaseReq
+}
+
+type CltMarkRead struct {
+	User string `json:"uid"`
+	Mid  string `json:"mid,omitempty"`
+	CltBaseReq
+}
+
+type CltSync struct {
+	Seq   int `json:"seq"`
+	Limit int `json:"limit,omitempty"`
+	CltBaseReq
+}
+
 type CltImport struct {
 	Contacts []Contact `json:"contacts"`
+	Format   string    `json:"format,omitempty"`
+	Payload  string    `json:"payload,omitempty"`
 	CltBaseReq
 }
 
@@ -113,11 +200,30 @@ type SrvWelcomeMessage struct {
 	Time    int    `json:"time"`
 }
 
+type SrvPingData struct {
+	Time int `json:"time"`
+}
+
+type RoleData struct {
+	Uid  string `json:"uid"`
+	Role Role   `json:"role"`
+}
+
+type SrvRoles struct {
+	Roles []RoleData `json:"list"`
+	SrvStatusMessage
+}
+
 type SrvStatusMessage struct {
 	Status int    `json:"status"`
 	Error  string `json:"error"`
 }
 
+type SrvRateLimitedMessage struct {
+	RetryAfter int `json:"retry_after"`
+	SrvStatusMessage
+}
+
 type SrvAddChannelMessage struct {
 	ChannelID string `json:"chid"`
 	SrvStatusMessage
@@ -138,11 +244,66 @@ type ChannelData struct {
 }
 
 type SrvUserInfo struct {
-	Nick       string `json:"nick"`
-	UserStatus string `json:"user_status"`
-	Email      string `json:"email"`
-	Phone      string `json:"phone"`
-	Avatar     string `json:"picture"`
+	Nick          string `json:"nick"`
+	UserStatus    string `json:"user_status"`
+	Email         string `json:"email"`
+	Phone         string `json:"phone"`
+	Avatar        string `json:"picture"`
+	Role          Role   `json:"role"`
+	EmailVerified bool   `json:"email_verified,omitempty"`
+	PhoneVerified bool   `json:"phone_verified,omitempty"`
+	SrvStatusMessage
+}
+
+type SrvVerify struct {
+	Kind    string `json:"kind"`
+	Address string `json:"address"`
+	SrvStatusMessage
+}
+
+type MessagePreview struct {
+	Mid       string `json:"mid"`
+	From      string `json:"from"`
+	Body      string `json:"body"`
+	Mime      string `json:"mime,omitempty"`
+	Time      int    `json:"time"`
+	Edited    int    `json:"edited,omitempty"`
+	Encrypted bool   `json:"encrypted,omitempty"`
+}
+
+type ConversationData struct {
+	Uid        string          `json:"uid"`
+	Nick       string          `json:"nick"`
+	Last       *MessagePreview `json:"last,omitempty"`
+	Unread     int             `json:"unread"`
+	ReadMid    string          `json:"read_mid,omitempty"`
+	Pinned     bool            `json:"pinned"`
+	Archived   bool            `json:"archived"`
+	Muted      bool            `json:"muted"`
+	MutedUntil int             `json:"muted_until,omitempty"`
+}
+
+type SrvConversations struct {
+	List []ConversationData `json:"list"`
+	SrvStatusMessage
+}
+
+type SrvConversation struct {
+	ConversationData
+	SrvStatusMessage
+}
+
+type UnreadData struct {
+	Uid    string `json:"uid"`
+	Unread int    `json:"unread"`
+}
+
+type SrvSync struct {
+	Events []SyncEvent  `json:"events"`
+	Seq    int          `json:"seq"`
+	More   bool         `json:"more"`
+	Reset  bool         `json:"reset"`
+	Unread []UnreadData `json:"unread"`
 	SrvStatusMessage
 }
 
@@ -153,6 +314,7 @@ type UserData struct {
 	Phone  string `json:"phone"`
 	Avatar string `json:"picture"`
 	MyID   string `json:"myid,omitempty"`
+	Bot    bool   `json:"bot,omitempty"`
 }
 
 type SrvListOfUsers struct {
@@ -160,18 +322,54 @@ type SrvListOfUsers struct {
 	SrvStatusMessage
 }
 
+type ReactionData struct {
+	Emoji string `json:"emoji"`
+	Count int    `json:"count"`
+}
+
 type MessageData struct {
-	Mid  string `json:"mid"`
-	From string `json:"from"`
-	Nick string `json:"nick"`
-	Body string `json:"body"`
-	Time int    `json:"time"`
+	Mid           string         `json:"mid"`
+	From          string         `json:"from"`
+	To            string         `json:"to"`
+	Nick          string         `json:"nick"`
+	Body          string         `json:"body"`
+	Time          int            `json:"time"`
+	Attach        AttachData     `json:"attach"`
+	ReplyTo       string         `json:"reply_to,omitempty"`
+	ForwardedFrom *ForwardData   `json:"forwarded_from,omitempty"`
+	Edited        int            `json:"edited,omitempty"`
+	Reactions     []ReactionData `json:"reactions,omitempty"`
+	Envelopes     []Envelope     `json:"envelopes,omitempty"`
+}
+
+type SrvUploadKeys struct {
+	Prekeys int `json:"prekeys"`
+	SrvStatusMessage
+}
+
+type KeyBundle struct {
+	Device       string       `json:"device"`
+	IdentityKey  string       `json:"identity_key"`
+	SignedPrekey SignedPrekey `json:"signed_prekey"`
+	Prekey       *Prekey      `json:"prekey,omitempty"`
+}
+
+type SrvKeys struct {
+	User    string      `json:"uid"`
+	Devices []KeyBundle `json:"devices"`
+	SrvStatusMessage
+}
+
+type SrvHistory struct {
+	Messages []MessageData `json:"list"`
+	SrvStatusMessage
 }
 
 /*
 {
 	"action":"ev_message",
 	"data":{
+		"mid":"MESSAGE_ID",
 		"from":"USER_ID",
 		"nick":"NICKNAME",
 		"body":"TEXT_OF_MESSAGE",
@@ -179,14 +377,76 @@ type MessageData struct {
 		"attach": {
 			"mime":"MIME_TYPE_OF_ATTACH",
 			"data":"BASE64_OF_ATTACH"
-		}
+		},
+		"reply_to":"MESSAGE_ID",
+		"forwarded_from": {
+			"mid":"MESSAGE_ID",
+			"from":"USER_ID",
+			"nick":"NICKNAME"
+		},
+		"envelopes":[{
+			"uid":"USER_ID",
+			"device":"DEVICE_ID",
+			"type":TYPE_OF_CIPHERTEXT,
+			"ciphertext":"BASE64_OF_CIPHERTEXT"
+		}]
 	}
 }
 */
 type EvSrvMessage struct {
-	From   string     `json:"from"`
-	Nick   string     `json:"nick"`
-	Body   string     `json:"body"`
-	Time   int        `json:"time"`
-	Attach AttachData `json:"attach,omitempty"`
+	Mid           string       `json:"mid"`
+	From          string       `json:"from"`
+	Nick          string       `json:"nick"`
+	Body          string       `json:"body"`
+	Time          int          `json:"time"`
+	Attach        AttachData   `json:"attach,omitempty"`
+	ReplyTo       string       `json:"reply_to,omitempty"`
+	ForwardedFrom *ForwardData `json:"forwarded_from,omitempty"`
+	Envelopes     []Envelope   `json:"envelopes,omitempty"`
+}
+
+type ForwardData struct {
+	Mid  string `json:"mid"`
+	From string `json:"from"`
+	Nick string `json:"nick"`
+}
+
+type EvSrvSystem struct {
+	Id   string `json:"id"`
+	Kind string `json:"kind"`
+	Text string `json:"text"`
+	Time int    `json:"time"`
+}
+
+type EvSrvMessageEdited struct {
+	Mid  string `json:"mid"`
+	From string `json:"from"`
+	Body string `json:"body"`
+	Time int    `json:"time"`
+}
+
+type EvSrvMessageDeleted struct {
+	Mid  string `json:"mid"`
+	From string `json:"from"`
+}
+
+type EvSrvContact struct {
+	Uid string `json:"uid"`
+}
+
+type EvSrvProfile struct {
+	Uid        string `json:"uid"`
+	Nick       string `json:"nick"`
+	UserStatus string `json:"user_status"`
+	Email      string `json:"email"`
+	Phone      string `json:"phone"`
+	Avatar     string `json:"picture"`
+}
+
+type EvSrvReaction struct {
+	Mid     string `json:"mid"`
+	From    string `json:"from"`
+	Emoji   string `json:"emoji"`
+	Removed bool   `json:"removed"`
+	Count   int    `json:"count"`
 }
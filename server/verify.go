@@ -0,0 +1,257 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sync"
+	"time"
+)
+
+// Kinds of verified contacts
+const (
+	VerifyEmail = "email"
+	VerifyPhone = "phone"
+)
+
+// Count of digits of verification code
+const verifyCodeDigits = 6
+
+// VerificationSender delivers verification codes by email or SMS
+type VerificationSender interface {
+	SendCode(kind string, address string, code string) error
+}
+
+// LocalSender keeps codes in memory instead of delivery. It is a stand-in for tests.
+type LocalSender struct {
+	mutex sync.Mutex
+	codes map[string]string // map key - address; val - last code
+}
+
+// NewLocalSender is constructor of LocalSender
+func NewLocalSender() *LocalSender {
+	return &LocalSender{codes: make(map[string]string)}
+}
+
+// SendCode stores code of address
+func (s *LocalSender) SendCode(kind string, address string, code string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.codes[address] = code
+	return nil
+}
+
+// Code returns last code sent to address
+func (s *LocalSender) Code(address string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.codes[address]
+}
+
+// WriterSender prints codes, e.g. to console of server started for development
+type WriterSender struct {
+	mutex sync.Mutex
+	w     io.Writer
+}
+
+// NewWriterSender is constructor of WriterSender
+func NewWriterSender(w io.Writer) *WriterSender {
+	return &WriterSender{w: w}
+}
+
+// SendCode writes line with code
+func (s *WriterSender) SendCode(kind string, address string, code string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, err := fmt.Fprintf(s.w, "Verification code of %s %s: %s\n", kind, address, code)
+	return err
+}
+
+// pendingCode is a code waiting for confirmation
+type pendingCode struct {
+	address  string
+	code     string
+	expires  time.Time
+	attempts int // Failed attempts
+}
+
+// Verifier issues verification codes and checks them
+type Verifier struct {
+	mutex    sync.Mutex
+	sender   VerificationSender
+	ttl      time.Duration
+	attempts int                     // Max failed attempts for one code (0 - no limit)
+	pending  map[string]*pendingCode // map key - uid and kind
+}
+
+// NewVerifier is constructor of Verifier
+func NewVerifier(sender VerificationSender, ttl time.Duration, attempts int) *Verifier {
+	return &Verifier{
+		sender:   sender,
+		ttl:      ttl,
+		attempts: attempts,
+		pending:  make(map[string]*pendingCode),
+	}
+}
+
+// newCode generates random numeric code
+func newCode() (string, error) {
+	max := big.NewInt(1)
+	for i := 0; i < verifyCodeDigits; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", verifyCodeDigits, n), nil
+}
+
+// Start sends new code to address, previous code of this kind becomes invalid
+func (v *Verifier) Start(uid string, kind string, address string) error {
+	code, err := newCode()
+	if err != nil {
+		return err
+	}
+	p := &pendingCode{address: address, code: code}
+	if v.ttl > 0 {
+		p.expires = time.Now().Add(v.ttl)
+	}
+	v.mutex.Lock()
+	v.pending[uid+"/"+kind] = p
+	v.mutex.Unlock()
+
+	if err := v.sender.SendCode(kind, address, code); err != nil {
+		v.mutex.Lock()
+		if v.pending[uid+"/"+kind] == p {
+			delete(v.pending, uid+"/"+kind)
+		}
+		v.mutex.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Check checks code and returns confirmed address
+func (v *Verifier) Check(uid string, kind string, code string) (string, int, error) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	key := uid + "/" + kind
+	p, ok := v.pending[key]
+	switch {
+	case !ok:
+		return "", ErrInvalidData, errors.New("Nothing to verify")
+	case !p.expires.IsZero() && time.Now().After(p.expires):
+		delete(v.pending, key)
+		return "", ErrTimeExpired, errors.New("Code is expired")
+	case subtle.ConstantTimeCompare([]byte(code), []byte(p.code)) != 1:
+		p.attempts++
+		if v.attempts > 0 && p.attempts >= v.attempts {
+			delete(v.pending, key)
+			return "", ErrAccessDenied, errors.New("Too many attempts, request new code")
+		}
+		return "", ErrInvalidData, errors.New("Invalid code")
+	}
+	delete(v.pending, key)
+	return p.address, ErrOK, nil
+}
+
+// Cancel removes code of kind
+func (v *Verifier) Cancel(uid string, kind string) {
+	v.mutex.Lock()
+	delete(v.pending, uid+"/"+kind)
+	v.mutex.Unlock()
+}
+
+// Remove removes all codes of user
+func (v *Verifier) Remove(uid string) {
+	v.Cancel(uid, VerifyEmail)
+	v.Cancel(uid, VerifyPhone)
+}
+
+// startVerification sends code to new email or phone of user. Every sent code is
+// limited by "sendcode" limit, also codes sent on change of profile.
+func (s *MessageServer) startVerification(c *Client, kind string, address string) error {
+	if ok, wait := s.limiter.Allow("sendcode", c.login, 1); !ok {
+		c.logger(LogServer).Warn("Verification code is rate limited", "kind", kind)
+		return &RateLimitError{Wait: wait}
+	}
+	err := s.verifier.Start(c.login, kind, address)
+	if err != nil {
+		c.logger(LogServer).Warn("Can't send verification code", "kind", kind, "err", err)
+		return err
+	}
+	c.logger(LogServer).Info("Verification code is sent", "kind", kind)
+	return nil
+}
+
+// SendCode user requests new code for his unverified email or phone
+func (s *MessageServer) SendCode(c *Client, kind string) {
+	if kind != VerifyEmail && kind != VerifyPhone {
+		c.Error("sendcode", "Unknown kind", ErrInvalidData, false)
+		return
+	}
+	address, verified := c.address(kind)
+	if address == "" || verified {
+		c.Error("sendcode", "Nothing to verify", ErrInvalidData, false)
+		return
+	}
+	if kind == VerifyPhone {
+		if _, valid := s.normalizePhone(address); !valid {
+			c.Error("sendcode", "Invalid phone", ErrInvalidData, false)
+			return
+		}
+	} else if _, valid := NormalizeEmail(address); !valid {
+		c.Error("sendcode", "Invalid email", ErrInvalidData, false)
+		return
+	}
+	if err := s.startVerification(c, kind, address); err != nil {
+		if e, ok := err.(*RateLimitError); ok {
+			c.RateLimited("sendcode", e.Wait, false)
+			return
+		}
+		c.Error("sendcode", "Can't send code", ErrInvalidData, false)
+		return
+	}
+	c.Ok("sendcode")
+}
+
+// Verify user confirms email or phone by code, after it other users find him by import
+func (s *MessageServer) Verify(c *Client, kind string, code string) {
+	address, status, err := s.verifier.Check(c.login, kind, code)
+	if err != nil {
+		c.Error("verify", err.Error(), status, false)
+		return
+	}
+	if current, _ := c.address(kind); address != current {
+		c.Error("verify", "Address is changed", ErrInvalidData, false)
+		return
+	}
+	// Another user could verify the same address while code was waiting
+	if status, err := s.Claim(kind, address, c.login); err != nil {
+		c.Error("verify", err.Error(), status, false)
+		return
+	}
+	c.setAddress(kind, address, true)
+	c.logger(LogServer).Info("Contact is verified", "kind", kind)
+
+	answer := SrvVerify{Kind: kind, Address: address}
+	answer.Status = ErrOK
+	answer.Error = "OK"
+	m, err := json.Marshal(struct {
+		Action string    `json:"action"`
+		Data   SrvVerify `json:"data"`
+	}{
+		Action: "verify",
+		Data:   answer,
+	})
+	if !c.CheckError(err, "Can't marhsal answer") {
+		return
+	}
+	c.Send(m)
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// verifyContacts confirms email and phone of user by codes from LocalSender of gServer
+func verifyContacts(c *Client) {
+	sender := gServer.verifier.sender.(*LocalSender)
+	if c.email != "" && !c.emailVerified {
+		gServer.Verify(c, VerifyEmail, sender.Code(c.email))
+	}
+	if c.phone != "" && !c.phoneVerified {
+		gServer.Verify(c, VerifyPhone, sender.Code(c.phone))
+	}
+}
+
+// failSender is a sender which can't deliver codes
+type failSender struct{}
+
+func (failSender) SendCode(kind string, address string, code string) error {
+	return errors.New("Gateway is down")
+}
+
+// TestServerVerify checks that only verified contacts are found
+func TestServerVerify(t *testing.T) {
+	sender := NewLocalSender()
+	config := DefaultConfig()
+	config.VerifySender = sender
+	config.VerifyAttempts = 2
+	gServer = newServerWithConfig(config)
+
+	conn := newTestConn()
+	c := NewTestClient(conn)
+	gServer.Register(c, "user", "pass", "user")
+	c.Auth("user", "pass")
+	c.SetUserInfo("", "user@mail.ru", "+79123456789", "")
+
+	code := sender.Code("user@mail.ru")
+	if len(code) != verifyCodeDigits || sender.Code("+79123456789") == "" {
+		t.Fatalf("Codes are not sent: %q", code)
+	}
+	if _, ok := gServer.FindUser("user@mail.ru", "+79123456789"); ok {
+		t.Errorf("Unverified user is found")
+	}
+
+	gServer.Verify(c, VerifyEmail, "wrong")
+	c.Flush()
+	if err := conn.CheckLastMessage(t, `{"action":"verify","data":{"status":3,"error":"Invalid code"}}`); err != nil {
+		t.Errorf("%v", err)
+	}
+	gServer.Verify(c, VerifyEmail, code)
+	c.Flush()
+	if err := conn.CheckLastMessage(t, `{"action":"verify","data":{"kind":"email","address":"user@mail.ru","status":0,"error":"OK"}}`); err != nil {
+		t.Errorf("%v", err)
+	}
+	if user, ok := gServer.FindUser("user@mail.ru", ""); !ok || user != c {
+		t.Errorf("Verified user is not found")
+	}
+	if _, ok := gServer.FindUser("", "+79123456789"); ok {
+		t.Errorf("User is found by unverified phone")
+	}
+
+	// Code is invalid after failed attempts, new one is requested
+	gServer.Verify(c, VerifyPhone, "wrong")
+	gServer.Verify(c, VerifyPhone, "wrong")
+	c.Flush()
+	if err := conn.CheckLastMessage(t, `{"action":"verify","data":{"status":10,"error":"Too many attempts, request new code"}}`); err != nil {
+		t.Errorf("%v", err)
+	}
+	gServer.SendCode(c, VerifyPhone)
+	gServer.Verify(c, VerifyPhone, sender.Code("+79123456789"))
+	if user, ok := gServer.FindUser("", "8 912 345-67-89"); !ok || user != c || !c.phoneVerified {
+		t.Errorf("User is not found by verified phone")
+	}
+
+	gServer.SendCode(c, VerifyPhone)
+	c.Flush()
+	if err := conn.CheckLastMessage(t, `{"action":"sendcode","data":{"status":3,"error":"Nothing to verify"}}`); err != nil {
+		t.Errorf("%v", err)
+	}
+
+	// New email has to be verified again
+	c.SetUserInfo("", "new@mail.ru", "", "")
+	if _, ok := gServer.FindUser("user@mail.ru", ""); ok || c.emailVerified {
+		t.Errorf("Old email is found")
+	}
+	if _, ok := gServer.FindUser("new@mail.ru", ""); ok {
+		t.Errorf("Unverified email is found")
+	}
+	// Code of old email is not valid for new one
+	gServer.Verify(c, VerifyEmail, code)
+	if c.emailVerified {
+		t.Errorf("Old code is accepted")
+	}
+}
+
+// TestVerifierExpired checks lifetime of codes and errors of sender
+func TestVerifierExpired(t *testing.T) {
+	v := NewVerifier(NewLocalSender(), time.Millisecond, 0)
+	if err := v.Start("user", VerifyEmail, "user@mail.ru"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	code := v.sender.(*LocalSender).Code("user@mail.ru")
+	if _, status, _ := v.Check("user", VerifyEmail, code); status != ErrTimeExpired {
+		t.Errorf("Expired code is accepted %v", status)
+	}
+
+	v = NewVerifier(failSender{}, time.Minute, 0)
+	if err := v.Start("user", VerifyPhone, "+79123456789"); err == nil {
+		t.Errorf("Error of sender is lost")
+	}
+	if _, status, _ := v.Check("user", VerifyPhone, ""); status != ErrInvalidData {
+		t.Errorf("Undelivered code is pending %v", status)
+	}
+}
+
+// TestServerVerifyLimit checks that codes sent on change of profile are limited too
+func TestServerVerifyLimit(t *testing.T) {
+	gServer = newServer()
+	sender := gServer.verifier.sender.(*LocalSender)
+	conn := newTestConn()
+	c := NewTestClient(conn)
+	c.Register("user", "pass", "user")
+
+	for i := 1; i <= 5; i++ {
+		c.SetUserInfo("", fmt.Sprintf("user%d@mail.ru", i), "", "")
+	}
+	if sender.Code("user3@mail.ru") == "" || sender.Code("user4@mail.ru") != "" || sender.Code("user5@mail.ru") != "" {
+		t.Errorf("Codes are not limited")
+	}
+	if email, _ := c.address(VerifyEmail); email != "user5@mail.ru" {
+		t.Errorf("Email is not changed '%s'", email)
+	}
+	gServer.SendCode(c, VerifyEmail)
+	c.Flush()
+	if err := conn.CheckLastMessage(t, `{"action":"sendcode","data":{"retry_after":60,"status":12,"error":"Too many requests"}}`); err != nil {
+		t.Errorf("%v", err)
+	}
+}
@@ -0,0 +1,295 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Events of webhooks
+const (
+	EventUserRegistered    = "user.registered"
+	EventUserAuthenticated = "user.authenticated"
+	EventMessageSent       = "message.sent"
+	EventContactAdded      = "contact.added"
+	EventAccountDeleted    = "account.deleted"
+)
+
+// Max count of events waiting for delivery to one webhook, new events are dropped
+const webhookQueueSize = 1000
+
+// WebhookConfig is a setting of one outgoing webhook
+type WebhookConfig struct {
+	Url    string   // Url for POST requests
+	Secret string   // Key of HMAC-SHA256 signature in header X-TM-Signature ("" - no signature)
+	Events []string // Names of events (empty - all events)
+
+	// message.sent is posted for messages to bots and for messages matching filters
+	Users    []string // Messages from or to these users
+	Keywords []string // Messages containing one of these words
+}
+
+// WebhookEvent is a body of webhook request
+type WebhookEvent struct {
+	Id    string      `json:"id"`
+	Event string      `json:"event"`
+	Time  int         `json:"time"`
+	Data  interface{} `json:"data"`
+}
+
+// Data of webhook events
+
+type WebhookUserData struct {
+	Uid  string `json:"uid"`
+	Nick string `json:"nick,omitempty"`
+	Ip   string `json:"ip,omitempty"`
+}
+
+type WebhookContactData struct {
+	Uid     string `json:"uid"`
+	Contact string `json:"contact"`
+}
+
+// delivery is an event waiting for posting
+type delivery struct {
+	id    string
+	event string
+	body  []byte
+}
+
+// webhook is a webhook with its delivery queue
+type webhook struct {
+	config WebhookConfig
+	events map[string]bool
+	queue  chan delivery
+}
+
+// wants checks if event has to be posted to webhook
+func (h *webhook) wants(event string, data interface{}, isBot func(string) bool) bool {
+	if len(h.events) > 0 && !h.events[event] {
+		return false
+	}
+	msg, ok := data.(MessageData)
+	if event != EventMessageSent || !ok {
+		return true
+	}
+	if isBot(msg.To) {
+		return true
+	}
+	for _, uid := range h.config.Users {
+		if msg.From == uid || msg.To == uid {
+			return true
+		}
+	}
+	body := strings.ToLower(msg.Body)
+	for _, word := range h.config.Keywords {
+		if word != "" && strings.Contains(body, strings.ToLower(word)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Webhooks posts server events to configured urls
+type Webhooks struct {
+	hooks   []*webhook
+	retries int           // Count of attempts of delivery
+	backoff time.Duration // Pause before second attempt, it doubles after each failure
+	client  *http.Client
+	sleep   func(time.Duration)
+	isBot   func(uid string) bool
+	lastID  int64
+}
+
+// NewWebhooks is constructor of Webhooks, it starts delivery goroutine for each webhook
+func NewWebhooks(config Config, isBot func(uid string) bool) *Webhooks {
+	w := &Webhooks{
+		isBot:   isBot,
+		retries: config.WebhookRetries,
+		backoff: config.WebhookBackoff,
+		client:  &http.Client{Timeout: config.WebhookTimeout},
+		sleep:   time.Sleep,
+	}
+	if w.retries < 1 {
+		w.retries = 1
+	}
+	for _, hc := range config.Webhooks {
+		// Webhooks of config are set by admin, so they may be in private network
+		if err := checkWebhookURL(hc.Url, true); err != nil {
+			logger(LogServer).Error("Invalid webhook", "url", hc.Url, "err", err)
+			continue
+		}
+		h := &webhook{
+			config: hc,
+			events: make(map[string]bool),
+			queue:  make(chan delivery, webhookQueueSize),
+		}
+		for _, event := range hc.Events {
+			h.events[event] = true
+		}
+		w.hooks = append(w.hooks, h)
+		go w.deliver(h)
+	}
+	return w
+}
+
+// Emit puts event to queues of webhooks which want it
+func (w *Webhooks) Emit(event string, data interface{}) {
+	if w == nil || len(w.hooks) == 0 {
+		return
+	}
+	d := delivery{
+		id:    strconv.FormatInt(atomic.AddInt64(&w.lastID, 1), 10),
+		event: event,
+	}
+	body, err := json.Marshal(WebhookEvent{
+		Id:    d.id,
+		Event: event,
+		Time:  int(time.Now().Unix()),
+		Data:  data,
+	})
+	if !CheckError(err, "Can't marhsal webhook event", false) {
+		return
+	}
+	d.body = body
+	for _, h := range w.hooks {
+		if !h.wants(event, data, w.isBot) {
+			continue
+		}
+		select {
+		case h.queue <- d:
+		default:
+			logger(LogServer).Warn("Webhook queue is full, event is dropped", "url", h.config.Url, "event", event)
+		}
+	}
+}
+
+// deliver posts events of webhook in order of emitting
+func (w *Webhooks) deliver(h *webhook) {
+	for d := range h.queue {
+		backoff := w.backoff
+		for attempt := 1; ; attempt++ {
+			err := w.post(h, d)
+			if err == nil {
+				break
+			}
+			logger(LogServer).Warn("Webhook failed", "url", h.config.Url, "attempt", attempt, "err", err)
+			if attempt >= w.retries {
+				break
+			}
+			w.sleep(backoff)
+			backoff *= 2
+		}
+	}
+}
+
+// webhookError is a failed response of webhook
+type webhookError struct {
+	code int
+}
+
+func (e *webhookError) Error() string {
+	return "Webhook answered " + strconv.Itoa(e.code)
+}
+
+// post sends one signed request to webhook
+func (w *Webhooks) post(h *webhook, d delivery) error {
+	req, err := http.NewRequest("POST", h.config.Url, bytes.NewReader(d.body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-TM-Event", d.event)
+	req.Header.Set("X-TM-Delivery", d.id)
+	if h.config.Secret != "" {
+		req.Header.Set("X-TM-Signature", SignWebhook(h.config.Secret, d.body))
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return &webhookError{code: resp.StatusCode}
+	}
+	return nil
+}
+
+// SignWebhook returns signature of webhook body: "sha256=" and hex of HMAC-SHA256
+func SignWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook checks signature of webhook body
+func VerifyWebhook(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(SignWebhook(secret, body)), []byte(signature))
+}
+
+// errPrivateAddress is an error of webhook to loopback or private network
+var errPrivateAddress = errors.New("Webhook to private address")
+
+// publicIP checks that ip is reachable from internet, not loopback, private or link-local one
+func publicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified())
+}
+
+// checkWebhookURL checks that url is http or https url with host. Without allowPrivate
+// all addresses of host have to be public, so webhook can't reach internal services.
+func checkWebhookURL(raw string, allowPrivate bool) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" || u.User != nil {
+		return errors.New("Invalid url")
+	}
+	if allowPrivate {
+		return nil
+	}
+	ips, err := net.LookupIP(u.Hostname())
+	if err != nil {
+		return err
+	}
+	for _, ip := range ips {
+		if !publicIP(ip) {
+			return errPrivateAddress
+		}
+	}
+	return nil
+}
+
+// newHookClient returns http client of webhooks. Without allowPrivate it checks address
+// of each connection too, because host may resolve to another address after checkWebhookURL.
+func newHookClient(timeout time.Duration, allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout}
+	if !allowPrivate {
+		dialer.Control = func(network string, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+				return errPrivateAddress
+			}
+			return nil
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookReceiver is a local receiver of webhooks
+type webhookReceiver struct {
+	server   *httptest.Server
+	secret   string
+	mutex    sync.Mutex
+	events   []WebhookEvent
+	failures int // Count of next requests answered with error
+	requests int
+}
+
+// newWebhookReceiver starts receiver which checks signatures with secret
+func newWebhookReceiver(t *testing.T, secret string) *webhookReceiver {
+	rcv := &webhookReceiver{secret: secret}
+	rcv.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rcv.mutex.Lock()
+		defer rcv.mutex.Unlock()
+
+		rcv.requests++
+		if !VerifyWebhook(secret, body, r.Header.Get("X-TM-Signature")) {
+			t.Errorf("Invalid signature of %s", body)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if rcv.failures > 0 {
+			rcv.failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var event WebhookEvent
+		json.Unmarshal(body, &event)
+		if r.Header.Get("X-TM-Event") != event.Event {
+			t.Errorf("Invalid event header %s", r.Header.Get("X-TM-Event"))
+		}
+		rcv.events = append(rcv.events, event)
+	}))
+	return rcv
+}
+
+// wait waits for count of received events and returns their names
+func (rcv *webhookReceiver) wait(count int) []string {
+	for i := 0; i < 100; i++ {
+		rcv.mutex.Lock()
+		n := len(rcv.events)
+		rcv.mutex.Unlock()
+		if n >= count {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	rcv.mutex.Lock()
+	defer rcv.mutex.Unlock()
+
+	names := make([]string, 0, len(rcv.events))
+	for _, event := range rcv.events {
+		names = append(names, event.Event)
+	}
+	return names
+}
+
+// newWebhookTestServer creates server with webhook to receiver
+func newWebhookTestServer(hook WebhookConfig) {
+	config := DefaultConfig()
+	config.Webhooks = []WebhookConfig{hook}
+	config.WebhookBackoff = time.Millisecond
+	config.WebhookRetries = 3
+	gServer = newServerWithConfig(config)
+}
+
+// TestWebhooksEvents checks events of users and filters of messages
+func TestWebhooksEvents(t *testing.T) {
+	rcv := newWebhookReceiver(t, "secret")
+	defer rcv.server.Close()
+	newWebhookTestServer(WebhookConfig{Url: rcv.server.URL, Secret: "secret", Keywords: []string{"deploy"}})
+
+	c1 := NewTestClient(newTestConn())
+	c2 := NewTestClient(newTestConn())
+	c1.Register("user1", "pass", "nick1")
+	c2.Register("user2", "pass", "nick2")
+	c1.AddContact("user2")
+	gServer.SendMessage(c1, "user2", "hello", AttachData{}, MessageLinks{}, nil)
+	gServer.SendMessage(c1, "user2", "Deploy is done", AttachData{}, MessageLinks{}, nil)
+	gServer.CreateBot("ci", "Build bot")
+	gServer.SendMessage(c1, "ci", "status", AttachData{}, MessageLinks{}, nil)
+	gServer.DeleteAccount("user2")
+
+	expected := []string{
+		EventUserRegistered, EventUserAuthenticated,
+		EventUserRegistered, EventUserAuthenticated,
+		EventContactAdded, EventMessageSent, EventMessageSent, EventAccountDeleted,
+	}
+	names := rcv.wait(len(expected))
+	if len(names) != len(expected) {
+		t.Fatalf("Invalid events %v", names)
+	}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Errorf("Invalid events %v waits %v", names, expected)
+			break
+		}
+	}
+	data := rcv.events[6].Data.(map[string]interface{})
+	if data["to"] != "ci" || data["body"] != "status" {
+		t.Errorf("Invalid data of message event %v", data)
+	}
+}
+
+// TestWebhooksRetries checks retries of failed deliveries
+func TestWebhooksRetries(t *testing.T) {
+	rcv := newWebhookReceiver(t, "key")
+	defer rcv.server.Close()
+	newWebhookTestServer(WebhookConfig{Url: rcv.server.URL, Secret: "key", Events: []string{EventAccountDeleted}})
+
+	rcv.failures = 2
+	gServer.Register(NewTestClient(newTestConn()), "user", "pass", "nick")
+	gServer.DeleteAccount("user")
+	if names := rcv.wait(1); len(names) != 1 || names[0] != EventAccountDeleted {
+		t.Errorf("Invalid events %v", names)
+	}
+	rcv.mutex.Lock()
+	defer rcv.mutex.Unlock()
+	if rcv.requests != 3 {
+		t.Errorf("Event is delivered in %d requests waits 3", rcv.requests)
+	}
+}
+
+// TestSignWebhook checks HMAC signature
+func TestSignWebhook(t *testing.T) {
+	body := []byte(`{"event":"user.registered"}`)
+	sign := SignWebhook("key", body)
+	if !VerifyWebhook("key", body, sign) || VerifyWebhook("other", body, sign) {
+		t.Errorf("Invalid signature %s", sign)
+	}
+}